@@ -0,0 +1,118 @@
+package discover
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// clientConfig Mozilla autoconfig 的 config-v1.1.xml, 只取收信服务器
+type clientConfig struct {
+	XMLName       xml.Name `xml:"clientConfig"`
+	EmailProvider struct {
+		ID              string           `xml:"id,attr"`
+		Domains         []string         `xml:"domain"`
+		IncomingServers []incomingServer `xml:"incomingServer"`
+	} `xml:"emailProvider"`
+}
+
+type incomingServer struct {
+	Type       string `xml:"type,attr"`
+	Hostname   string `xml:"hostname"`
+	Port       int    `xml:"port"`
+	SocketType string `xml:"socketType"`
+	Username   string `xml:"username"`
+}
+
+// tlsMode autoconfig 的 socketType 对应的 TLS 模式
+func tlsMode(socketType string) string {
+	switch strings.ToUpper(strings.TrimSpace(socketType)) {
+	case "SSL":
+		return TLSImplicit
+	case "STARTTLS":
+		return TLSStartTLS
+	}
+	return TLSNone
+}
+
+// ParseAutoconfig 解析 autoconfig XML, 返回其中的 IMAP 服务器.
+// 有多个时优先直接 TLS, 其次 STARTTLS; 没有 IMAP 服务器时返回 nil
+func ParseAutoconfig(r io.Reader) (*Settings, error) {
+	var cfg clientConfig
+	if err := xml.NewDecoder(r).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("parse autoconfig: %w", err)
+	}
+	var best *Settings
+	rank := map[string]int{TLSImplicit: 0, TLSStartTLS: 1, TLSNone: 2}
+	for _, in := range cfg.EmailProvider.IncomingServers {
+		host := strings.TrimSpace(in.Hostname)
+		if !strings.EqualFold(in.Type, "imap") || host == "" {
+			continue
+		}
+		s := &Settings{
+			Host:     host,
+			Port:     in.Port,
+			TLS:      tlsMode(in.SocketType),
+			Username: strings.TrimSpace(in.Username),
+			Source:   SourceAutoconfig,
+			Provider: cfg.EmailProvider.ID,
+		}
+		if s.Port == 0 {
+			s.Port = 143
+			if s.TLS == TLSImplicit {
+				s.Port = 993
+			}
+		}
+		if best == nil || rank[s.TLS] < rank[best.TLS] {
+			best = s
+		}
+	}
+	return best, nil
+}
+
+// lookupAutoconfig 依次请求 AutoconfigURLs, 使用第一个含 IMAP 服务器的配置
+func (d *Discoverer) lookupAutoconfig(ctx context.Context, email, domain string) (*Settings, error) {
+	urls := d.AutoconfigURLs
+	if urls == nil {
+		urls = DefaultAutoconfigURLs
+	}
+	r := strings.NewReplacer("{domain}", domain, "{email}", url.QueryEscape(email))
+	var firstErr error
+	for _, u := range urls {
+		s, err := d.fetchAutoconfig(ctx, r.Replace(u))
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if s != nil {
+			return s, nil
+		}
+	}
+	return nil, firstErr
+}
+
+func (d *Discoverer) fetchAutoconfig(ctx context.Context, u string) (*Settings, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	// 配置文件很小, 限制读取的大小
+	return ParseAutoconfig(io.LimitReader(resp.Body, 1<<20))
+}
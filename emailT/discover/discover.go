@@ -0,0 +1,256 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TLS 模式
+const (
+	// TLSImplicit 连接后直接 TLS 握手, 一般为 993 端口
+	TLSImplicit = "tls"
+	// TLSStartTLS 明文连接后用 STARTTLS 升级, 一般为 143 端口
+	TLSStartTLS = "starttls"
+	// TLSNone 不加密
+	TLSNone = "none"
+)
+
+// 结果的来源
+const (
+	SourceProvider   = "provider"
+	SourceAutoconfig = "autoconfig"
+	SourceSRV        = "srv"
+	SourceMX         = "mx"
+)
+
+// ErrNotFound 所有方式都没有找到服务器配置
+var ErrNotFound = errors.New("discover: imap settings not found")
+
+// Settings IMAP 服务器配置
+type Settings struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	// TLS 见 TLSImplicit 等
+	TLS string `json:"tls"`
+	// Username 登录用户名, 可以含 %EMAILADDRESS%、%EMAILLOCALPART%、%EMAILDOMAIN%, 见 LoginName
+	Username string `json:"username,omitempty"`
+	// Source 结果的来源, 见 SourceProvider 等
+	Source string `json:"source"`
+	// Provider 内置服务商的名字, 或 autoconfig 中的服务商 id
+	Provider string `json:"provider,omitempty"`
+}
+
+// Addr 服务器地址 host:port, 即账号的 server
+func (s *Settings) Addr() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// LoginName 替换 Username 中的占位符, 为空时用完整的邮箱地址
+func (s *Settings) LoginName(email string) string {
+	if s.Username == "" {
+		return email
+	}
+	local, domain := splitAddress(email)
+	return strings.NewReplacer(
+		"%EMAILADDRESS%", email,
+		"%EMAILLOCALPART%", local,
+		"%EMAILDOMAIN%", domain,
+	).Replace(s.Username)
+}
+
+// Resolver DNS 查询, *net.Resolver 实现了这个接口, 测试时可以替换
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// DefaultAutoconfigURLs Thunderbird 查询 autoconfig 的地址, {domain} 和 {email} 为占位符
+var DefaultAutoconfigURLs = []string{
+	"https://autoconfig.{domain}/mail/config-v1.1.xml?emailaddress={email}",
+	"https://{domain}/.well-known/autoconfig/mail/config-v1.1.xml",
+	"https://autoconfig.thunderbird.net/v1.1/{domain}",
+}
+
+// Discoverer 根据邮箱地址查找 IMAP 服务器. 依次查询内置服务商、autoconfig、
+// DNS SRV 记录和 MX 记录, 使用第一个找到的结果. 零值可用
+type Discoverer struct {
+	// Providers 为空时使用 Providers
+	Providers []Provider
+	// Resolver 为空时使用 net.DefaultResolver
+	Resolver Resolver
+	// HTTPClient 为空时使用 10 秒超时的客户端
+	HTTPClient *http.Client
+	// AutoconfigURLs 为空时使用 DefaultAutoconfigURLs
+	AutoconfigURLs []string
+}
+
+// Default 默认的 Discoverer
+var Default = &Discoverer{}
+
+// Discover 用默认的 Discoverer 查找邮箱地址的 IMAP 服务器
+func Discover(ctx context.Context, email string) (*Settings, error) {
+	return Default.Discover(ctx, email)
+}
+
+// Discover 查找邮箱地址的 IMAP 服务器, 优先返回直接 TLS 的结果. 没有找到时返回 ErrNotFound,
+// 错误中带有各个方式失败的原因
+func (d *Discoverer) Discover(ctx context.Context, email string) (*Settings, error) {
+	_, domain := splitAddress(email)
+	if domain == "" {
+		return nil, fmt.Errorf("discover: invalid email address %q", email)
+	}
+	if s := d.lookupProvider(domain); s != nil {
+		return s, nil
+	}
+	var reasons []string
+	lookups := []struct {
+		name   string
+		lookup func(ctx context.Context, email, domain string) (*Settings, error)
+	}{
+		{SourceAutoconfig, d.lookupAutoconfig},
+		{SourceSRV, d.lookupSRV},
+		{SourceMX, d.lookupMX},
+	}
+	// 找到的不是直接 TLS 时继续查找, 都没有时才返回第一个结果
+	var found *Settings
+	for _, l := range lookups {
+		s, err := l.lookup(ctx, email, domain)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			reasons = append(reasons, l.name+": "+err.Error())
+			continue
+		}
+		if s != nil && s.TLS == TLSImplicit {
+			return s, nil
+		}
+		if found == nil {
+			found = s
+		}
+	}
+	if found != nil {
+		return found, nil
+	}
+	if len(reasons) == 0 {
+		return nil, fmt.Errorf("%w for %s", ErrNotFound, domain)
+	}
+	return nil, fmt.Errorf("%w for %s (%s)", ErrNotFound, domain, strings.Join(reasons, "; "))
+}
+
+func (d *Discoverer) providers() []Provider {
+	if d.Providers != nil {
+		return d.Providers
+	}
+	return Providers
+}
+
+func (d *Discoverer) resolver() Resolver {
+	if d.Resolver != nil {
+		return d.Resolver
+	}
+	return net.DefaultResolver
+}
+
+func (d *Discoverer) httpClient() *http.Client {
+	if d.HTTPClient != nil {
+		return d.HTTPClient
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// lookupProvider 邮箱域名就是内置服务商的域名
+func (d *Discoverer) lookupProvider(domain string) *Settings {
+	for _, p := range d.providers() {
+		for _, dom := range p.Domains {
+			if strings.EqualFold(dom, domain) {
+				return p.settings(SourceProvider)
+			}
+		}
+	}
+	return nil
+}
+
+// lookupSRV 按 RFC 6186 查询 _imaps._tcp 和 _imap._tcp 记录,
+// 目标为 "." 表示域名不提供该服务
+func (d *Discoverer) lookupSRV(ctx context.Context, email, domain string) (*Settings, error) {
+	var firstErr error
+	for _, srv := range []struct{ service, tls string }{
+		{"imaps", TLSImplicit},
+		{"imap", TLSStartTLS},
+	} {
+		_, addrs, err := d.resolver().LookupSRV(ctx, srv.service, "tcp", domain)
+		if err != nil {
+			if firstErr == nil && !isNotFound(err) {
+				firstErr = err
+			}
+			continue
+		}
+		sort.SliceStable(addrs, func(i, j int) bool {
+			if addrs[i].Priority != addrs[j].Priority {
+				return addrs[i].Priority < addrs[j].Priority
+			}
+			return addrs[i].Weight > addrs[j].Weight
+		})
+		for _, a := range addrs {
+			host := strings.TrimSuffix(a.Target, ".")
+			if host == "" || a.Port == 0 {
+				continue
+			}
+			return &Settings{Host: host, Port: int(a.Port), TLS: srv.tls, Source: SourceSRV}, nil
+		}
+	}
+	return nil, firstErr
+}
+
+// lookupMX 域名的 MX 指向内置服务商的企业邮箱时使用该服务商, 按 MX 优先级匹配
+func (d *Discoverer) lookupMX(ctx context.Context, email, domain string) (*Settings, error) {
+	mxs, err := d.resolver().LookupMX(ctx, domain)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.SliceStable(mxs, func(i, j int) bool { return mxs[i].Pref < mxs[j].Pref })
+	for _, mx := range mxs {
+		host := strings.ToLower(strings.TrimSuffix(mx.Host, "."))
+		for _, p := range d.providers() {
+			for _, suffix := range p.MX {
+				if host == suffix || strings.HasSuffix(host, "."+suffix) {
+					return p.settings(SourceMX), nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (p *Provider) settings(source string) *Settings {
+	s := p.Settings
+	s.Source = source
+	s.Provider = p.Name
+	return &s
+}
+
+// splitAddress 拆分邮箱地址, 域名转为小写
+func splitAddress(email string) (local, domain string) {
+	i := strings.LastIndex(email, "@")
+	if i <= 0 || i == len(email)-1 {
+		return email, ""
+	}
+	return email[:i], strings.ToLower(strings.TrimSuffix(email[i+1:], "."))
+}
+
+// isNotFound 域名没有这类记录
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
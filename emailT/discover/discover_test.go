@@ -0,0 +1,318 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeResolver 按域名返回固定记录的 Resolver, 没有记录时返回 NXDOMAIN
+type fakeResolver struct {
+	srv map[string][]*net.SRV
+	mx  map[string][]*net.MX
+
+	mu      sync.Mutex
+	queries []string
+}
+
+func (r *fakeResolver) record(q string) {
+	r.mu.Lock()
+	r.queries = append(r.queries, q)
+	r.mu.Unlock()
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	q := "_" + service + "._" + proto + "." + name
+	r.record("srv " + q)
+	addrs, ok := r.srv[q]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: q, IsNotFound: true}
+	}
+	// 返回副本, 排序不影响下一次查询
+	out := make([]*net.SRV, len(addrs))
+	for i, a := range addrs {
+		c := *a
+		out[i] = &c
+	}
+	return q, out, nil
+}
+
+func (r *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	r.record("mx " + name)
+	mxs, ok := r.mx[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	out := make([]*net.MX, len(mxs))
+	for i, m := range mxs {
+		c := *m
+		out[i] = &c
+	}
+	return out, nil
+}
+
+// autoconfigXML 只有一个收信服务器的 autoconfig
+func autoconfigXML(typ, host string, port int, socketType string) string {
+	return fmt.Sprintf(`<?xml version="1.0"?>
+<clientConfig version="1.1">
+  <emailProvider id="%s">
+    <domain>%s</domain>
+    <incomingServer type="%s">
+      <hostname>%s</hostname>
+      <port>%d</port>
+      <socketType>%s</socketType>
+      <username>%%EMAILADDRESS%%</username>
+    </incomingServer>
+  </emailProvider>
+</clientConfig>`, host, host, typ, host, port, socketType)
+}
+
+// newAutoconfigServer 按域名返回 configs 中的 autoconfig, 其它域名 404
+func newAutoconfigServer(t *testing.T, configs map[string]string) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domain := strings.TrimPrefix(r.URL.Path, "/")
+		mu.Lock()
+		requests = append(requests, domain+" "+r.URL.Query().Get("email"))
+		mu.Unlock()
+		cfg, ok := configs[domain]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, cfg)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestDiscoverOrder(t *testing.T) {
+	res := &fakeResolver{
+		srv: map[string][]*net.SRV{
+			"_imaps._tcp.autoconfig.example": {{Target: "srv.autoconfig.example.", Port: 993}},
+			"_imaps._tcp.srv.example":        {{Target: "imap.srv.example.", Port: 993}},
+		},
+		mx: map[string][]*net.MX{
+			"autoconfig.example": {{Host: "mx1.mxhichina.com.", Pref: 5}},
+			"srv.example":        {{Host: "mx1.mxhichina.com.", Pref: 5}},
+			"mx.example":         {{Host: "mx1.mxhichina.com.", Pref: 5}},
+		},
+	}
+	web, requests := newAutoconfigServer(t, map[string]string{
+		"autoconfig.example": autoconfigXML("imap", "imap.autoconfig.example", 993, "SSL"),
+	})
+	d := &Discoverer{Resolver: res, HTTPClient: web.Client(), AutoconfigURLs: []string{web.URL + "/{domain}?email={email}"}}
+
+	tests := []struct {
+		email  string
+		source string
+		host   string
+		// queries 查找过程中的 DNS 查询, 后面的方式找到结果时不再查询
+		queries string
+	}{
+		{"hr@qq.com", SourceProvider, "imap.qq.com", "[]"},
+		{"hr@autoconfig.example", SourceAutoconfig, "imap.autoconfig.example", "[]"},
+		{"hr@srv.example", SourceSRV, "imap.srv.example", "[srv _imaps._tcp.srv.example]"},
+		{"hr@mx.example", SourceMX, "imap.qiye.aliyun.com",
+			"[srv _imaps._tcp.mx.example srv _imap._tcp.mx.example mx mx.example]"},
+	}
+	for _, tt := range tests {
+		res.queries = nil
+		s, err := d.Discover(context.Background(), tt.email)
+		if err != nil {
+			t.Errorf("%s: %v", tt.email, err)
+			continue
+		}
+		if s.Source != tt.source || s.Host != tt.host {
+			t.Errorf("%s: found %s from %s, want %s from %s", tt.email, s.Host, s.Source, tt.host, tt.source)
+		}
+		if got := fmt.Sprint(res.queries); got != tt.queries {
+			t.Errorf("%s: dns queries %s, want %s", tt.email, got, tt.queries)
+		}
+	}
+	if got := fmt.Sprint(*requests); !strings.Contains(got, "autoconfig.example hr@autoconfig.example") {
+		t.Errorf("autoconfig requests %s, want the email address in the query", got)
+	}
+
+	_, err := d.Discover(context.Background(), "hr@nothing.example")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown domain: err = %v, want ErrNotFound", err)
+	}
+	if _, err = d.Discover(context.Background(), "not-an-address"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("invalid address: err = %v", err)
+	}
+}
+
+func TestDiscoverPrefersImplicitTLS(t *testing.T) {
+	web, _ := newAutoconfigServer(t, map[string]string{
+		"starttls.example": autoconfigXML("imap", "mail.starttls.example", 143, "STARTTLS"),
+		"only.example":     autoconfigXML("imap", "mail.only.example", 143, "STARTTLS"),
+	})
+	res := &fakeResolver{srv: map[string][]*net.SRV{
+		"_imaps._tcp.starttls.example": {{Target: "imaps.starttls.example.", Port: 993}},
+	}}
+	d := &Discoverer{Resolver: res, HTTPClient: web.Client(), AutoconfigURLs: []string{web.URL + "/{domain}"}}
+
+	// autoconfig 只有 STARTTLS 时继续查 SRV
+	s, err := d.Discover(context.Background(), "hr@starttls.example")
+	if err != nil || s.Source != SourceSRV || s.Addr() != "imaps.starttls.example:993" {
+		t.Errorf("got %+v, %v; want imaps.starttls.example:993 from srv", s, err)
+	}
+	// 其它方式都没有找到时返回 STARTTLS 的结果
+	s, err = d.Discover(context.Background(), "hr@only.example")
+	if err != nil || s.Source != SourceAutoconfig || s.TLS != TLSStartTLS {
+		t.Errorf("got %+v, %v; want the starttls autoconfig", s, err)
+	}
+}
+
+func TestDiscoverReportsReasons(t *testing.T) {
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer web.Close()
+	d := &Discoverer{Resolver: &fakeResolver{}, HTTPClient: web.Client(), AutoconfigURLs: []string{web.URL + "/{domain}"}}
+	_, err := d.Discover(context.Background(), "hr@broken.example")
+	if !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "autoconfig: GET") {
+		t.Errorf("err = %v, want ErrNotFound with the autoconfig failure", err)
+	}
+}
+
+func TestLookupSRV(t *testing.T) {
+	tests := []struct {
+		name string
+		srv  map[string][]*net.SRV
+		want string
+	}{
+		{
+			name: "imaps preferred",
+			srv: map[string][]*net.SRV{
+				"_imaps._tcp.example.com": {{Target: "imaps.example.com.", Port: 993}},
+				"_imap._tcp.example.com":  {{Target: "imap.example.com.", Port: 143}},
+			},
+			want: "imaps.example.com:993 tls",
+		},
+		{
+			// 目标为 "." 表示不提供直接 TLS 的服务, 使用 STARTTLS
+			name: "dot target",
+			srv: map[string][]*net.SRV{
+				"_imaps._tcp.example.com": {{Target: ".", Port: 0}},
+				"_imap._tcp.example.com":  {{Target: "imap.example.com.", Port: 143}},
+			},
+			want: "imap.example.com:143 starttls",
+		},
+		{
+			// priority 小的优先, 相同时 weight 大的优先
+			name: "priority",
+			srv: map[string][]*net.SRV{
+				"_imaps._tcp.example.com": {
+					{Target: "backup.example.com.", Port: 993, Priority: 20, Weight: 100},
+					{Target: "light.example.com.", Port: 993, Priority: 10, Weight: 5},
+					{Target: "heavy.example.com.", Port: 993, Priority: 10, Weight: 50},
+				},
+			},
+			want: "heavy.example.com:993 tls",
+		},
+		{
+			name: "only dot targets",
+			srv: map[string][]*net.SRV{
+				"_imaps._tcp.example.com": {{Target: "."}},
+				"_imap._tcp.example.com":  {{Target: "."}},
+			},
+			want: "<nil>",
+		},
+	}
+	for _, tt := range tests {
+		d := &Discoverer{Resolver: &fakeResolver{srv: tt.srv}}
+		s, err := d.lookupSRV(context.Background(), "hr@example.com", "example.com")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got := "<nil>"
+		if s != nil {
+			got = s.Addr() + " " + s.TLS
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLookupMX(t *testing.T) {
+	tests := []struct {
+		mx   []*net.MX
+		want string
+	}{
+		{[]*net.MX{{Host: "mxw.mxhichina.com.", Pref: 5}}, "qiye.aliyun"},
+		{[]*net.MX{{Host: "MXBIZ1.QQ.COM.", Pref: 10}}, "exmail"},
+		// 只匹配完整的域名后缀
+		{[]*net.MX{{Host: "mx.notmxhichina.com.", Pref: 5}}, ""},
+		// 按优先级匹配
+		{[]*net.MX{{Host: "alt1.aspmx.l.google.com.", Pref: 20}, {Host: "example-com.mail.protection.outlook.com.", Pref: 10}}, "outlook"},
+		{[]*net.MX{{Host: "mail.example.com.", Pref: 10}}, ""},
+	}
+	for _, tt := range tests {
+		d := &Discoverer{Resolver: &fakeResolver{mx: map[string][]*net.MX{"example.com": tt.mx}}}
+		s, err := d.lookupMX(context.Background(), "hr@example.com", "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if s != nil {
+			got = s.Provider
+		}
+		if got != tt.want {
+			t.Errorf("mx %s: provider %q, want %q", tt.mx[0].Host, got, tt.want)
+		}
+	}
+}
+
+func TestParseAutoconfig(t *testing.T) {
+	const cfg = `<clientConfig version="1.1">
+  <emailProvider id="example.com">
+    <incomingServer type="pop3">
+      <hostname>pop.example.com</hostname><port>995</port><socketType>SSL</socketType>
+    </incomingServer>
+    <incomingServer type="imap">
+      <hostname>plain.example.com</hostname><port>143</port><socketType>plain</socketType>
+    </incomingServer>
+    <incomingServer type="imap">
+      <hostname>starttls.example.com</hostname><port>143</port><socketType>STARTTLS</socketType>
+    </incomingServer>
+    <incomingServer type="IMAP">
+      <hostname> tls.example.com </hostname><socketType>SSL</socketType>
+      <username>%EMAILLOCALPART%</username>
+    </incomingServer>
+  </emailProvider>
+</clientConfig>`
+	s, err := ParseAutoconfig(strings.NewReader(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s == nil || s.Addr() != "tls.example.com:993" || s.TLS != TLSImplicit || s.Provider != "example.com" {
+		t.Fatalf("got %+v, want tls.example.com:993 with implicit TLS", s)
+	}
+	if got := s.LoginName("hr@example.com"); got != "hr" {
+		t.Errorf("LoginName = %q, want hr", got)
+	}
+
+	// 没有直接 TLS 时用 STARTTLS, 不用明文
+	noTLS := strings.Replace(cfg, "<socketType>SSL</socketType>\n      <username>", "<socketType>plain</socketType>\n      <username>", 1)
+	if s, err = ParseAutoconfig(strings.NewReader(noTLS)); err != nil || s.Host != "starttls.example.com" {
+		t.Errorf("without SSL: got %+v, %v; want starttls.example.com", s, err)
+	}
+
+	if s, err = ParseAutoconfig(strings.NewReader(autoconfigXML("pop3", "pop.example.com", 995, "SSL"))); err != nil || s != nil {
+		t.Errorf("pop3 only: got %+v, %v; want nil", s, err)
+	}
+	if _, err = ParseAutoconfig(strings.NewReader("<clientConfig>")); err == nil {
+		t.Error("truncated xml parsed without error")
+	}
+}
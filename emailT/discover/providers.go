@@ -0,0 +1,77 @@
+package discover
+
+// Provider 内置的邮箱服务商
+type Provider struct {
+	Name string
+	// Domains 服务商自己的邮箱域名, 如 163.com
+	Domains []string
+	// MX 企业邮箱的 MX 主机后缀, 自有域名的 MX 指向这些主机时使用该服务商
+	MX       []string
+	Settings Settings
+}
+
+// Providers 内置的服务商列表, 先于 autoconfig 和 DNS 查询使用
+var Providers = []Provider{
+	{
+		Name:     "qq",
+		Domains:  []string{"qq.com", "foxmail.com", "vip.qq.com"},
+		Settings: Settings{Host: "imap.qq.com", Port: 993, TLS: TLSImplicit, Username: "%EMAILADDRESS%"},
+	},
+	{
+		// 腾讯企业邮箱
+		Name:     "exmail",
+		Domains:  []string{"exmail.qq.com"},
+		MX:       []string{"mxbiz1.qq.com", "mxbiz2.qq.com", "exmail.qq.com"},
+		Settings: Settings{Host: "imap.exmail.qq.com", Port: 993, TLS: TLSImplicit, Username: "%EMAILADDRESS%"},
+	},
+	{
+		Name:     "163",
+		Domains:  []string{"163.com"},
+		Settings: Settings{Host: "imap.163.com", Port: 993, TLS: TLSImplicit, Username: "%EMAILADDRESS%"},
+	},
+	{
+		Name:     "126",
+		Domains:  []string{"126.com"},
+		Settings: Settings{Host: "imap.126.com", Port: 993, TLS: TLSImplicit, Username: "%EMAILADDRESS%"},
+	},
+	{
+		Name:     "yeah",
+		Domains:  []string{"yeah.net"},
+		Settings: Settings{Host: "imap.yeah.net", Port: 993, TLS: TLSImplicit, Username: "%EMAILADDRESS%"},
+	},
+	{
+		// 网易企业邮箱
+		Name:     "qiye163",
+		MX:       []string{"qiye.163.com", "qiye163mx01.mxmail.netease.com", "qiye163mx02.mxmail.netease.com"},
+		Settings: Settings{Host: "imap.qiye.163.com", Port: 993, TLS: TLSImplicit, Username: "%EMAILADDRESS%"},
+	},
+	{
+		Name:     "aliyun",
+		Domains:  []string{"aliyun.com"},
+		Settings: Settings{Host: "imap.aliyun.com", Port: 993, TLS: TLSImplicit, Username: "%EMAILADDRESS%"},
+	},
+	{
+		// 阿里企业邮箱
+		Name:     "qiye.aliyun",
+		MX:       []string{"mxhichina.com", "qiye.aliyun.com"},
+		Settings: Settings{Host: "imap.qiye.aliyun.com", Port: 993, TLS: TLSImplicit, Username: "%EMAILADDRESS%"},
+	},
+	{
+		Name:     "sohu",
+		Domains:  []string{"sohu.com", "vip.sohu.com"},
+		MX:       []string{"mx.sohu.com"},
+		Settings: Settings{Host: "imap.sohu.com", Port: 993, TLS: TLSImplicit, Username: "%EMAILADDRESS%"},
+	},
+	{
+		Name:     "gmail",
+		Domains:  []string{"gmail.com", "googlemail.com"},
+		MX:       []string{"google.com", "googlemail.com"},
+		Settings: Settings{Host: "imap.gmail.com", Port: 993, TLS: TLSImplicit, Username: "%EMAILADDRESS%"},
+	},
+	{
+		Name:     "outlook",
+		Domains:  []string{"outlook.com", "hotmail.com", "live.com", "msn.com", "outlook.cn"},
+		MX:       []string{"protection.outlook.com", "olc.protection.outlook.com"},
+		Settings: Settings{Host: "outlook.office365.com", Port: 993, TLS: TLSImplicit, Username: "%EMAILADDRESS%"},
+	},
+}
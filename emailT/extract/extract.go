@@ -0,0 +1,409 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Candidate 字段名, 用于 Template.Fields
+const (
+	FieldName       = "name"
+	FieldPosition   = "position"
+	FieldPhone      = "phone"
+	FieldEmail      = "email"
+	FieldExperience = "experience"
+	FieldResumeURL  = "resume_url"
+)
+
+// ErrNoTemplate 没有与发件人和主题匹配的模板
+var ErrNoTemplate = errors.New("extract: no template for this sender")
+
+// Candidate 从招聘网站通知邮件中提取的候选人
+type Candidate struct {
+	// Source 使用的模板名称
+	Source   string `json:"source"`
+	Name     string `json:"name"`
+	Position string `json:"position,omitempty"`
+	Phone    string `json:"phone,omitempty"`
+	Email    string `json:"email,omitempty"`
+	// Experience 工作年限, 应届生为 0, 没有或无法识别时为 -1
+	Experience int `json:"experience"`
+	// ExperienceText 工作年限的原文, 如 "3年", "20年应届生"
+	ExperienceText string `json:"experience_text,omitempty"`
+	ResumeURL      string `json:"resume_url,omitempty"`
+	// Extra 模板中定义的其他字段, 如性别、学历、期望薪资
+	Extra map[string]string `json:"extra,omitempty"`
+}
+
+// Rule 取一个字段的规则. CSS 和 XPath 都为空时对整封邮件的文本使用 Regexp
+type Rule struct {
+	CSS   string `json:"css,omitempty"`
+	XPath string `json:"xpath,omitempty"`
+	// Attr 取元素的属性值, 为空时取文本. XPath 可以直接选择属性, 如 //a/@href
+	Attr string `json:"attr,omitempty"`
+	// Index 匹配到多个值时取第几个, 从 0 开始, 负数从末尾算起
+	Index int `json:"index,omitempty"`
+	// Regexp 对取到的值再匹配, 有分组时取第一个分组. Index 只计算匹配成功的值
+	Regexp string `json:"regexp,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Rules 一个字段的多条规则, 按顺序尝试. json 中可以写成单条规则
+type Rules []Rule
+
+// UnmarshalJSON 接受规则数组或单条规则
+func (rs *Rules) UnmarshalJSON(data []byte) error {
+	if d := bytes.TrimSpace(data); len(d) > 0 && d[0] == '{' {
+		var r Rule
+		if err := json.Unmarshal(d, &r); err != nil {
+			return err
+		}
+		*rs = Rules{r}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]Rule)(rs))
+}
+
+// Template 一个招聘网站通知邮件的提取模板
+type Template struct {
+	Name string `json:"name"`
+	// From 发件人地址的域名, 同时匹配子域名
+	From []string `json:"from"`
+	// Subject 主题需要匹配的正则, 为空时不检查
+	Subject string `json:"subject,omitempty"`
+	// Fields 字段名到规则, 按顺序尝试直到取到非空的值.
+	// 字段名见 FieldName 等常量, 其他字段放入 Candidate.Extra
+	Fields map[string]Rules `json:"fields"`
+
+	subject *regexp.Regexp
+}
+
+func (t *Template) compile() error {
+	if t.Name == "" {
+		return errors.New("extract: template has no name")
+	}
+	if len(t.From) == 0 {
+		return fmt.Errorf("extract: template %s has no sender", t.Name)
+	}
+	var err error
+	if t.Subject != "" {
+		if t.subject, err = regexp.Compile(t.Subject); err != nil {
+			return fmt.Errorf("extract: template %s subject: %v", t.Name, err)
+		}
+	}
+	// 复制规则, 编译结果不写回调用方的模板
+	fields := make(map[string]Rules, len(t.Fields))
+	for field, rules := range t.Fields {
+		rules = append(Rules(nil), rules...)
+		fields[field] = rules
+		for i := range rules {
+			r := &rules[i]
+			if r.CSS != "" {
+				if _, err = cascadia.Compile(r.CSS); err != nil {
+					return fmt.Errorf("extract: template %s field %s: %v", t.Name, field, err)
+				}
+			}
+			if r.XPath != "" {
+				if _, err = htmlquery.QueryAll(&html.Node{Type: html.DocumentNode}, r.XPath); err != nil {
+					return fmt.Errorf("extract: template %s field %s: %v", t.Name, field, err)
+				}
+			}
+			if r.Regexp != "" {
+				if r.re, err = regexp.Compile(r.Regexp); err != nil {
+					return fmt.Errorf("extract: template %s field %s: %v", t.Name, field, err)
+				}
+			}
+		}
+	}
+	t.Fields = fields
+	return nil
+}
+
+// Match 发件人和主题是否符合模板
+func (t *Template) Match(from, subject string) bool {
+	return t.ownAddress(from) && (t.subject == nil || t.subject.MatchString(subject))
+}
+
+// ownAddress 地址是否属于招聘网站
+func (t *Template) ownAddress(addr string) bool {
+	domain := strings.ToLower(addr[strings.LastIndex(addr, "@")+1:])
+	for _, d := range t.From {
+		d = strings.ToLower(d)
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Extractor 按发件人选择模板提取候选人, 创建后可以并发使用
+type Extractor struct {
+	templates []*Template
+}
+
+// NewExtractor 编译模板, 先添加的模板优先匹配
+func NewExtractor(templates ...Template) (*Extractor, error) {
+	e := &Extractor{}
+	for _, t := range templates {
+		if err := e.add(t, false); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// Add 添加模板, 优先于已有的模板匹配; 同名的模板被替换
+func (e *Extractor) Add(t Template) error {
+	return e.add(t, true)
+}
+
+func (e *Extractor) add(t Template, first bool) error {
+	if err := t.compile(); err != nil {
+		return err
+	}
+	for i, old := range e.templates {
+		if old.Name == t.Name {
+			e.templates = append(e.templates[:i:i], e.templates[i+1:]...)
+			break
+		}
+	}
+	if first {
+		e.templates = append([]*Template{&t}, e.templates...)
+	} else {
+		e.templates = append(e.templates, &t)
+	}
+	return nil
+}
+
+// Template 返回与发件人和主题匹配的模板, 没有时返回 nil
+func (e *Extractor) Template(from, subject string) *Template {
+	for _, t := range e.templates {
+		if t.Match(from, subject) {
+			return t
+		}
+	}
+	return nil
+}
+
+// Extract 从邮件的 html 正文中提取候选人. 没有匹配的模板时返回 ErrNoTemplate
+func (e *Extractor) Extract(from, subject, body string) (*Candidate, error) {
+	t := e.Template(from, subject)
+	if t == nil {
+		return nil, ErrNoTemplate
+	}
+	return t.Extract(body)
+}
+
+// Extract 按模板提取候选人, 至少要取到姓名或简历链接
+func (t *Template) Extract(body string) (*Candidate, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("extract %s: %v", t.Name, err)
+	}
+	p := &page{doc: doc, query: goquery.NewDocumentFromNode(doc)}
+	c := &Candidate{Source: t.Name, Experience: -1}
+	for field, rules := range t.Fields {
+		var v string
+		for i := range rules {
+			if v = p.find(&rules[i]); v != "" {
+				break
+			}
+		}
+		if v == "" {
+			continue
+		}
+		switch field {
+		case FieldName:
+			c.Name = v
+		case FieldPosition:
+			c.Position = v
+		case FieldPhone:
+			c.Phone = normalizePhone(v)
+		case FieldEmail:
+			c.Email = strings.ToLower(v)
+		case FieldExperience:
+			c.ExperienceText = v
+			c.Experience = parseExperience(v)
+		case FieldResumeURL:
+			c.ResumeURL = v
+		default:
+			if c.Extra == nil {
+				c.Extra = make(map[string]string)
+			}
+			c.Extra[field] = v
+		}
+	}
+	if c.Name == "" && c.ResumeURL == "" {
+		return nil, fmt.Errorf("extract %s: no candidate found", t.Name)
+	}
+	// 模板没有取到时在正文中查找手机号和邮箱, 跳过招聘网站自己的地址
+	if c.Phone == "" {
+		if m := phoneReg.FindStringSubmatch(p.text()); m != nil {
+			c.Phone = m[1]
+		}
+	}
+	if c.Email == "" {
+		for _, addr := range emailReg.FindAllString(p.text(), -1) {
+			if !t.ownAddress(addr) {
+				c.Email = strings.ToLower(addr)
+				break
+			}
+		}
+	}
+	return c, nil
+}
+
+// page 解析后的正文, 同一封邮件的多个规则共用
+type page struct {
+	doc   *html.Node
+	query *goquery.Document
+	// plain body 中的文本, 第一次使用时生成
+	plain *string
+}
+
+func (p *page) text() string {
+	if p.plain == nil {
+		var words []string
+		p.query.Find("body").Each(func(_ int, s *goquery.Selection) {
+			for _, n := range s.Nodes {
+				words = appendText(words, n)
+			}
+		})
+		s := strings.Join(words, " ")
+		p.plain = &s
+	}
+	return *p.plain
+}
+
+// appendText 按文本节点分词, 相邻元素的文本不会连在一起
+func appendText(words []string, n *html.Node) []string {
+	switch {
+	case n.Type == html.TextNode:
+		return append(words, strings.Fields(n.Data)...)
+	case n.Type == html.ElementNode && (n.DataAtom == atom.Script || n.DataAtom == atom.Style):
+		return words
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		words = appendText(words, c)
+	}
+	return words
+}
+
+// find 按规则取值, 合并空白; 没有匹配时返回空
+func (p *page) find(r *Rule) string {
+	var values []string
+	switch {
+	case r.CSS != "":
+		p.query.Find(r.CSS).Each(func(_ int, s *goquery.Selection) {
+			if r.Attr != "" {
+				v, _ := s.Attr(r.Attr)
+				values = append(values, v)
+			} else {
+				values = append(values, s.Text())
+			}
+		})
+	case r.XPath != "":
+		nodes, _ := htmlquery.QueryAll(p.doc, r.XPath)
+		for _, n := range nodes {
+			if r.Attr != "" {
+				values = append(values, htmlquery.SelectAttr(n, r.Attr))
+			} else {
+				values = append(values, htmlquery.InnerText(n))
+			}
+		}
+	default:
+		values = []string{p.text()}
+	}
+	if r.re != nil {
+		matched := values[:0]
+		for _, v := range values {
+			m := r.re.FindStringSubmatch(strings.TrimSpace(v))
+			switch {
+			case m == nil:
+			case len(m) > 1:
+				matched = append(matched, m[1])
+			default:
+				matched = append(matched, m[0])
+			}
+		}
+		values = matched
+	}
+	i := r.Index
+	if i < 0 {
+		i += len(values)
+	}
+	if i < 0 || i >= len(values) {
+		return ""
+	}
+	v := values[i]
+	return strings.Join(strings.Fields(v), " ")
+}
+
+var (
+	// phoneReg 中国大陆手机号
+	phoneReg = regexp.MustCompile(`(?:^|\D)(1[3-9]\d{9})(?:\D|$)`)
+	emailReg = regexp.MustCompile(`[\w.+-]+@[\w-]+(?:\.[\w-]+)+`)
+	yearsReg = regexp.MustCompile(`(\d+)\s*年`)
+)
+
+// normalizePhone 去掉空格、横线和 +86 前缀
+func normalizePhone(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	d := b.String()
+	if len(d) == 13 && strings.HasPrefix(d, "86") {
+		d = d[2:]
+	}
+	if d == "" {
+		return s
+	}
+	return d
+}
+
+// parseExperience 解析工作年限, 如 "3年"、"5年以上"、"应届生"
+func parseExperience(s string) int {
+	if strings.Contains(s, "应届") || strings.Contains(s, "在校") || strings.Contains(s, "无经验") {
+		return 0
+	}
+	if m := yearsReg.FindStringSubmatch(s); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return n
+	}
+	// LinkedIn 等英文模板, 如 "5 years"
+	f := strings.Fields(s)
+	for i := 0; i+1 < len(f); i++ {
+		if strings.HasPrefix(strings.ToLower(f[i+1]), "year") {
+			if n, err := strconv.Atoi(f[i]); err == nil {
+				return n
+			}
+		}
+	}
+	return -1
+}
+
+// LoadTemplates 读取 json 文件中的模板列表
+func LoadTemplates(path string) (templates []Template, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &templates)
+	return templates, err
+}
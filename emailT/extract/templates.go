@@ -0,0 +1,96 @@
+package extract
+
+import "fmt"
+
+// Templates 内置的招聘网站模板. 网站改版后可以用 json 文件中的同名模板覆盖, 见 LoadTemplates
+var Templates = []Template{
+	{
+		// BOSS直聘 "收到简历" 通知, 候选人信息在简历链接中的几个 span 里
+		Name: "boss",
+		From: []string{"zhipin.com", "bosszhipin.com"},
+		Fields: map[string]Rules{
+			FieldName:       {{CSS: `a[href*="resume-page"] span`}},
+			FieldPosition:   {{CSS: `span[style*="53CAC3"]`, Regexp: `^([^|]+?)\s*(?:\||$)`}},
+			FieldExperience: {{CSS: `a[href*="resume-page"] span`, Regexp: `^(\d+年.*|.*应届.*)$`}},
+			FieldResumeURL:  {{CSS: `a[href*="resume-page"]`, Attr: "href"}},
+			"gender":        {{CSS: `a[href*="resume-page"] span`, Regexp: `^(男|女)$`}},
+			"education":     {{CSS: `a[href*="resume-page"]`, Regexp: degreePattern}},
+			"salary":        {{CSS: `a[href*="resume-page"] span`, Regexp: `^\d+-\d+K$`}},
+		},
+	},
+	{
+		// 智联招聘的简历投递通知, 候选人信息为 "标签: 值" 的表格
+		Name:    "zhaopin",
+		From:    []string{"zhaopin.com", "zhaopin.cn"},
+		Subject: `应聘|简历`,
+		Fields: map[string]Rules{
+			FieldName:       labelRules("姓名"),
+			FieldPosition:   append(labelRules("应聘职位"), Rule{Regexp: `应聘(?:贵公司)?\s*[“"【]?([^”"】\s]+)`}),
+			FieldPhone:      labelRules("手机", "电话"),
+			FieldEmail:      labelRules("邮箱", "E-mail"),
+			FieldExperience: append(labelRules("工作经验", "工作年限"), Rule{Regexp: experiencePattern}),
+			FieldResumeURL:  {{XPath: `//a[contains(@href,"zhaopin.com") and (contains(.,"简历") or contains(.,"查看"))]/@href`}},
+			"education":     append(labelRules("学历"), Rule{Regexp: degreePattern}),
+		},
+	},
+	{
+		// 前程无忧 (51job) 的简历投递通知
+		Name:    "51job",
+		From:    []string{"51job.com"},
+		Subject: `应聘|简历`,
+		Fields: map[string]Rules{
+			FieldName:       labelRules("姓名"),
+			FieldPosition:   append(labelRules("应聘职位", "投递职位"), Rule{Regexp: `应聘\s*[“"【]?([^”"】\s]+)`}),
+			FieldPhone:      labelRules("手机", "电话"),
+			FieldEmail:      labelRules("邮箱", "E-mail"),
+			FieldExperience: append(labelRules("工作年限", "工作经验"), Rule{Regexp: experiencePattern}),
+			FieldResumeURL:  {{XPath: `//a[contains(@href,"51job.com") and (contains(.,"简历") or contains(.,"查看"))]/@href`}},
+			"education":     append(labelRules("学历"), Rule{Regexp: degreePattern}),
+		},
+	},
+	{
+		// 猎聘的简历投递通知
+		Name:    "liepin",
+		From:    []string{"liepin.com", "lietou.com"},
+		Subject: `应聘|简历|投递`,
+		Fields: map[string]Rules{
+			FieldName:       labelRules("姓名"),
+			FieldPosition:   append(labelRules("应聘职位", "投递职位"), Rule{Regexp: `投递了?(?:您的)?\s*[“"【]?([^”"】\s]+)`}),
+			FieldPhone:      labelRules("手机", "电话"),
+			FieldEmail:      labelRules("邮箱", "E-mail"),
+			FieldExperience: append(labelRules("工作年限", "工作经验"), Rule{Regexp: experiencePattern}),
+			FieldResumeURL:  {{XPath: `//a[contains(@href,"liepin.com") and (contains(.,"简历") or contains(.,"查看"))]/@href`}},
+			"education":     append(labelRules("学历"), Rule{Regexp: degreePattern}),
+		},
+	},
+	{
+		// LinkedIn 的 "new applicant" 通知, 英文
+		Name:    "linkedin",
+		From:    []string{"linkedin.com"},
+		Subject: `(?i)applicant|applied|application`,
+		Fields: map[string]Rules{
+			FieldName:       {{XPath: `//a[contains(@href,"/in/")][normalize-space(.)!=""]`}},
+			FieldPosition:   {{Regexp: `(?i)applied (?:for|to) (?:your job )?(.+?)(?: at | in |\.|$)`}},
+			FieldEmail:      {{XPath: `//a[starts-with(@href,"mailto:")]`}},
+			FieldExperience: {{Regexp: `(?i)(\d+\+? years?)`}},
+			FieldResumeURL:  {{XPath: `//a[contains(@href,"/hiring/") or contains(@href,"applicants")]/@href`}},
+		},
+	},
+}
+
+const (
+	degreePattern     = `(博士|硕士|研究生|MBA|本科|大专|中专|中技|高中)`
+	experiencePattern = `(\d+\s*年(?:以上)?(?:工作)?经验|无工作经验|应届毕业生|在校生)`
+)
+
+// labelRules "标签: 值" 形式的字段, 值在标签之后的单元格中, 或与标签在同一段文本里
+func labelRules(labels ...string) Rules {
+	var rules Rules
+	for _, l := range labels {
+		rules = append(rules,
+			Rule{XPath: fmt.Sprintf(`//*[normalize-space(text())="%s" or normalize-space(text())="%s:" or normalize-space(text())="%s："]/following-sibling::*[1]`, l, l, l)},
+			Rule{Regexp: fmt.Sprintf(`%s\s*[:：]\s*(\S+)`, l)},
+		)
+	}
+	return rules
+}
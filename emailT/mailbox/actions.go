@@ -0,0 +1,151 @@
+package mailbox
+
+import (
+	"fmt"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+)
+
+// Actions 邮件处理成功后在服务器上执行的操作, 按标记、复制、移动、删除的顺序执行.
+// 处理失败的邮件不会执行, 进度也不会前进, 程序中途退出不会丢邮件
+type Actions struct {
+	// Seen 标记为已读
+	Seen bool `json:"seen,omitempty"`
+	// Keyword 添加自定义标记, 如 $Ingested, 可以作为 Criteria 排除已处理的邮件
+	Keyword string `json:"keyword,omitempty"`
+	// CopyTo 复制到该文件夹
+	CopyTo string `json:"copy_to,omitempty"`
+	// MoveTo 移动到该文件夹. 服务器不支持 MOVE 时用 COPY 加删除代替, 删除同 Delete
+	MoveTo string `json:"move_to,omitempty"`
+	// Delete 从当前文件夹删除, 服务器不支持 UIDPLUS 时见 ExpungeAll
+	Delete bool `json:"delete,omitempty"`
+	// CreateFolders 目标文件夹不存在时自动创建
+	CreateFolders bool `json:"create_folders,omitempty"`
+	// ExpungeAll 服务器不支持 UIDPLUS 时仍然用 EXPUNGE 清除删除的邮件, 这会同时清除
+	// 文件夹中其它已标记删除的邮件. 为 false 时这种服务器上的邮件只标记 \Deleted,
+	// 留给邮件客户端清除
+	ExpungeAll bool `json:"expunge_all,omitempty"`
+}
+
+// Apply 对当前选中文件夹中的一封邮件执行操作
+func (a *Actions) Apply(c *client.Client, uid uint32) error {
+	if a == nil {
+		return nil
+	}
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uid)
+
+	var flags []interface{}
+	if a.Seen {
+		flags = append(flags, imap.SeenFlag)
+	}
+	if a.Keyword != "" {
+		flags = append(flags, a.Keyword)
+	}
+	if len(flags) > 0 {
+		if err := c.UidStore(seqset, imap.FormatFlagsOp(imap.AddFlags, true), flags, nil); err != nil {
+			return fmt.Errorf("uid %d: store flags: %w", uid, err)
+		}
+	}
+	if a.CopyTo != "" {
+		if err := a.copy(c, seqset, a.CopyTo); err != nil {
+			return fmt.Errorf("uid %d: copy to %s: %w", uid, a.CopyTo, err)
+		}
+	}
+	if a.MoveTo != "" {
+		if err := a.move(c, seqset, a.MoveTo); err != nil {
+			return fmt.Errorf("uid %d: move to %s: %w", uid, a.MoveTo, err)
+		}
+		return nil
+	}
+	if a.Delete {
+		if err := a.expunge(c, seqset); err != nil {
+			return fmt.Errorf("uid %d: delete: %w", uid, err)
+		}
+	}
+	return nil
+}
+
+// move 优先使用 MOVE (RFC 6851), 否则 COPY 后删除原邮件
+func (a *Actions) move(c *client.Client, seqset *imap.SeqSet, dest string) error {
+	ok, err := c.Support("MOVE")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := a.copy(c, seqset, dest); err != nil {
+			return err
+		}
+		return a.expunge(c, seqset)
+	}
+	return a.execute(c, &commands.Uid{Cmd: &moveCommand{SeqSet: seqset, Mailbox: dest}}, dest)
+}
+
+func (a *Actions) copy(c *client.Client, seqset *imap.SeqSet, dest string) error {
+	return a.execute(c, &commands.Uid{Cmd: &commands.Copy{SeqSet: seqset, Mailbox: dest}}, dest)
+}
+
+// execute 执行 COPY 或 MOVE, 服务器返回 [TRYCREATE] 且允许创建时创建文件夹后重试一次
+func (a *Actions) execute(c *client.Client, cmd imap.Commander, dest string) error {
+	status, err := c.Execute(cmd, nil)
+	if err != nil {
+		return err
+	}
+	if status.Code == imap.CodeTryCreate && a.CreateFolders {
+		if err := c.Create(dest); err != nil {
+			return fmt.Errorf("create: %w", err)
+		}
+		if status, err = c.Execute(cmd, nil); err != nil {
+			return err
+		}
+	}
+	return status.Err()
+}
+
+// expunge 标记删除后清除. 服务器支持 UIDPLUS 时只清除这些邮件, 否则
+// EXPUNGE 会同时清除文件夹中其它已标记删除的邮件, 只在 ExpungeAll 时执行
+func (a *Actions) expunge(c *client.Client, seqset *imap.SeqSet) error {
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	if err := c.UidStore(seqset, item, []interface{}{imap.DeletedFlag}, nil); err != nil {
+		return err
+	}
+	ok, err := c.Support("UIDPLUS")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if !a.ExpungeAll {
+			return nil
+		}
+		return c.Expunge(nil)
+	}
+	status, err := c.Execute(&commands.Uid{Cmd: &uidExpungeCommand{SeqSet: seqset}}, nil)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// moveCommand MOVE 命令, go-imap 没有内置
+type moveCommand struct {
+	SeqSet  *imap.SeqSet
+	Mailbox string
+}
+
+func (cmd *moveCommand) Command() *imap.Command {
+	// 借用 COPY 的参数格式, 包括文件夹名称的 UTF-7 编码
+	c := (&commands.Copy{SeqSet: cmd.SeqSet, Mailbox: cmd.Mailbox}).Command()
+	c.Name = "MOVE"
+	return c
+}
+
+// uidExpungeCommand UID EXPUNGE 的内层命令 (RFC 4315)
+type uidExpungeCommand struct {
+	SeqSet *imap.SeqSet
+}
+
+func (cmd *uidExpungeCommand) Command() *imap.Command {
+	return &imap.Command{Name: "EXPUNGE", Arguments: []interface{}{cmd.SeqSet}}
+}
@@ -0,0 +1,61 @@
+package mailbox
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// deletedUIDs 文件夹中的邮件和其中标记删除的邮件
+func deletedUIDs(t *testing.T, c *client.Client) (all, deleted []uint32) {
+	t.Helper()
+	seqset, _ := imap.ParseSeqSet("1:*")
+	msgs := make(chan *imap.Message, 10)
+	if err := c.UidFetch(seqset, []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, msgs); err != nil {
+		t.Fatal(err)
+	}
+	for m := range msgs {
+		all = append(all, m.Uid)
+		for _, f := range m.Flags {
+			if f == imap.DeletedFlag {
+				deleted = append(deleted, m.Uid)
+			}
+		}
+	}
+	return all, deleted
+}
+
+func TestDeleteWithoutUIDPlus(t *testing.T) {
+	c := dialTestServer(t, newTestServer(t))
+	appendMessage(t, c, "INBOX", "second")
+	appendMessage(t, c, "INBOX", "third")
+	if _, err := c.Select("INBOX", false); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := c.Support("UIDPLUS"); ok {
+		t.Skip("test server supports UIDPLUS")
+	}
+	// 其它客户端标记删除但还没有清除的邮件
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(6)
+	if err := c.UidStore(seqset, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.DeletedFlag}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&Actions{Delete: true}).Apply(c, 7); err != nil {
+		t.Fatal(err)
+	}
+	all, deleted := deletedUIDs(t, c)
+	if fmt.Sprint(all) != "[6 7 8]" || fmt.Sprint(deleted) != "[6 7]" {
+		t.Fatalf("after delete: uids %v, deleted %v; want nothing expunged", all, deleted)
+	}
+
+	if err := (&Actions{Delete: true, ExpungeAll: true}).Apply(c, 8); err != nil {
+		t.Fatal(err)
+	}
+	if all, _ = deletedUIDs(t, c); len(all) != 0 {
+		t.Errorf("after ExpungeAll: uids %v, want all expunged", all)
+	}
+}
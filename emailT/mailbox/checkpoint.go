@@ -0,0 +1,74 @@
+package mailbox
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"studyGo/emailT/tools"
+)
+
+// Checkpoint 某个账号某个文件夹的同步进度
+type Checkpoint struct {
+	UIDValidity uint32 `json:"uid_validity"`
+	// LastUID 已处理的最大 UID, 下次从 LastUID+1 开始取
+	LastUID uint32 `json:"last_uid"`
+	// Seen POP3 已处理邮件的 UIDL
+	Seen      []string  `json:"seen,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CheckpointStore 同步进度的存储
+type CheckpointStore interface {
+	// Load 读取进度, 没有记录时 ok 为 false
+	Load(account, mailbox string) (cp Checkpoint, ok bool, err error)
+	Save(account, mailbox string, cp Checkpoint) error
+}
+
+// FileCheckpointStore 保存在 JSON 文件中的同步进度
+type FileCheckpointStore struct {
+	path string
+	mu   sync.Mutex
+	data map[string]map[string]Checkpoint
+}
+
+// NewFileCheckpointStore 打开进度文件, 文件不存在时在第一次保存时创建
+func NewFileCheckpointStore(path string) (*FileCheckpointStore, error) {
+	s := &FileCheckpointStore{path: path, data: make(map[string]map[string]Checkpoint)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &s.data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Load 读取进度
+func (s *FileCheckpointStore) Load(account, mailbox string) (Checkpoint, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp, ok := s.data[account][mailbox]
+	return cp, ok, nil
+}
+
+// Save 保存进度并写入文件, 先写临时文件再改名
+func (s *FileCheckpointStore) Save(account, mailbox string, cp Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data[account] == nil {
+		s.data[account] = make(map[string]Checkpoint)
+	}
+	s.data[account][mailbox] = cp
+	data, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return tools.WriteFileAtomic(s.path, data, 0600)
+}
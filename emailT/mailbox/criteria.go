@@ -0,0 +1,165 @@
+package mailbox
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// criteriaDateLayout 配置中日期的格式
+const criteriaDateLayout = "2006-01-02"
+
+// Criteria 服务器端搜索条件, 可以从 json 配置加载. 不同字段之间为 AND,
+// 同一字段的多个值之间为 OR
+type Criteria struct {
+	// From 发件人包含其中之一
+	From []string `json:"from,omitempty"`
+	// Subject 主题包含其中之一, 如 ["简历", "应聘"]
+	Subject []string `json:"subject,omitempty"`
+	// Header 每个邮件头都要包含对应的值, 值为空时只要求有该邮件头
+	Header map[string]string `json:"header,omitempty"`
+	Unseen bool              `json:"unseen,omitempty"`
+	// Larger、Smaller 邮件大小的范围, 字节
+	Larger  uint32 `json:"larger,omitempty"`
+	Smaller uint32 `json:"smaller,omitempty"`
+	// Since、Before 收到日期的范围, 格式为 2006-01-02, Before 不含当天
+	Since  string `json:"since,omitempty"`
+	Before string `json:"before,omitempty"`
+	// LastDays 只收最近几天的邮件, 与 Since 同时设置时取较晚的日期
+	LastDays int `json:"last_days,omitempty"`
+}
+
+// NewCriteria 创建空的搜索条件, 用 With 系列方法链式添加条件
+func NewCriteria() *Criteria {
+	return new(Criteria)
+}
+
+// WithFrom 发件人包含 addrs 之一
+func (c *Criteria) WithFrom(addrs ...string) *Criteria {
+	c.From = append(c.From, addrs...)
+	return c
+}
+
+// WithSubject 主题包含 words 之一
+func (c *Criteria) WithSubject(words ...string) *Criteria {
+	c.Subject = append(c.Subject, words...)
+	return c
+}
+
+// WithHeader 邮件头 key 包含 value
+func (c *Criteria) WithHeader(key, value string) *Criteria {
+	if c.Header == nil {
+		c.Header = make(map[string]string)
+	}
+	c.Header[key] = value
+	return c
+}
+
+// OnlyUnseen 只收未读邮件
+func (c *Criteria) OnlyUnseen() *Criteria {
+	c.Unseen = true
+	return c
+}
+
+// LargerThan 邮件大于 n 字节
+func (c *Criteria) LargerThan(n uint32) *Criteria {
+	c.Larger = n
+	return c
+}
+
+// SmallerThan 邮件小于 n 字节
+func (c *Criteria) SmallerThan(n uint32) *Criteria {
+	c.Smaller = n
+	return c
+}
+
+// Between 收到日期在 [since, before) 之间, 为零的一端不限制
+func (c *Criteria) Between(since, before time.Time) *Criteria {
+	c.Since, c.Before = "", ""
+	if !since.IsZero() {
+		c.Since = since.Format(criteriaDateLayout)
+	}
+	if !before.IsZero() {
+		c.Before = before.Format(criteriaDateLayout)
+	}
+	return c
+}
+
+// InLastDays 只收最近 n 天的邮件
+func (c *Criteria) InLastDays(n int) *Criteria {
+	c.LastDays = n
+	return c
+}
+
+// Build 转换为 go-imap 的搜索条件
+func (c *Criteria) Build() (*imap.SearchCriteria, error) {
+	out := imap.NewSearchCriteria()
+	if c == nil {
+		return out, nil
+	}
+	if err := addAnyOf(out, "From", c.From); err != nil {
+		return nil, err
+	}
+	if err := addAnyOf(out, "Subject", c.Subject); err != nil {
+		return nil, err
+	}
+	for k, v := range c.Header {
+		out.Header.Add(k, v)
+	}
+	if c.Unseen {
+		out.WithoutFlags = append(out.WithoutFlags, imap.SeenFlag)
+	}
+	out.Larger, out.Smaller = c.Larger, c.Smaller
+
+	var err error
+	if c.Since != "" {
+		if out.Since, err = time.ParseInLocation(criteriaDateLayout, c.Since, time.Local); err != nil {
+			return nil, fmt.Errorf("criteria since: %v", err)
+		}
+	}
+	if c.Before != "" {
+		if out.Before, err = time.ParseInLocation(criteriaDateLayout, c.Before, time.Local); err != nil {
+			return nil, fmt.Errorf("criteria before: %v", err)
+		}
+	}
+	if c.LastDays > 0 {
+		since := time.Now().AddDate(0, 0, -c.LastDays)
+		if since.After(out.Since) {
+			out.Since = since
+		}
+	}
+	return out, nil
+}
+
+// addAnyOf 一个值时直接作为 HEADER 条件, 多个值时用 OR 连接
+func addAnyOf(out *imap.SearchCriteria, key string, values []string) error {
+	switch len(values) {
+	case 0:
+		return nil
+	case 1:
+		out.Header.Add(key, values[0])
+		return nil
+	}
+	var list []*imap.SearchCriteria
+	for _, v := range values {
+		if v == "" {
+			return fmt.Errorf("criteria %s: empty value", key)
+		}
+		sc := imap.NewSearchCriteria()
+		sc.Header.Add(key, v)
+		list = append(list, sc)
+	}
+	out.Or = append(out.Or, orCriteria(list).Or...)
+	return nil
+}
+
+// orCriteria 把多个条件嵌套成 OR a (OR b c)
+func orCriteria(list []*imap.SearchCriteria) *imap.SearchCriteria {
+	if len(list) == 1 {
+		return list[0]
+	}
+	out := imap.NewSearchCriteria()
+	out.Or = [][2]*imap.SearchCriteria{{list[0], orCriteria(list[1:])}}
+	return out
+}
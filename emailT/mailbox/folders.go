@@ -0,0 +1,154 @@
+package mailbox
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"studyGo/emailT/tools"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/utf7"
+)
+
+// DefaultFolderDeny FolderFilter.Deny 为空时排除的文件夹: 已发送、草稿和已删除
+var DefaultFolderDeny = []string{`\Sent`, `\Drafts`, `\Trash`}
+
+// Folder LIST 返回的文件夹
+type Folder struct {
+	// Name 解码后的名称, 如 "招聘"; 选择文件夹时使用该名称
+	Name string
+	// Raw 服务器返回的原始名称, 一般为 modified UTF-7, 如 "&YttYWA-"
+	Raw        string
+	Delimiter  string
+	Attributes []string
+}
+
+// Selectable 是否可以 SELECT
+func (f *Folder) Selectable() bool {
+	return !f.HasAttr(imap.NoSelectAttr) && !f.HasAttr(`\NonExistent`)
+}
+
+// HasAttr 是否带有属性, 不区分大小写
+func (f *Folder) HasAttr(attr string) bool {
+	for _, a := range f.Attributes {
+		if strings.EqualFold(a, attr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListFolders 用 LIST "" "*" 列出全部文件夹. 名称按 modified UTF-7 解码,
+// 有些服务器直接返回 UTF-8 或 GBK 的名称, 解码失败时按原样或按字符集检测处理
+func ListFolders(c *client.Client) ([]Folder, error) {
+	res := &listResponse{}
+	status, err := c.Execute(&commands.List{Mailbox: "*"}, res)
+	if err != nil {
+		return nil, err
+	}
+	if err = status.Err(); err != nil {
+		return nil, err
+	}
+	return res.folders, nil
+}
+
+// listResponse 自己解析 LIST 响应, go-imap 遇到无法解码的名称会让整个 LIST 失败
+type listResponse struct {
+	folders []Folder
+}
+
+func (r *listResponse) Handle(resp imap.Resp) error {
+	name, fields, ok := imap.ParseNamedResp(resp)
+	if !ok || name != "LIST" || len(fields) < 3 {
+		return responses.ErrUnhandled
+	}
+	var f Folder
+	f.Attributes, _ = imap.ParseStringList(fields[0])
+	f.Delimiter, _ = fields[1].(string)
+	raw, err := imap.ParseString(fields[2])
+	if err != nil {
+		return nil
+	}
+	f.Raw = raw
+	f.Name = imap.CanonicalMailboxName(DecodeFolderName(raw))
+	r.folders = append(r.folders, f)
+	return nil
+}
+
+// DecodeFolderName 解码 modified UTF-7 (RFC 3501 5.1.3) 的文件夹名称
+func DecodeFolderName(raw string) string {
+	if name, err := utf7.Encoding.NewDecoder().String(raw); err == nil {
+		return name
+	}
+	if utf8.ValidString(raw) {
+		return raw
+	}
+	name, _ := tools.DetectCharset([]byte(raw))
+	if data, err := tools.DefaultCharsets.Decode(name, []byte(raw)); err == nil {
+		return string(data)
+	}
+	return raw
+}
+
+// FolderFilter 按名称模式选择文件夹. 模式中 * 匹配任意字符, ? 匹配一个字符,
+// 不区分大小写; 以 \ 开头的模式匹配文件夹属性, 如 \Junk
+type FolderFilter struct {
+	// Allow 要收取的文件夹, 为空时为全部
+	Allow []string `json:"allow,omitempty"`
+	// Deny 排除的文件夹, 优先于 Allow; 为 nil 时使用 DefaultFolderDeny
+	Deny []string `json:"deny,omitempty"`
+}
+
+// Filter 选出可以 SELECT 且符合模式的文件夹
+func (ff *FolderFilter) Filter(folders []Folder) (out []Folder) {
+	deny := ff.Deny
+	if deny == nil {
+		deny = DefaultFolderDeny
+	}
+	for _, f := range folders {
+		if !f.Selectable() || matchFolder(&f, deny) {
+			continue
+		}
+		if len(ff.Allow) > 0 && !matchFolder(&f, ff.Allow) {
+			continue
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+func matchFolder(f *Folder, patterns []string) bool {
+	for _, p := range patterns {
+		if strings.HasPrefix(p, `\`) {
+			if f.HasAttr(p) {
+				return true
+			}
+			continue
+		}
+		if globRegexp(p).MatchString(f.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func globRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,46 @@
+package mailbox
+
+import (
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/responses"
+)
+
+// idleCommand RFC 2177 IDLE 命令, go-imap 的 client 没有内置
+type idleCommand struct{}
+
+func (idleCommand) Command() *imap.Command {
+	return &imap.Command{Name: "IDLE"}
+}
+
+// idleResponse 收到服务器的继续请求后等待 stop, 再发送 DONE 结束 IDLE.
+// IDLE 期间的 EXISTS 等响应交给 client 的 Updates
+type idleResponse struct {
+	stop    <-chan struct{}
+	replies chan []byte
+}
+
+func (r *idleResponse) Replies() <-chan []byte {
+	return r.replies
+}
+
+func (r *idleResponse) Handle(resp imap.Resp) error {
+	if _, ok := resp.(*imap.ContinuationReq); !ok {
+		return responses.ErrUnhandled
+	}
+	go func() {
+		<-r.stop
+		r.replies <- []byte("DONE\r\n")
+	}()
+	return nil
+}
+
+// idle 发起 IDLE, stop 关闭后结束, 连接断开时返回错误
+func idle(c *client.Client, stop <-chan struct{}) error {
+	res := &idleResponse{stop: stop, replies: make(chan []byte, 1)}
+	status, err := c.Execute(idleCommand{}, res)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
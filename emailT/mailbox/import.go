@@ -0,0 +1,427 @@
+package mailbox
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message/textproto"
+)
+
+// MboxFormat mbox 的变体, 区别在于正文中以 "From " 开头的行如何转义
+type MboxFormat int
+
+const (
+	// MboxRD 正文中的 ">*From " 行多加一个 ">", 读取时去掉一个; 也能读取 mboxo
+	MboxRD MboxFormat = iota
+	// MboxO 只转义 "From " 行, 读取时 ">From " 还原为 "From "
+	MboxO
+	// MboxCL 按 Content-Length 确定正文长度, "From " 行与 mboxo 一样转义
+	MboxCL
+	// MboxCL2 按 Content-Length 确定正文长度, 不转义
+	MboxCL2
+)
+
+// Importer 从本地文件导入邮件, 产生与 Syncer 相同的 Message, 可以直接使用
+// 收取邮件的 Handler. 导入不记录进度, 重复导入时由 Handler 去重
+type Importer struct {
+	// Account 填入 Message.Account, 一般为文件来源的说明
+	Account string
+	// Format mbox 文件的变体, 默认为 MboxRD
+	Format MboxFormat
+}
+
+// Import 根据路径的类型导入: 含有 cur、new 目录的为 Maildir, 其它目录导入
+// 其中全部的 .eml 文件, .eml 文件单独导入, 其它文件按 mbox 导入
+func (im *Importer) Import(path string, fn Handler) (n int, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case fi.IsDir() && isMaildir(path):
+		return im.ImportMaildir(path, fn)
+	case fi.IsDir():
+		return im.ImportEMLDir(path, fn)
+	case strings.EqualFold(filepath.Ext(path), ".eml"):
+		return im.ImportEML(path, fn)
+	}
+	return im.ImportMbox(path, fn)
+}
+
+// ImportMbox 导入 mbox 文件, 邮件逐封读入内存
+func (im *Importer) ImportMbox(path string, fn Handler) (n int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	mailbox := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	r := NewMboxReader(f, im.Format)
+	for {
+		m, err := r.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("%s: %v", path, err)
+		}
+		msg := &Message{
+			Account:      im.Account,
+			Mailbox:      mailbox,
+			UID:          uint32(n + 1),
+			Flags:        m.Flags,
+			Size:         uint32(len(m.Data)),
+			InternalDate: m.Date,
+			Body:         bytes.NewReader(m.Data),
+		}
+		if err = fn(msg); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// ImportMaildir 导入 Maildir 的 new 和 cur 中的邮件, 以及 Maildir++ 的子文件夹
+// (以 "." 开头的目录, 名称为 modified UTF-7)
+func (im *Importer) ImportMaildir(dir string, fn Handler) (n int, err error) {
+	if n, err = im.importMaildirFolder(dir, "INBOX", fn); err != nil {
+		return n, err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return n, err
+	}
+	for _, e := range entries {
+		sub := filepath.Join(dir, e.Name())
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), ".") || e.Name() == "." || e.Name() == ".." || !isMaildir(sub) {
+			continue
+		}
+		name := DecodeFolderName(strings.ReplaceAll(strings.TrimPrefix(e.Name(), "."), ".", "/"))
+		m, err := im.importMaildirFolder(sub, name, fn)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (im *Importer) importMaildirFolder(dir, mailbox string, fn Handler) (n int, err error) {
+	var files []string
+	for _, sub := range []string{"new", "cur"} {
+		entries, err := ioutil.ReadDir(filepath.Join(dir, sub))
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		for _, e := range entries {
+			if e.Mode().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+				files = append(files, filepath.Join(sub, e.Name()))
+			}
+		}
+	}
+	// 文件名以投递时间开头, 按文件名排序即按到达顺序
+	sort.Slice(files, func(i, j int) bool { return filepath.Base(files[i]) < filepath.Base(files[j]) })
+	for _, name := range files {
+		msg, err := im.readFile(filepath.Join(dir, name), mailbox, uint32(n+1))
+		if err != nil {
+			return n, err
+		}
+		msg.Flags = maildirFlags(filepath.Base(name))
+		if t := maildirTime(filepath.Base(name)); !t.IsZero() {
+			msg.InternalDate = t
+		}
+		if err = fn(msg); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// ImportEML 导入单个 .eml 文件
+func (im *Importer) ImportEML(path string, fn Handler) (int, error) {
+	msg, err := im.readFile(path, filepath.Base(filepath.Dir(path)), 1)
+	if err != nil {
+		return 0, err
+	}
+	if err = fn(msg); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// ImportEMLDir 递归导入目录中的 .eml 文件, 文件夹为相对于 dir 的目录
+func (im *Importer) ImportEMLDir(dir string, fn Handler) (n int, err error) {
+	uids := make(map[string]uint32)
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || !strings.EqualFold(filepath.Ext(path), ".eml") {
+			return nil
+		}
+		mailbox, _ := filepath.Rel(dir, filepath.Dir(path))
+		if mailbox == "." {
+			mailbox = filepath.Base(dir)
+		}
+		mailbox = filepath.ToSlash(mailbox)
+		uids[mailbox]++
+		msg, err := im.readFile(path, mailbox, uids[mailbox])
+		if err != nil {
+			return err
+		}
+		if err = fn(msg); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// readFile 读取一封邮件文件, 收到时间取文件的修改时间
+func (im *Importer) readFile(path, mailbox string, uid uint32) (*Message, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	msg := &Message{
+		Account: im.Account,
+		Mailbox: mailbox,
+		UID:     uid,
+		Size:    uint32(len(data)),
+		Body:    bytes.NewReader(data),
+	}
+	if fi, err := os.Stat(path); err == nil {
+		msg.InternalDate = fi.ModTime()
+	}
+	return msg, nil
+}
+
+func isMaildir(dir string) bool {
+	for _, sub := range []string{"cur", "new"} {
+		if fi, err := os.Stat(filepath.Join(dir, sub)); err != nil || !fi.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// maildirFlags 解析文件名中 ":2," 之后的标记, Windows 上的 Maildir 用 "!" 代替 ":"
+func maildirFlags(name string) []string {
+	i := strings.LastIndex(name, ":2,")
+	if i < 0 {
+		i = strings.LastIndex(name, "!2,")
+	}
+	if i < 0 {
+		return nil
+	}
+	var flags []string
+	for _, c := range name[i+3:] {
+		switch c {
+		case 'S':
+			flags = append(flags, imap.SeenFlag)
+		case 'R':
+			flags = append(flags, imap.AnsweredFlag)
+		case 'F':
+			flags = append(flags, imap.FlaggedFlag)
+		case 'T':
+			flags = append(flags, imap.DeletedFlag)
+		case 'D':
+			flags = append(flags, imap.DraftFlag)
+		}
+	}
+	return flags
+}
+
+// maildirTime 文件名开头的投递时间, 如 1600000000.M1P2.host
+func maildirTime(name string) time.Time {
+	i := strings.IndexByte(name, '.')
+	if i <= 0 {
+		return time.Time{}
+	}
+	sec, err := strconv.ParseInt(name[:i], 10, 64)
+	if err != nil || sec <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// MboxMessage mbox 中的一封邮件
+type MboxMessage struct {
+	// Sender "From " 分隔行中的发件人
+	Sender string
+	// Date "From " 分隔行中的时间, 无法解析时为零
+	Date time.Time
+	// Flags 由 Status、X-Status 邮件头得到的标记
+	Flags []string
+	// Data 还原转义后的原始邮件
+	Data []byte
+}
+
+// MboxReader 逐封读取 mbox
+type MboxReader struct {
+	r      *bufio.Reader
+	format MboxFormat
+	// next 已经读到的下一封邮件的分隔行
+	next []byte
+	err  error
+}
+
+// NewMboxReader 创建 mbox 读取器
+func NewMboxReader(r io.Reader, format MboxFormat) *MboxReader {
+	return &MboxReader{r: bufio.NewReader(r), format: format}
+}
+
+// Next 读取下一封邮件, 没有更多邮件时返回 io.EOF
+func (m *MboxReader) Next() (*MboxMessage, error) {
+	sep := m.next
+	if sep == nil {
+		// 跳过文件开头第一个分隔行之前的内容
+		for {
+			line, err := m.readLine()
+			if err != nil {
+				return nil, err
+			}
+			if isFromLine(line) {
+				sep = line
+				break
+			}
+		}
+	}
+	m.next = nil
+	msg := &MboxMessage{}
+	msg.Sender, msg.Date = parseFromLine(sep)
+
+	var buf bytes.Buffer
+	inHeader := true
+	length := -1
+	bodyStart := 0
+	for {
+		line, err := m.readLine()
+		if err == io.EOF {
+			m.err = io.EOF
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if isFromLine(line) && (length < 0 || buf.Len()-bodyStart >= length) {
+			m.next = line
+			break
+		}
+		if inHeader {
+			if len(bytes.TrimRight(line, "\r\n")) == 0 {
+				inHeader = false
+				bodyStart = buf.Len() + len(line)
+				if m.format == MboxCL || m.format == MboxCL2 {
+					length = contentLength(buf.Bytes())
+				}
+			}
+			buf.Write(line)
+			continue
+		}
+		buf.Write(m.unescape(line))
+	}
+	data := buf.Bytes()
+	// 分隔行之前的空行属于 mbox 格式, 不属于邮件
+	if bytes.HasSuffix(data, []byte("\r\n\r\n")) {
+		data = data[:len(data)-2]
+	} else if bytes.HasSuffix(data, []byte("\n\n")) {
+		data = data[:len(data)-1]
+	}
+	msg.Data = data
+	msg.Flags = mboxFlags(data)
+	return msg, nil
+}
+
+func (m *MboxReader) readLine() ([]byte, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	line, err := m.r.ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		return line, nil
+	}
+	return line, err
+}
+
+// unescape 还原正文中被转义的 "From " 行
+func (m *MboxReader) unescape(line []byte) []byte {
+	if m.format == MboxCL2 || len(line) == 0 || line[0] != '>' {
+		return line
+	}
+	rest := bytes.TrimLeft(line, ">")
+	if !bytes.HasPrefix(rest, []byte("From ")) {
+		return line
+	}
+	if m.format == MboxRD || len(line)-len(rest) == 1 {
+		return line[1:]
+	}
+	return line
+}
+
+func isFromLine(line []byte) bool {
+	return bytes.HasPrefix(line, []byte("From "))
+}
+
+// mboxDateLayouts "From " 分隔行中时间的格式
+var mboxDateLayouts = []string{time.ANSIC, "Mon Jan _2 15:04:05 MST 2006", "Mon Jan _2 15:04:05 -0700 2006", "Mon, _2 Jan 2006 15:04:05 -0700"}
+
+// parseFromLine 解析 "From sender@example.com Wed Nov 11 12:00:00 2020"
+func parseFromLine(line []byte) (sender string, date time.Time) {
+	fields := strings.Fields(strings.TrimSpace(string(line)))
+	if len(fields) < 2 {
+		return "", date
+	}
+	sender = fields[1]
+	rest := strings.Join(fields[2:], " ")
+	for _, layout := range mboxDateLayouts {
+		if t, err := time.ParseInLocation(layout, rest, time.Local); err == nil {
+			return sender, t
+		}
+	}
+	return sender, date
+}
+
+// contentLength 邮件头中的 Content-Length, 没有或无法解析时为 -1
+func contentLength(header []byte) int {
+	h, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(header)))
+	if err != nil {
+		return -1
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(h.Get("Content-Length")))
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// mboxFlags 由 Status 和 X-Status 邮件头得到标记
+func mboxFlags(data []byte) []string {
+	h, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil
+	}
+	var flags []string
+	status := h.Get("Status") + h.Get("X-Status")
+	for _, c := range []struct {
+		c    string
+		flag string
+	}{{"R", imap.SeenFlag}, {"A", imap.AnsweredFlag}, {"F", imap.FlaggedFlag}, {"D", imap.DeletedFlag}, {"T", imap.DraftFlag}} {
+		if strings.Contains(status, c.c) {
+			flags = append(flags, c.flag)
+		}
+	}
+	return flags
+}
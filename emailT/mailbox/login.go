@@ -0,0 +1,134 @@
+package mailbox
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"studyGo/emailT/pop3"
+
+	"github.com/emersion/go-imap/client"
+)
+
+// DefaultLoginTimeout 连接、TLS 握手和登录各自的默认超时
+const DefaultLoginTimeout = 30 * time.Second
+
+// 登录检查的结果, 见 LoginError
+const (
+	StatusOK = "ok"
+	// StatusDNS 服务器域名无法解析
+	StatusDNS = "dns_error"
+	// StatusTLS TLS 握手失败或证书无效
+	StatusTLS = "tls_error"
+	// StatusAuth 服务器拒绝了用户名和密码
+	StatusAuth = "auth_error"
+	// StatusUnavailable 连接被拒绝、超时或中途断开
+	StatusUnavailable = "unavailable"
+)
+
+// LoginError 登录失败
+type LoginError struct {
+	Account string
+	// Status 失败的类型, 见 StatusDNS 等
+	Status string
+	Err    error
+}
+
+func (e *LoginError) Error() string {
+	if e.Account == "" {
+		return fmt.Sprintf("login: %s: %v", e.Status, e.Err)
+	}
+	return fmt.Sprintf("login %s: %s: %v", e.Account, e.Status, e.Err)
+}
+
+func (e *LoginError) Unwrap() error {
+	return e.Err
+}
+
+// loginError 给登录错误加上账号. POP3 的 -ERR 和 OAuth 刷新 token 失败为认证失败,
+// 其它没有分类的错误按连接阶段的错误分类
+func loginError(account string, err error) *LoginError {
+	var le *LoginError
+	var perr *pop3.Error
+	var terr *TokenError
+	switch {
+	case errors.As(err, &le):
+		return &LoginError{Account: account, Status: le.Status, Err: le.Err}
+	case errors.As(err, &perr), errors.As(err, &terr), errors.Is(err, ErrNoRefreshToken):
+		return &LoginError{Account: account, Status: StatusAuth, Err: err}
+	}
+	return &LoginError{Account: account, Status: dialStatus(err), Err: err}
+}
+
+// LoginStatus 登录结果的类型, err 为空时为 StatusOK
+func LoginStatus(err error) string {
+	if err == nil {
+		return StatusOK
+	}
+	var lerr *LoginError
+	if errors.As(err, &lerr) {
+		return lerr.Status
+	}
+	return dialStatus(err)
+}
+
+// Login 建立 TLS 连接并用用户名和密码登录. timeout 限制连接、握手和登录命令,
+// 为 0 时使用 DefaultLoginTimeout; 登录之后的命令不限时. 失败时返回 *LoginError
+func Login(addr, user, password string, timeout time.Duration) (*client.Client, error) {
+	if timeout <= 0 {
+		timeout = DefaultLoginTimeout
+	}
+	c, err := client.DialWithDialerTLS(&net.Dialer{Timeout: timeout}, addr, nil)
+	if err != nil {
+		return nil, &LoginError{Status: dialStatus(err), Err: err}
+	}
+	c.Timeout = timeout
+	if err = c.Login(user, password); err != nil {
+		c.Logout()
+		return nil, &LoginError{Status: loginStatus(err), Err: err}
+	}
+	// 连接上还留着登录命令的超时, 用一个不限时的命令清除
+	c.Timeout = 0
+	if err = c.Noop(); err != nil {
+		c.Logout()
+		return nil, &LoginError{Status: StatusUnavailable, Err: err}
+	}
+	return c, nil
+}
+
+// dialStatus 连接和握手阶段的错误类型
+func dialStatus(err error) string {
+	var dnsErr *net.DNSError
+	var authorityErr x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+	var certErr x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	switch {
+	case errors.As(err, &dnsErr):
+		return StatusDNS
+	case errors.As(err, &authorityErr), errors.As(err, &hostErr), errors.As(err, &certErr), errors.As(err, &recordErr):
+		return StatusTLS
+	case strings.Contains(err.Error(), "tls:") || strings.Contains(err.Error(), "x509:"):
+		// 握手时收到的 alert 没有导出的类型
+		return StatusTLS
+	}
+	return StatusUnavailable
+}
+
+// loginStatus 登录命令的错误类型: 服务器返回 NO/BAD 为认证失败, 网络错误为不可用
+func loginStatus(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, client.ErrLoginDisabled):
+		return StatusAuth
+	case errors.As(err, &netErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		strings.Contains(err.Error(), "connection closed"):
+		return StatusUnavailable
+	}
+	return StatusAuth
+}
@@ -0,0 +1,301 @@
+package mailbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"studyGo/emailT/tools"
+	"studyGo/emailT/vault"
+
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-sasl"
+)
+
+// OAuth 登录方式, 见 Account.Auth
+const (
+	// AuthOAuth 按服务器能力选择, 优先 OAUTHBEARER
+	AuthOAuth       = "oauth"
+	AuthXOAuth2     = "xoauth2"
+	AuthOAuthBearer = "oauthbearer"
+)
+
+// tokenExpiryDelta access token 过期前多久开始刷新
+const tokenExpiryDelta = time.Minute
+
+// ErrNoRefreshToken 没有保存 refresh token, 需要重新授权
+var ErrNoRefreshToken = errors.New("oauth: no refresh token, authorize the account first")
+
+// IsOAuth 是否为 OAuth 登录方式
+func IsOAuth(auth string) bool {
+	switch strings.ToLower(auth) {
+	case AuthOAuth, AuthXOAuth2, AuthOAuthBearer:
+		return true
+	}
+	return false
+}
+
+// Token OAuth2 token
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	TokenType    string    `json:"token_type,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// Valid access token 不为空且不会马上过期
+func (t *Token) Valid() bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || time.Now().Add(tokenExpiryDelta).Before(t.Expiry))
+}
+
+// TokenSource 提供有效的 access token
+type TokenSource interface {
+	Token() (*Token, error)
+}
+
+// TokenStore token 的存储, 刷新后的 token 写回以便下次启动时使用
+type TokenStore interface {
+	// Load 读取账号的 token, 没有记录时返回 nil
+	Load(account string) (*Token, error)
+	Save(account string, tok *Token) error
+}
+
+// OAuthConfig 账号的 OAuth2 配置
+type OAuthConfig struct {
+	ClientID     string       `json:"client_id"`
+	ClientSecret vault.Secret `json:"client_secret,omitempty"`
+	// TokenURL 刷新 token 的地址, 如 https://oauth2.googleapis.com/token
+	TokenURL string   `json:"token_url"`
+	Scopes   []string `json:"scopes,omitempty"`
+	// RefreshToken TokenStore 中没有记录时使用的 refresh token
+	RefreshToken vault.Secret `json:"refresh_token,omitempty"`
+}
+
+// TokenError token 接口返回的错误 (RFC 6749 5.2)
+type TokenError struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *TokenError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("oauth: %s: %s (http %d)", e.Code, e.Description, e.StatusCode)
+	}
+	return fmt.Sprintf("oauth: %s (http %d)", e.Code, e.StatusCode)
+}
+
+// RefreshTokenSource 用保存的 refresh token 刷新 access token, 在过期前复用,
+// 刷新结果写回 Store. 可以并发使用
+type RefreshTokenSource struct {
+	Config  *OAuthConfig
+	Account string
+	Store   TokenStore
+	// Client 请求 token 接口的 http 客户端, 为空时使用 10 秒超时的默认客户端
+	Client *http.Client
+
+	mu  sync.Mutex
+	tok *Token
+}
+
+// Token 返回有效的 access token, 需要时刷新
+func (s *RefreshTokenSource) Token() (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok == nil && s.Store != nil {
+		tok, err := s.Store.Load(s.Account)
+		if err != nil {
+			return nil, err
+		}
+		s.tok = tok
+	}
+	if s.tok.Valid() {
+		return s.tok, nil
+	}
+	refresh := string(s.Config.RefreshToken)
+	if s.tok != nil && s.tok.RefreshToken != "" {
+		refresh = s.tok.RefreshToken
+	}
+	if refresh == "" {
+		return nil, ErrNoRefreshToken
+	}
+	tok, err := s.refresh(refresh)
+	if err != nil {
+		return nil, err
+	}
+	s.tok = tok
+	if s.Store != nil {
+		if err = s.Store.Save(s.Account, tok); err != nil {
+			return nil, err
+		}
+	}
+	return tok, nil
+}
+
+// Expire 丢弃缓存的 access token, 下次 Token 时重新刷新.
+// 服务器拒绝了还没到过期时间的 token (如已被撤销) 时使用
+func (s *RefreshTokenSource) Expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok != nil {
+		t := *s.tok
+		t.AccessToken = ""
+		s.tok = &t
+	}
+}
+
+// refresh 用 refresh_token 换取新的 access token (RFC 6749 6)
+func (s *RefreshTokenSource) refresh(refreshToken string) (*Token, error) {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {s.Config.ClientID},
+	}
+	if s.Config.ClientSecret != "" {
+		form.Set("client_secret", string(s.Config.ClientSecret))
+	}
+	if len(s.Config.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.Config.Scopes, " "))
+	}
+	hc := s.Client
+	if hc == nil {
+		hc = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := hc.PostForm(s.Config.TokenURL, form)
+	if err != nil {
+		return nil, fmt.Errorf("oauth: refresh token: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("oauth: refresh token: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		te := &TokenError{StatusCode: resp.StatusCode}
+		if json.Unmarshal(body, te) != nil || te.Code == "" {
+			te.Code = strings.TrimSpace(string(body))
+		}
+		return nil, te
+	}
+	var res struct {
+		AccessToken  string      `json:"access_token"`
+		RefreshToken string      `json:"refresh_token"`
+		TokenType    string      `json:"token_type"`
+		ExpiresIn    json.Number `json:"expires_in"`
+	}
+	if err = json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("oauth: bad token response: %v", err)
+	}
+	if res.AccessToken == "" {
+		return nil, errors.New("oauth: token response has no access_token")
+	}
+	tok := &Token{AccessToken: res.AccessToken, RefreshToken: res.RefreshToken, TokenType: res.TokenType}
+	// 服务器没有返回新的 refresh token 时继续使用原来的
+	if tok.RefreshToken == "" {
+		tok.RefreshToken = refreshToken
+	}
+	if n, err := res.ExpiresIn.Int64(); err == nil && n > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(n) * time.Second)
+	}
+	return tok, nil
+}
+
+// FileTokenStore 保存在 JSON 文件中的 token, 文件权限为 0600
+type FileTokenStore struct {
+	path string
+	mu   sync.Mutex
+	data map[string]*Token
+}
+
+// NewFileTokenStore 打开 token 文件, 文件不存在时在第一次保存时创建
+func NewFileTokenStore(path string) (*FileTokenStore, error) {
+	s := &FileTokenStore{path: path, data: make(map[string]*Token)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &s.data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Load 读取账号的 token
+func (s *FileTokenStore) Load(account string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tok := s.data[account]; tok != nil {
+		t := *tok
+		return &t, nil
+	}
+	return nil, nil
+}
+
+// Save 保存 token 并写入文件
+func (s *FileTokenStore) Save(account string, tok *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := *tok
+	s.data[account] = &t
+	data, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return tools.WriteFileAtomic(s.path, data, 0600)
+}
+
+// Authenticate 用 OAuth2 登录 IMAP. mech 为 xoauth2、oauthbearer, 或 oauth
+// 按服务器声明的 AUTH= 能力选择; addr 为服务器地址, OAUTHBEARER 需要其中的主机和端口.
+// 服务器拒绝 token 且 ts 可以 Expire 时, 刷新 token 后重试一次
+func Authenticate(c *client.Client, mech, user, addr string, ts TokenSource) error {
+	err := authenticate(c, mech, user, addr, ts)
+	var xerr *sasl.Xoauth2Error
+	var berr *sasl.OAuthBearerError
+	if errors.As(err, &xerr) || errors.As(err, &berr) {
+		if e, ok := ts.(interface{ Expire() }); ok {
+			e.Expire()
+			return authenticate(c, mech, user, addr, ts)
+		}
+	}
+	return err
+}
+
+func authenticate(c *client.Client, mech, user, addr string, ts TokenSource) error {
+	mech = strings.ToLower(mech)
+	if mech == AuthOAuth {
+		mech = AuthXOAuth2
+		if ok, err := c.SupportAuth(sasl.OAuthBearer); err != nil {
+			return err
+		} else if ok {
+			mech = AuthOAuthBearer
+		}
+	}
+	tok, err := ts.Token()
+	if err != nil {
+		return err
+	}
+	var auth sasl.Client
+	switch mech {
+	case AuthXOAuth2:
+		auth = sasl.NewXoauth2Client(user, tok.AccessToken)
+	case AuthOAuthBearer:
+		host, portStr, _ := net.SplitHostPort(addr)
+		port, _ := strconv.Atoi(portStr)
+		auth = sasl.NewOAuthBearerClient(&sasl.OAuthBearerOptions{Username: user, Token: tok.AccessToken, Host: host, Port: port})
+	default:
+		return fmt.Errorf("unknown auth mechanism %q", mech)
+	}
+	return c.Authenticate(auth)
+}
@@ -0,0 +1,228 @@
+package mailbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-sasl"
+)
+
+// tokenEndpoint 假的 token 接口, 每次刷新发放新的 access token 和 refresh token
+type tokenEndpoint struct {
+	*httptest.Server
+	// ExpiresIn 发放的 access token 的有效秒数
+	ExpiresIn int
+
+	mu sync.Mutex
+	// refreshTokens 每次请求使用的 refresh token
+	refreshTokens []string
+}
+
+func newTokenEndpoint(t *testing.T) *tokenEndpoint {
+	e := &tokenEndpoint{ExpiresIn: 3600}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("client_id") != "client" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_request"}`)
+			return
+		}
+		e.mu.Lock()
+		e.refreshTokens = append(e.refreshTokens, r.FormValue("refresh_token"))
+		n := len(e.refreshTokens)
+		e.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  fmt.Sprintf("access-%d", n),
+			"refresh_token": fmt.Sprintf("refresh-%d", n),
+			"token_type":    "Bearer",
+			"expires_in":    e.ExpiresIn,
+		})
+	}))
+	t.Cleanup(e.Close)
+	return e
+}
+
+// requests 收到的刷新请求使用的 refresh token
+func (e *tokenEndpoint) requests() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.refreshTokens...)
+}
+
+func (e *tokenEndpoint) config() *OAuthConfig {
+	return &OAuthConfig{ClientID: "client", TokenURL: e.URL, RefreshToken: "refresh-0"}
+}
+
+func TestRefreshTokenSource(t *testing.T) {
+	e := newTokenEndpoint(t)
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	store, err := NewFileTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &RefreshTokenSource{Config: e.config(), Account: "hr@example.com", Store: store}
+
+	for i := 0; i < 2; i++ {
+		tok, err := ts.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok.AccessToken != "access-1" {
+			t.Errorf("Token() #%d = %s, want access-1 reused", i, tok.AccessToken)
+		}
+	}
+	if got := fmt.Sprint(e.requests()); got != "[refresh-0]" {
+		t.Errorf("refresh requests %s, want one with the configured token", got)
+	}
+
+	// 轮换后的 refresh token 写入了文件, 下次启动时直接使用未过期的 access token
+	reopened, err := NewFileTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := reopened.Load("hr@example.com")
+	if saved == nil || saved.AccessToken != "access-1" || saved.RefreshToken != "refresh-1" {
+		t.Fatalf("saved token = %+v, want access-1 with rotated refresh-1", saved)
+	}
+	ts = &RefreshTokenSource{Config: e.config(), Account: "hr@example.com", Store: reopened}
+	if tok, err := ts.Token(); err != nil || tok.AccessToken != "access-1" {
+		t.Fatalf("Token() after restart = %+v, %v", tok, err)
+	}
+	if len(e.requests()) != 1 {
+		t.Errorf("token refreshed again after restart")
+	}
+
+	// 过期后用轮换后的 refresh token 刷新
+	ts.Expire()
+	if tok, err := ts.Token(); err != nil || tok.AccessToken != "access-2" {
+		t.Fatalf("Token() after Expire = %+v, %v", tok, err)
+	}
+	if got := fmt.Sprint(e.requests()); got != "[refresh-0 refresh-1]" {
+		t.Errorf("refresh requests %s, want the rotated token used", got)
+	}
+}
+
+func TestRefreshTokenSourceNearExpiry(t *testing.T) {
+	e := newTokenEndpoint(t)
+	// 有效期短于 tokenExpiryDelta 的 token 每次都要刷新
+	e.ExpiresIn = 30
+	ts := &RefreshTokenSource{Config: e.config(), Account: "hr@example.com"}
+	for i := 0; i < 2; i++ {
+		if _, err := ts.Token(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := fmt.Sprint(e.requests()); got != "[refresh-0 refresh-1]" {
+		t.Errorf("refresh requests %s", got)
+	}
+
+	ts = &RefreshTokenSource{Config: &OAuthConfig{ClientID: "client", TokenURL: e.URL}, Account: "new"}
+	if _, err := ts.Token(); !errors.Is(err, ErrNoRefreshToken) {
+		t.Errorf("Token() without refresh token = %v, want ErrNoRefreshToken", err)
+	}
+}
+
+func TestTokenError(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   TokenError
+	}{
+		{http.StatusBadRequest, `{"error":"invalid_grant","error_description":"Token has been expired or revoked."}`,
+			TokenError{StatusCode: 400, Code: "invalid_grant", Description: "Token has been expired or revoked."}},
+		{http.StatusBadGateway, "upstream unavailable\n", TokenError{StatusCode: 502, Code: "upstream unavailable"}},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			fmt.Fprint(w, tt.body)
+		}))
+		ts := &RefreshTokenSource{Config: &OAuthConfig{ClientID: "client", TokenURL: srv.URL, RefreshToken: "refresh-0"}}
+		_, err := ts.Token()
+		srv.Close()
+		var terr *TokenError
+		if !errors.As(err, &terr) {
+			t.Errorf("status %d: err = %v, want *TokenError", tt.status, err)
+			continue
+		}
+		if *terr != tt.want {
+			t.Errorf("status %d: error = %+v, want %+v", tt.status, *terr, tt.want)
+		}
+		if LoginStatus(loginError("hr", err)) != StatusAuth {
+			t.Errorf("status %d: token error not classified as auth failure", tt.status)
+		}
+	}
+}
+
+// xoauth2Server 只接受 token 为 valid 的 XOAUTH2 服务端, 拒绝时按 Gmail 的格式返回错误
+type xoauth2Server struct {
+	conn   server.Conn
+	be     *memory.Backend
+	valid  string
+	failed bool
+}
+
+func (s *xoauth2Server) Next(response []byte) ([]byte, bool, error) {
+	if s.failed {
+		return nil, true, errors.New("invalid credentials")
+	}
+	if !bytes.Contains(response, []byte("auth=Bearer "+s.valid+"\x01")) {
+		s.failed = true
+		return []byte(`{"status":"401","schemes":"bearer","scope":"https://mail.google.com/"}`), false, nil
+	}
+	user, err := s.be.Login(s.conn.Info(), "username", "password")
+	if err != nil {
+		return nil, true, err
+	}
+	ctx := s.conn.Context()
+	ctx.State = imap.AuthenticatedState
+	ctx.User = user
+	return nil, true, nil
+}
+
+func TestAuthenticateRetriesAfterExpire(t *testing.T) {
+	e := newTokenEndpoint(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	be := memory.New()
+	s := server.New(be)
+	s.AllowInsecureAuth = true
+	// 第一个 token 在过期前被撤销了, 只接受刷新后的 token
+	s.EnableAuth(sasl.Xoauth2, func(conn server.Conn) sasl.Server {
+		return &xoauth2Server{conn: conn, be: be, valid: "access-2"}
+	})
+	go s.Serve(l)
+	defer s.Close()
+
+	c, err := client.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Logout()
+	ts := &RefreshTokenSource{Config: e.config(), Account: "username"}
+	if _, err = ts.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if err = Authenticate(c, AuthOAuth, "username", l.Addr().String(), ts); err != nil {
+		t.Fatal(err)
+	}
+	if c.State() != imap.AuthenticatedState {
+		t.Errorf("state = %v after Authenticate", c.State())
+	}
+	if got := fmt.Sprint(e.requests()); got != "[refresh-0 refresh-1]" {
+		t.Errorf("refresh requests %s, want one refresh after the rejected token", got)
+	}
+}
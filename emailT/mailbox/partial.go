@@ -0,0 +1,311 @@
+package mailbox
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"studyGo/emailT/tools"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+)
+
+// 两阶段收取的默认参数
+const (
+	// DefaultChunkSize 分段 FETCH 每次取的字节数, 也是收取时一个节点占用内存的上限
+	DefaultChunkSize = 256 << 10
+	// DefaultMaxTextSize 正文节点的最大字节数, 超过的部分丢弃
+	DefaultMaxTextSize = 1 << 20
+)
+
+// PartialFetch 两阶段收取: 先取 BODYSTRUCTURE 和邮件头, 再按需分段取正文和
+// 符合条件的附件, 附件边取边写入磁盘, 内存占用与邮件大小无关.
+// 两阶段收取不验证签名也不解密, 需要时收取完整邮件
+type PartialFetch struct {
+	// Dir 附件落盘目录, 必须设置
+	Dir string `json:"dir,omitempty"`
+	// Types 要下载的附件类型, 如 application/pdf、image/*; 为空时下载全部附件
+	Types []string `json:"types,omitempty"`
+	// MaxMessageSize 邮件超过该大小时只取邮件头和正文, 不下载附件; 0 为不限制
+	MaxMessageSize uint32 `json:"max_message_size,omitempty"`
+	// MaxAttachmentSize 单个附件编码后的最大字节数, 超过的跳过; 0 为不限制
+	MaxAttachmentSize uint32 `json:"max_attachment_size,omitempty"`
+	// MaxTextSize 正文节点的最大字节数, 为 0 时使用 DefaultMaxTextSize
+	MaxTextSize uint32 `json:"max_text_size,omitempty"`
+	// ChunkSize 每次 FETCH 的字节数, 为 0 时使用 DefaultChunkSize
+	ChunkSize uint32 `json:"chunk_size,omitempty"`
+}
+
+// PartialMessage 两阶段收取的结果
+type PartialMessage struct {
+	Structure *imap.BodyStructure
+	// Parsed 邮件头和正文. 附件节点的 Body 为空, 内容在 Attachments 中
+	Parsed *tools.ParsedMessage
+	// Attachments 与 Parsed.Attachments 一一对应, 已落盘或注明了跳过的原因
+	Attachments []tools.Attachment
+}
+
+// headerSection 第一阶段取的邮件头
+var headerSection = &imap.BodySectionName{BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier}, Peek: true}
+
+// fetchItems 第一阶段 FETCH 的数据项
+func (p *PartialFetch) fetchItems() []imap.FetchItem {
+	return []imap.FetchItem{imap.FetchUid, imap.FetchFlags, imap.FetchInternalDate, imap.FetchRFC822Size,
+		imap.FetchBodyStructure, headerSection.FetchItem()}
+}
+
+// Fetch 第二阶段: m 为第一阶段取到的邮件, 按 BODYSTRUCTURE 取需要的节点
+func (p *PartialFetch) Fetch(c *client.Client, m *imap.Message) (*PartialMessage, error) {
+	if p.Dir == "" {
+		return nil, fmt.Errorf("partial fetch: Dir is not set")
+	}
+	if err := os.MkdirAll(p.Dir, 0755); err != nil {
+		return nil, err
+	}
+	if m.BodyStructure == nil {
+		return nil, fmt.Errorf("uid %d: server returned no bodystructure", m.Uid)
+	}
+	raw := m.GetBody(headerSection)
+	if raw == nil {
+		return nil, fmt.Errorf("uid %d: server returned no header", m.Uid)
+	}
+	h, err := textproto.ReadHeader(bufio.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("uid %d: header: %v", m.Uid, err)
+	}
+	res := &PartialMessage{
+		Structure: m.BodyStructure,
+		Parsed:    &tools.ParsedMessage{Header: message.Header{Header: h}},
+	}
+
+	var leaves [][]int
+	m.BodyStructure.Walk(func(path []int, bs *imap.BodyStructure) bool {
+		if strings.EqualFold(bs.MIMEType, "multipart") {
+			return true
+		}
+		leaves = append(leaves, path)
+		return false
+	})
+	// 单节点邮件的 MIME 头就是邮件头
+	headers := []message.Header{res.Parsed.Header}
+	if len(m.BodyStructure.Parts) > 0 {
+		if headers, err = partHeaders(c, m.Uid, leaves); err != nil {
+			return nil, fmt.Errorf("uid %d: %v", m.Uid, err)
+		}
+	}
+	x := &tools.AttachmentExtractor{Dir: p.Dir}
+	for i, path := range leaves {
+		part := tools.NewPart(headers[i], sectionPath(path))
+		switch {
+		case part.IsAttachment():
+			att, err := p.attachment(c, m, path, part, x)
+			if err != nil {
+				return nil, fmt.Errorf("uid %d: %v", m.Uid, err)
+			}
+			part.SHA256 = att.SHA256
+			res.Attachments = append(res.Attachments, att)
+		case part.IsText():
+			data, err := p.text(c, m.Uid, path, part)
+			if err != nil {
+				return nil, fmt.Errorf("uid %d: part %s: %v", m.Uid, part.Path, err)
+			}
+			part.SetBody(data)
+		}
+		// 内嵌图片等其它节点只记录信息, 不取内容
+		res.Parsed.AddPart(part)
+	}
+	return res, nil
+}
+
+// partHeaders 用一次 FETCH 取全部叶子节点的 MIME 头
+func partHeaders(c *client.Client, uid uint32, leaves [][]int) ([]message.Header, error) {
+	out := make([]message.Header, len(leaves))
+	var sections []*imap.BodySectionName
+	var items []imap.FetchItem
+	for _, path := range leaves {
+		section := &imap.BodySectionName{BodyPartName: imap.BodyPartName{Specifier: imap.MIMESpecifier, Path: path}, Peek: true}
+		sections = append(sections, section)
+		items = append(items, section.FetchItem())
+	}
+	m, err := fetchOne(c, uid, items)
+	if err != nil {
+		return nil, err
+	}
+	for i, section := range sections {
+		raw := m.GetBody(section)
+		if raw == nil {
+			return nil, fmt.Errorf("part %s: server returned no mime header", sectionPath(section.Path))
+		}
+		h, err := textproto.ReadHeader(bufio.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("part %s: mime header: %v", sectionPath(section.Path), err)
+		}
+		out[i] = message.Header{Header: h}
+	}
+	return out, nil
+}
+
+// text 取正文节点, 超过 MaxTextSize 的部分丢弃
+func (p *PartialFetch) text(c *client.Client, uid uint32, path []int, part *tools.Part) ([]byte, error) {
+	max := p.MaxTextSize
+	if max == 0 {
+		max = DefaultMaxTextSize
+	}
+	r := p.section(c, uid, path, max)
+	body, _ := tools.DecodeTransfer(part.TransferEncoding, r)
+	return ioutil.ReadAll(body)
+}
+
+// attachment 判断附件是否需要下载, 需要时边取边写入磁盘
+func (p *PartialFetch) attachment(c *client.Client, m *imap.Message, path []int, part *tools.Part, x *tools.AttachmentExtractor) (tools.Attachment, error) {
+	bs := structureAt(m.BodyStructure, path)
+	att := tools.Attachment{Filename: part.Filename, ContentType: part.ContentType, ContentID: part.ContentID}
+	var reason string
+	switch {
+	case p.MaxMessageSize > 0 && m.Size > p.MaxMessageSize:
+		reason = fmt.Sprintf("message size %d exceeds limit of %d bytes", m.Size, p.MaxMessageSize)
+	case bs != nil && p.MaxAttachmentSize > 0 && bs.Size > p.MaxAttachmentSize:
+		reason = fmt.Sprintf("size %d exceeds limit of %d bytes", bs.Size, p.MaxAttachmentSize)
+	case !p.wantType(part.ContentType):
+		reason = fmt.Sprintf("type %s not wanted", part.ContentType)
+	}
+	if reason != "" {
+		att.Skipped = true
+		att.SkipReason = reason
+		return att, nil
+	}
+	body, _ := tools.DecodeTransfer(part.TransferEncoding, p.section(c, m.Uid, path, 0))
+	return x.Save(part, body)
+}
+
+// wantType 附件类型是否符合 Types
+func (p *PartialFetch) wantType(contentType string) bool {
+	if len(p.Types) == 0 {
+		return true
+	}
+	for _, pattern := range p.Types {
+		if ok, _ := path.Match(strings.ToLower(pattern), contentType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// section 返回分段读取节点内容的 reader, limit 不为 0 时最多读取 limit 字节
+func (p *PartialFetch) section(c *client.Client, uid uint32, path []int, limit uint32) io.Reader {
+	chunk := p.ChunkSize
+	if chunk == 0 {
+		chunk = DefaultChunkSize
+	}
+	return &sectionReader{c: c, uid: uid, path: path, chunk: chunk, limit: limit}
+}
+
+// sectionReader 用 BODY.PEEK[path]<offset.chunk> 分段读取一个节点,
+// 同一时间只有一段在内存中
+type sectionReader struct {
+	c      *client.Client
+	uid    uint32
+	path   []int
+	chunk  uint32
+	limit  uint32
+	offset uint32
+	buf    bytes.Buffer
+	eof    bool
+}
+
+func (r *sectionReader) Read(b []byte) (int, error) {
+	if r.buf.Len() == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+		if r.buf.Len() == 0 {
+			return 0, io.EOF
+		}
+	}
+	return r.buf.Read(b)
+}
+
+func (r *sectionReader) next() error {
+	n := r.chunk
+	if r.limit > 0 && r.offset+n >= r.limit {
+		n = r.limit - r.offset
+		r.eof = true
+	}
+	section := &imap.BodySectionName{BodyPartName: imap.BodyPartName{Path: r.path}, Peek: true, Partial: []int{int(r.offset), int(n)}}
+	m, err := fetchOne(r.c, r.uid, []imap.FetchItem{section.FetchItem()})
+	if err != nil {
+		return fmt.Errorf("part %s: %v", sectionPath(r.path), err)
+	}
+	body := m.GetBody(section)
+	if body == nil {
+		return fmt.Errorf("part %s: server returned no body", sectionPath(r.path))
+	}
+	r.buf.Reset()
+	got, err := r.buf.ReadFrom(body)
+	if err != nil {
+		return err
+	}
+	r.offset += uint32(got)
+	if uint32(got) < n {
+		r.eof = true
+	}
+	return nil
+}
+
+// fetchOne 对一封邮件执行 UID FETCH
+func fetchOne(c *client.Client, uid uint32, items []imap.FetchItem) (*imap.Message, error) {
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uid)
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seqset, items, messages)
+	}()
+	var msg *imap.Message
+	for m := range messages {
+		if msg == nil {
+			msg = m
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("uid fetch: %w", err)
+	}
+	if msg == nil {
+		return nil, fmt.Errorf("uid %d: message not found", uid)
+	}
+	return msg, nil
+}
+
+// structureAt 按节点编号找到 BODYSTRUCTURE 中的节点
+func structureAt(bs *imap.BodyStructure, path []int) *imap.BodyStructure {
+	if len(bs.Parts) == 0 {
+		return bs
+	}
+	for _, i := range path {
+		if i < 1 || i > len(bs.Parts) {
+			return nil
+		}
+		bs = bs.Parts[i-1]
+	}
+	return bs
+}
+
+func sectionPath(path []int) string {
+	s := make([]string, len(path))
+	for i, n := range path {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ".")
+}
@@ -0,0 +1,396 @@
+package mailbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"studyGo/emailT/pop3"
+	"studyGo/emailT/vault"
+
+	"github.com/emersion/go-imap/client"
+)
+
+// 调度的默认参数
+const (
+	DefaultWorkers = 4
+	// DefaultAccountTimeout 单个账号一次收取的最长时间
+	DefaultAccountTimeout = 10 * time.Minute
+	DefaultRetries        = 2
+)
+
+// 账号的协议和登录方式
+const (
+	ProtocolIMAP = "imap"
+	ProtocolPOP3 = "pop3"
+	// AuthAPOP POP3 的 APOP 登录
+	AuthAPOP = "apop"
+)
+
+// ErrAccountTimeout 账号收取超时
+var ErrAccountTimeout = errors.New("account fetch timed out")
+
+// Account 一个邮箱账号的收取配置
+type Account struct {
+	UserName string `json:"user_name"`
+	// Password 密码或 vault 密文, 登录前才解密, 见 vault.Reveal
+	Password vault.Secret `json:"password"`
+	// Server 服务器地址, 如 imap.exmail.qq.com:993
+	Server string `json:"server"`
+	// Protocol imap 或 pop3, 为空时为 imap
+	Protocol string `json:"protocol,omitempty"`
+	// Auth 登录方式, 为空时用用户名和密码登录; apop 为 POP3 的 APOP;
+	// oauth、xoauth2、oauthbearer 为 IMAP 的 OAuth2 登录, 见 OAuth
+	Auth string `json:"auth,omitempty"`
+	// OAuth OAuth2 登录时刷新 access token 的配置
+	OAuth *OAuthConfig `json:"oauth,omitempty"`
+	// Mailboxes 收取的文件夹, 与 Folders 都为空时为 INBOX. 以下的文件夹、
+	// 搜索条件和两阶段收取只对 IMAP 有效, POP3 的 Actions 只支持 Delete
+	Mailboxes []string `json:"mailboxes,omitempty"`
+	// Folders 不为空时用 LIST 列出文件夹, 收取其中符合模式的文件夹
+	Folders *FolderFilter `json:"folders,omitempty"`
+	// Criteria 服务器端的搜索条件
+	Criteria *Criteria `json:"criteria,omitempty"`
+	// Actions 邮件处理成功后在服务器上执行的操作
+	Actions *Actions `json:"actions,omitempty"`
+	// Partial 不为空时两阶段收取, 只下载需要的附件
+	Partial *PartialFetch `json:"partial,omitempty"`
+	// Timeout 一次收取的最长时间, 为 0 时使用 Scheduler.Timeout.
+	// json 中为 timeout, 写成 "90s"、"10m" 这样的字符串
+	Timeout time.Duration `json:"-"`
+	// Retries 失败后的重试次数, 为 0 时使用 Scheduler.Retries, 小于 0 时不重试
+	Retries int `json:"retries,omitempty"`
+}
+
+// accountJSON Account 的 json 格式, 外层的 Timeout 覆盖 Account 中的同名字段
+type accountJSON struct {
+	*accountFields
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// accountFields 与 Account 相同但没有 json 方法, 避免递归
+type accountFields Account
+
+// MarshalJSON 把 Timeout 写成时长字符串
+func (a Account) MarshalJSON() ([]byte, error) {
+	v := accountJSON{accountFields: (*accountFields)(&a)}
+	if a.Timeout != 0 {
+		v.Timeout = a.Timeout.String()
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON 解析 time.ParseDuration 格式的 timeout
+func (a *Account) UnmarshalJSON(data []byte) error {
+	v := accountJSON{accountFields: (*accountFields)(a)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	a.Timeout = 0
+	if v.Timeout != "" {
+		d, err := time.ParseDuration(v.Timeout)
+		if err != nil {
+			return fmt.Errorf("account %s: timeout: %v", a.UserName, err)
+		}
+		if d < 0 {
+			return fmt.Errorf("account %s: negative timeout %s", a.UserName, v.Timeout)
+		}
+		a.Timeout = d
+	}
+	return nil
+}
+
+// AccountStats 账号的收取统计
+type AccountStats struct {
+	Account string
+	// Fetched 累计收取的邮件数
+	Fetched int
+	// Failures 累计失败的次数, ConsecutiveFailures 为最近连续失败的次数
+	Failures            int
+	ConsecutiveFailures int
+	LastError           string
+	LastErrorTime       time.Time
+	LastSuccess         time.Time
+	Running             bool
+}
+
+// Scheduler 并发收取多个账号, 每个账号有独立的超时、重试次数和错误状态,
+// 一个账号出错不影响其它账号
+type Scheduler struct {
+	// Dial 登录 IMAP 账号, 必须设置
+	Dial func(a Account) (*client.Client, error)
+	// DialPOP3 登录 POP3 账号, 有 POP3 账号时必须设置
+	DialPOP3 func(a Account) (*pop3.Client, error)
+	Store    CheckpointStore
+	// Since 账号第一次同步时只取该时间之后的邮件
+	Since time.Time
+	// Workers 同时收取的账号数, 为 0 时使用 DefaultWorkers
+	Workers int
+	// Timeout、Retries 账号没有单独配置时的默认值, 为 0 时使用
+	// DefaultAccountTimeout 和 DefaultRetries
+	Timeout time.Duration
+	Retries int
+	// RetryDelay 重试前的等待时间, 每次翻倍
+	RetryDelay time.Duration
+
+	mu    sync.Mutex
+	stats map[string]*AccountStats
+}
+
+// Run 收取一轮, 所有账号完成后返回累计的统计, 同 Stats
+func (s *Scheduler) Run(accounts []Account, fn Handler) []AccountStats {
+	workers := s.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	jobs := make(chan Account)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for a := range jobs {
+				s.runAccount(a, fn)
+			}
+		}()
+	}
+	for _, a := range accounts {
+		jobs <- a
+	}
+	close(jobs)
+	wg.Wait()
+	return s.Stats()
+}
+
+// Loop 每隔 interval 收取一轮, 直到 stop 关闭
+func (s *Scheduler) Loop(accounts []Account, interval time.Duration, stop <-chan struct{}, fn Handler) {
+	for {
+		s.Run(accounts, fn)
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Stats 全部账号的统计, 按账号排序
+func (s *Scheduler) Stats() []AccountStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]AccountStats, 0, len(s.stats))
+	for _, st := range s.stats {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Account < out[j].Account })
+	return out
+}
+
+// update 在锁内修改账号的统计
+func (s *Scheduler) update(account string, fn func(st *AccountStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stats == nil {
+		s.stats = make(map[string]*AccountStats)
+	}
+	st, ok := s.stats[account]
+	if !ok {
+		st = &AccountStats{Account: account}
+		s.stats[account] = st
+	}
+	fn(st)
+}
+
+func (s *Scheduler) runAccount(a Account, fn Handler) {
+	s.update(a.UserName, func(st *AccountStats) { st.Running = true })
+	retries := a.Retries
+	if retries == 0 {
+		retries = s.Retries
+	}
+	if retries == 0 {
+		retries = DefaultRetries
+	}
+	delay := durationOr(s.RetryDelay, time.Second)
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var fetched int
+		fetched, err = s.fetchWithTimeout(a, fn)
+		s.update(a.UserName, func(st *AccountStats) { st.Fetched += fetched })
+		if err == nil || retries < 0 || attempt >= retries || !retryable(err) {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	s.update(a.UserName, func(st *AccountStats) {
+		st.Running = false
+		if err != nil {
+			st.Failures++
+			st.ConsecutiveFailures++
+			st.LastError = err.Error()
+			st.LastErrorTime = time.Now()
+			return
+		}
+		st.ConsecutiveFailures = 0
+		st.LastSuccess = time.Now()
+	})
+}
+
+// fetchWithTimeout 超时后断开连接, 让阻塞中的命令立即返回.
+// 还在登录时超时则等登录结束, 最多再等 DefaultLoginTimeout; 之后登录成功的连接由 fetch 自行关闭
+func (s *Scheduler) fetchWithTimeout(a Account, fn Handler) (fetched int, err error) {
+	timeout := a.Timeout
+	if timeout <= 0 {
+		timeout = durationOr(s.Timeout, DefaultAccountTimeout)
+	}
+	var (
+		mu        sync.Mutex
+		terminate func() error
+		timedOut  bool
+	)
+	type result struct {
+		fetched int
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := s.fetch(a, fn, func(fn func() error) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			terminate = fn
+			return !timedOut
+		})
+		done <- result{n, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.fetched, r.err
+	case <-timer.C:
+	}
+	mu.Lock()
+	timedOut = true
+	connected := terminate != nil
+	if connected {
+		terminate()
+	}
+	mu.Unlock()
+	err = fmt.Errorf("%w after %v", ErrAccountTimeout, timeout)
+	if !connected {
+		// 不等登录协程结束的话, 重试时同一个账号会有多个连接
+		wait := time.NewTimer(DefaultLoginTimeout)
+		defer wait.Stop()
+		select {
+		case <-done:
+		case <-wait.C:
+		}
+		return 0, err
+	}
+	r := <-done
+	return r.fetched, err
+}
+
+// fetch 登录并同步账号的全部文件夹. 登录后调用 connected 登记断开连接的方法,
+// 返回 false 表示已经超时
+func (s *Scheduler) fetch(a Account, fn Handler, connected func(terminate func() error) bool) (fetched int, err error) {
+	switch a.Protocol {
+	case "", ProtocolIMAP:
+	case ProtocolPOP3:
+		return s.fetchPOP3(a, fn, connected)
+	default:
+		return 0, fmt.Errorf("account %s: unknown protocol %q", a.UserName, a.Protocol)
+	}
+	c, err := s.Dial(a)
+	if err != nil {
+		return 0, loginError(a.UserName, err)
+	}
+	defer c.Logout()
+	if !connected(c.Terminate) {
+		return 0, ErrAccountTimeout
+	}
+
+	mailboxes, err := accountMailboxes(c, a)
+	if err != nil {
+		return 0, err
+	}
+	criteria, err := a.Criteria.Build()
+	if err != nil {
+		return 0, err
+	}
+	syncer := &Syncer{Client: c, Account: a.UserName, Store: s.Store, Since: s.Since, Criteria: criteria,
+		Actions: a.Actions, Partial: a.Partial}
+	for _, name := range mailboxes {
+		res, err := syncer.Sync(name, fn)
+		fetched += res.Fetched
+		if err != nil {
+			return fetched, err
+		}
+	}
+	return fetched, nil
+}
+
+// fetchPOP3 收取 POP3 账号的收件箱, 配置了 Actions.Delete 时处理后删除
+func (s *Scheduler) fetchPOP3(a Account, fn Handler, connected func(terminate func() error) bool) (int, error) {
+	if s.DialPOP3 == nil {
+		return 0, fmt.Errorf("account %s: pop3 is not configured", a.UserName)
+	}
+	c, err := s.DialPOP3(a)
+	if err != nil {
+		return 0, loginError(a.UserName, err)
+	}
+	if !connected(c.Close) {
+		c.Close()
+		return 0, ErrAccountTimeout
+	}
+	src := &POP3Source{Client: c, Account: a.UserName, Store: s.Store}
+	fetched, err := SyncSource(src, "INBOX", a.Actions != nil && a.Actions.Delete, fn)
+	if err != nil {
+		// 出错时不提交删除, 已处理的邮件按 UIDL 跳过
+		c.Close()
+		return fetched, err
+	}
+	return fetched, src.Close()
+}
+
+// accountMailboxes 账号要收取的文件夹, 去掉重复的
+func accountMailboxes(c *client.Client, a Account) ([]string, error) {
+	names := append([]string(nil), a.Mailboxes...)
+	if a.Folders != nil {
+		folders, err := ListFolders(c)
+		if err != nil {
+			return nil, fmt.Errorf("list folders: %w", err)
+		}
+		for _, f := range a.Folders.Filter(folders) {
+			names = append(names, f.Name)
+		}
+	}
+	if len(names) == 0 {
+		return []string{"INBOX"}, nil
+	}
+	seen := make(map[string]bool)
+	out := names[:0]
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+	return out, nil
+}
+
+// retryable 只有网络错误、连接断开和超时可以重试. 服务器拒绝登录 (如密码错误)、
+// 配置错误和 Handler 的错误重试也没用
+func retryable(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, ErrAccountTimeout)
+}
@@ -0,0 +1,91 @@
+package mailbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+)
+
+func TestAccountTimeoutJSON(t *testing.T) {
+	var a Account
+	err := json.Unmarshal([]byte(`{"user_name":"hr@example.com","server":"imap.example.com:993","timeout":"90s","retries":1}`), &a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Timeout != 90*time.Second || a.UserName != "hr@example.com" || a.Retries != 1 {
+		t.Fatalf("decoded %+v", a)
+	}
+
+	data, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"timeout":"1m30s"`) {
+		t.Errorf("encoded %s, want timeout 1m30s", data)
+	}
+	var b Account
+	if err = json.Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Timeout != a.Timeout || b.Server != a.Server {
+		t.Errorf("round trip %+v, want %+v", b, a)
+	}
+
+	var none Account
+	if err = json.Unmarshal([]byte(`{"user_name":"hr@example.com"}`), &none); err != nil || none.Timeout != 0 {
+		t.Errorf("without timeout: %v, %v", none.Timeout, err)
+	}
+	for _, bad := range []string{`"10"`, `"-1m"`, `600`} {
+		if err = json.Unmarshal([]byte(`{"timeout":`+bad+`}`), &none); err == nil {
+			t.Errorf("timeout %s accepted", bad)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{fmt.Errorf("uid fetch: %w", io.ErrUnexpectedEOF), true},
+		{loginError("hr", io.EOF), true},
+		{fmt.Errorf("%w after 1s", ErrAccountTimeout), true},
+		{&LoginError{Account: "hr", Status: StatusAuth, Err: errors.New("invalid password")}, false},
+		{fmt.Errorf("account hr: unknown protocol %q", "smtp"), false},
+		{errors.New("handler: save resume failed"), false},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.err); got != tt.want {
+			t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFetchWithTimeoutWaitsForLogin(t *testing.T) {
+	var dialing int32
+	s := &Scheduler{
+		Timeout: 10 * time.Millisecond,
+		Dial: func(a Account) (*client.Client, error) {
+			atomic.StoreInt32(&dialing, 1)
+			time.Sleep(100 * time.Millisecond)
+			atomic.StoreInt32(&dialing, 0)
+			return nil, errors.New("dial timed out")
+		},
+	}
+	_, err := s.fetchWithTimeout(Account{UserName: "hr"}, func(*Message) error { return nil })
+	if !errors.Is(err, ErrAccountTimeout) {
+		t.Fatalf("err = %v, want ErrAccountTimeout", err)
+	}
+	if atomic.LoadInt32(&dialing) != 0 {
+		t.Error("returned while the login was still running")
+	}
+}
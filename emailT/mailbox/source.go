@@ -0,0 +1,267 @@
+package mailbox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"studyGo/emailT/pop3"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// MessageRef MailSource.List 返回的一封邮件
+type MessageRef struct {
+	Mailbox string
+	// ID 来源内唯一且不变的编号: IMAP 为 UID, POP3 为 UIDL
+	ID string
+
+	uid uint32
+	// num POP3 本次会话中的编号
+	num  int
+	size int64
+}
+
+// MailSource 邮件来源的统一接口, 由 IMAP 和 POP3 实现.
+// 邮件必须按 List 返回的顺序处理
+type MailSource interface {
+	// List 列出文件夹中还没有处理过的邮件, 按到达顺序排列
+	List(mailbox string) ([]MessageRef, error)
+	// Fetch 取一封完整的邮件, Body 在下一次调用之前有效
+	Fetch(ref MessageRef) (*Message, error)
+	// Delete 从服务器删除邮件
+	Delete(ref MessageRef) error
+	// Checkpoint 记录邮件已处理, 之后 List 不再返回
+	Checkpoint(ref MessageRef) error
+	// Close 结束会话, POP3 的删除在这时才生效
+	Close() error
+}
+
+// SyncSource 取出来源中的新邮件交给 fn, 成功后记录进度, del 为 true 时
+// 同时从服务器删除. fn 返回错误时停止, 该邮件下次重新处理
+func SyncSource(src MailSource, mailbox string, del bool, fn Handler) (fetched int, err error) {
+	refs, err := src.List(mailbox)
+	if err != nil {
+		return 0, err
+	}
+	for _, ref := range refs {
+		msg, err := src.Fetch(ref)
+		if err != nil {
+			return fetched, err
+		}
+		if err = fn(msg); err != nil {
+			return fetched, err
+		}
+		if del {
+			if err = src.Delete(ref); err != nil {
+				return fetched, err
+			}
+		}
+		if err = src.Checkpoint(ref); err != nil {
+			return fetched, err
+		}
+		fetched++
+	}
+	return fetched, nil
+}
+
+// IMAPSource IMAP 的 MailSource, 进度与 Syncer 相同, 按 UIDVALIDITY 和 UID 记录
+type IMAPSource struct {
+	Client  *client.Client
+	Account string
+	Store   CheckpointStore
+	// Since、Criteria 见 Syncer
+	Since    time.Time
+	Criteria *imap.SearchCriteria
+	// ExpungeAll 见 Actions.ExpungeAll
+	ExpungeAll bool
+
+	mailbox string
+	cp      Checkpoint
+}
+
+func (s *IMAPSource) syncer() *Syncer {
+	return &Syncer{Client: s.Client, Account: s.Account, Store: s.Store, Since: s.Since, Criteria: s.Criteria}
+}
+
+// List 选择文件夹, 列出上次进度之后符合条件的邮件
+func (s *IMAPSource) List(mailbox string) ([]MessageRef, error) {
+	if mailbox == "" {
+		mailbox = "INBOX"
+	}
+	mbox, err := s.Client.Select(mailbox, false)
+	if err != nil {
+		return nil, fmt.Errorf("select %s: %w", mailbox, err)
+	}
+	cp, ok, err := s.Store.Load(s.Account, mailbox)
+	if err != nil {
+		return nil, err
+	}
+	full := !ok || cp.UIDValidity != mbox.UidValidity
+	if full {
+		cp = Checkpoint{UIDValidity: mbox.UidValidity}
+	}
+	s.mailbox, s.cp = mailbox, cp
+	if mbox.Messages == 0 {
+		return nil, nil
+	}
+	uids, err := s.syncer().newUIDs(cp.LastUID, full)
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]MessageRef, len(uids))
+	for i, uid := range uids {
+		refs[i] = MessageRef{Mailbox: mailbox, ID: strconv.FormatUint(uint64(uid), 10), uid: uid}
+	}
+	return refs, nil
+}
+
+// Fetch 取一封邮件, 不会标记为已读
+func (s *IMAPSource) Fetch(ref MessageRef) (msg *Message, err error) {
+	if err = s.check(ref); err != nil {
+		return nil, err
+	}
+	sy := s.syncer()
+	err = sy.fetch([]uint32{ref.uid}, func(m *imap.Message) error {
+		msg = &Message{
+			Account:      s.Account,
+			Mailbox:      ref.Mailbox,
+			UIDValidity:  s.cp.UIDValidity,
+			UID:          m.Uid,
+			Flags:        m.Flags,
+			Size:         m.Size,
+			InternalDate: m.InternalDate,
+		}
+		return sy.content(m, msg)
+	})
+	if err == nil && msg == nil {
+		err = fmt.Errorf("uid %d: message not found", ref.uid)
+	}
+	return msg, err
+}
+
+// Delete 标记删除并清除, 服务器不支持 UIDPLUS 时见 ExpungeAll
+func (s *IMAPSource) Delete(ref MessageRef) error {
+	if err := s.check(ref); err != nil {
+		return err
+	}
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(ref.uid)
+	return (&Actions{ExpungeAll: s.ExpungeAll}).expunge(s.Client, seqset)
+}
+
+// Checkpoint 把进度推进到该邮件
+func (s *IMAPSource) Checkpoint(ref MessageRef) error {
+	if err := s.check(ref); err != nil {
+		return err
+	}
+	if ref.uid > s.cp.LastUID {
+		s.cp.LastUID = ref.uid
+	}
+	s.cp.UpdatedAt = time.Now()
+	return s.Store.Save(s.Account, s.mailbox, s.cp)
+}
+
+// Close 退出登录
+func (s *IMAPSource) Close() error {
+	return s.Client.Logout()
+}
+
+// check 邮件必须来自最近一次 List 的文件夹
+func (s *IMAPSource) check(ref MessageRef) error {
+	if ref.Mailbox != s.mailbox || ref.uid == 0 {
+		return fmt.Errorf("message %s/%s is not from the selected mailbox %q", ref.Mailbox, ref.ID, s.mailbox)
+	}
+	return nil
+}
+
+// POP3Source POP3 的 MailSource. POP3 只有收件箱, 按 UIDL 记录已处理的邮件;
+// 服务器上已经不存在的 UIDL 会从进度中清除
+type POP3Source struct {
+	Client  *pop3.Client
+	Account string
+	Store   CheckpointStore
+
+	cp   Checkpoint
+	seen map[string]bool
+}
+
+// List 列出 UIDL 没有处理过的邮件, mailbox 只能为空或 INBOX
+func (s *POP3Source) List(mailbox string) ([]MessageRef, error) {
+	if mailbox != "" && !strings.EqualFold(mailbox, "INBOX") {
+		return nil, fmt.Errorf("pop3: no mailbox %q, only INBOX", mailbox)
+	}
+	list, err := s.Client.UIDL()
+	if err != nil {
+		return nil, fmt.Errorf("uidl: %w", err)
+	}
+	cp, _, err := s.Store.Load(s.Account, "INBOX")
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool, len(list))
+	for _, m := range list {
+		exists[m.UID] = true
+	}
+	s.seen = make(map[string]bool, len(cp.Seen))
+	var seen []string
+	for _, uid := range cp.Seen {
+		if exists[uid] && !s.seen[uid] {
+			s.seen[uid] = true
+			seen = append(seen, uid)
+		}
+	}
+	s.cp = cp
+	s.cp.Seen = seen
+
+	var refs []MessageRef
+	for _, m := range list {
+		if m.UID == "" {
+			return nil, fmt.Errorf("uidl: message %d has no unique id", m.ID)
+		}
+		if !s.seen[m.UID] {
+			refs = append(refs, MessageRef{Mailbox: "INBOX", ID: m.UID, num: m.ID, size: m.Size})
+		}
+	}
+	return refs, nil
+}
+
+// Fetch 用 RETR 取一封邮件
+func (s *POP3Source) Fetch(ref MessageRef) (*Message, error) {
+	if ref.num == 0 {
+		return nil, fmt.Errorf("pop3: message %s is not from List", ref.ID)
+	}
+	body, err := s.Client.Retr(ref.num)
+	if err != nil {
+		return nil, fmt.Errorf("retr %d: %w", ref.num, err)
+	}
+	return &Message{Account: s.Account, Mailbox: "INBOX", UIDL: ref.ID, Size: uint32(ref.size), Body: body}, nil
+}
+
+// Delete 标记删除, Close 之后才真正删除
+func (s *POP3Source) Delete(ref MessageRef) error {
+	if ref.num == 0 {
+		return fmt.Errorf("pop3: message %s is not from List", ref.ID)
+	}
+	return s.Client.Dele(ref.num)
+}
+
+// Checkpoint 记录 UIDL 已处理
+func (s *POP3Source) Checkpoint(ref MessageRef) error {
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	if !s.seen[ref.ID] {
+		s.seen[ref.ID] = true
+		s.cp.Seen = append(s.cp.Seen, ref.ID)
+	}
+	s.cp.UpdatedAt = time.Now()
+	return s.Store.Save(s.Account, "INBOX", s.cp)
+}
+
+// Close 用 QUIT 结束会话, 提交删除
+func (s *POP3Source) Close() error {
+	return s.Client.Quit()
+}
@@ -0,0 +1,129 @@
+package mailbox
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"studyGo/emailT/pop3"
+	"studyGo/emailT/pop3/pop3test"
+)
+
+// newPOP3Source 连接 POP3 测试服务器
+func newPOP3Source(t *testing.T, s *pop3test.Server, store CheckpointStore) *POP3Source {
+	t.Helper()
+	c, err := pop3.Dial(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Auth("hr", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return &POP3Source{Client: c, Account: "hr", Store: store}
+}
+
+// collectUIDL 记录处理过的 UIDL 的 Handler
+func collectUIDL(uidls *[]string) Handler {
+	return func(msg *Message) error {
+		if _, err := ioutil.ReadAll(msg.Body); err != nil {
+			return err
+		}
+		*uidls = append(*uidls, msg.UIDL)
+		return nil
+	}
+}
+
+func TestPOP3SourceSkipsSeen(t *testing.T) {
+	s, err := pop3test.NewServer("hr", "secret",
+		pop3test.Message{UID: "a", Body: "Subject: a\r\n\r\na\r\n"},
+		pop3test.Message{UID: "b", Body: "Subject: b\r\n\r\nb\r\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	store := newTestStore(t)
+
+	var uidls []string
+	n, err := SyncSource(newPOP3Source(t, s, store), "", false, collectUIDL(&uidls))
+	if err != nil || n != 2 || fmt.Sprint(uidls) != "[a b]" {
+		t.Fatalf("first sync: %d %v %v", n, uidls, err)
+	}
+
+	// 新会话中编号会变化, 只按 UIDL 判断
+	uidls = nil
+	src := newPOP3Source(t, s, store)
+	refs, err := src.List("INBOX")
+	if err != nil || len(refs) != 0 {
+		t.Fatalf("List after sync = %v, %v; want nothing new", refs, err)
+	}
+	if _, err = src.List("Sent"); err == nil {
+		t.Error("List of a folder other than INBOX succeeded")
+	}
+
+	// 服务器上删除了 a, 来了 c
+	other := newPOP3Source(t, s, store).Client
+	if err = other.Dele(1); err != nil {
+		t.Fatal(err)
+	}
+	if err = other.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	s.Deliver(pop3test.Message{UID: "c", Body: "Subject: c\r\n\r\nc\r\n"})
+	src = newPOP3Source(t, s, store)
+	if n, err = SyncSource(src, "", true, collectUIDL(&uidls)); err != nil || n != 1 {
+		t.Fatalf("second sync: %d %v", n, err)
+	}
+	if fmt.Sprint(uidls) != "[c]" {
+		t.Errorf("handled %v, want [c]", uidls)
+	}
+	if err = src.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Messages(); len(got) != 1 || got[0].UID != "b" {
+		t.Errorf("server has %v after delete, want only b", got)
+	}
+	// 已经不在服务器上的 UIDL 从进度中清除
+	cp, _, _ := store.Load("hr", "INBOX")
+	if fmt.Sprint(cp.Seen) != "[b c]" {
+		t.Errorf("seen = %v, want [b c]", cp.Seen)
+	}
+}
+
+func TestPOP3SourcePartialRead(t *testing.T) {
+	body := "Subject: %s\r\n\r\n" + strings.Repeat("resume line\r\n.dotted\r\n", 1000)
+	s, err := pop3test.NewServer("hr", "secret",
+		pop3test.Message{UID: "a", Body: fmt.Sprintf(body, "a")},
+		pop3test.Message{UID: "b", Body: fmt.Sprintf(body, "b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// 只读邮件头就删除, 剩下的正文不能影响后面的命令
+	var subjects []string
+	src := newPOP3Source(t, s, newTestStore(t))
+	n, err := SyncSource(src, "", true, func(msg *Message) error {
+		h, err := textproto.NewReader(bufio.NewReaderSize(msg.Body, 16)).ReadMIMEHeader()
+		if err != nil {
+			return err
+		}
+		subjects = append(subjects, h.Get("Subject"))
+		return nil
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("sync: %d %v", n, err)
+	}
+	if fmt.Sprint(subjects) != "[a b]" {
+		t.Errorf("subjects %v, want [a b]", subjects)
+	}
+	if err = src.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Messages(); len(got) != 0 {
+		t.Errorf("server has %d messages after delete, want none", len(got))
+	}
+}
@@ -0,0 +1,225 @@
+package mailbox
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// DefaultBatchSize 每次 UID FETCH 的邮件数
+const DefaultBatchSize = 20
+
+// Message 同步到的一封邮件
+type Message struct {
+	Account     string
+	Mailbox     string
+	UIDValidity uint32
+	UID         uint32
+	// UIDL POP3 邮件的唯一编号, IMAP 邮件为空
+	UIDL  string
+	Flags []string
+	Size  uint32
+	// InternalDate 服务器收到邮件的时间
+	InternalDate time.Time
+	// Body 完整的原始邮件, 只在回调期间有效; 两阶段收取时为空
+	Body io.Reader
+	// Partial 两阶段收取的结果, 见 Syncer.Partial
+	Partial *PartialMessage
+}
+
+// Handler 处理一封邮件. 返回错误时同步停止, 进度停在上一封邮件
+type Handler func(msg *Message) error
+
+// SyncResult 一次同步的结果
+type SyncResult struct {
+	Mailbox     string
+	UIDValidity uint32
+	// Full 没有进度或 UIDVALIDITY 变化, 进行了全量同步
+	Full    bool
+	Fetched int
+	LastUID uint32
+}
+
+// Syncer 按 UIDVALIDITY 和 UID 增量同步文件夹, 每处理完一封邮件保存一次进度
+type Syncer struct {
+	Client  *client.Client
+	Account string
+	Store   CheckpointStore
+	// Since 全量同步时只取该时间之后收到的邮件, 为零时取全部
+	Since time.Time
+	// Criteria 服务器端的搜索条件, 只同步符合条件的邮件, 为空时同步全部
+	Criteria *imap.SearchCriteria
+	// Actions 每封邮件处理成功后在服务器上执行的操作, 执行成功后才保存进度
+	Actions *Actions
+	// Partial 不为空时两阶段收取, 只取需要的正文和附件, 否则取完整邮件
+	Partial *PartialFetch
+	// BatchSize 每次 UID FETCH 的邮件数, 为 0 时使用 DefaultBatchSize
+	BatchSize int
+	// afterSelect SELECT 成功后调用, Watcher 用它区分 SELECT 产生的 EXISTS
+	afterSelect func()
+}
+
+// Sync 同步一个文件夹中上次之后的新邮件, 按 UID 从小到大交给 fn
+func (s *Syncer) Sync(mailbox string, fn Handler) (res SyncResult, err error) {
+	res.Mailbox = mailbox
+	mbox, err := s.Client.Select(mailbox, false)
+	if err != nil {
+		return res, fmt.Errorf("select %s: %w", mailbox, err)
+	}
+	if s.afterSelect != nil {
+		s.afterSelect()
+	}
+	res.UIDValidity = mbox.UidValidity
+
+	cp, ok, err := s.Store.Load(s.Account, mailbox)
+	if err != nil {
+		return res, err
+	}
+	if !ok || cp.UIDValidity != mbox.UidValidity {
+		// UIDVALIDITY 变化后原来的 UID 全部失效, 重新同步
+		res.Full = true
+		cp = Checkpoint{UIDValidity: mbox.UidValidity}
+	}
+	res.LastUID = cp.LastUID
+	if mbox.Messages == 0 || mbox.UidNext != 0 && cp.LastUID+1 >= mbox.UidNext {
+		return res, s.save(mailbox, cp)
+	}
+
+	// 不符合条件的邮件不会再处理, 全部处理完后把进度推进到 SELECT 时的 UIDNEXT 之前
+	defer func() {
+		if err != nil {
+			return
+		}
+		if mbox.UidNext > 0 && cp.LastUID < mbox.UidNext-1 {
+			cp.LastUID = mbox.UidNext - 1
+			res.LastUID = cp.LastUID
+		}
+		err = s.save(mailbox, cp)
+	}()
+	uids, err := s.newUIDs(cp.LastUID, res.Full)
+	if err != nil {
+		return res, err
+	}
+	size := s.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	for len(uids) > 0 {
+		n := size
+		if n > len(uids) {
+			n = len(uids)
+		}
+		batch := uids[:n]
+		uids = uids[n:]
+		err = s.fetch(batch, func(m *imap.Message) error {
+			msg := &Message{
+				Account:      s.Account,
+				Mailbox:      mailbox,
+				UIDValidity:  mbox.UidValidity,
+				UID:          m.Uid,
+				Flags:        m.Flags,
+				Size:         m.Size,
+				InternalDate: m.InternalDate,
+			}
+			if err := s.content(m, msg); err != nil {
+				return err
+			}
+			if err := fn(msg); err != nil {
+				return err
+			}
+			if err := s.Actions.Apply(s.Client, m.Uid); err != nil {
+				return err
+			}
+			cp.LastUID = m.Uid
+			res.LastUID = m.Uid
+			res.Fetched++
+			return s.save(mailbox, cp)
+		})
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+func (s *Syncer) save(mailbox string, cp Checkpoint) error {
+	cp.UpdatedAt = time.Now()
+	return s.Store.Save(s.Account, mailbox, cp)
+}
+
+// newUIDs 查询 lastUID 之后的 UID, 按从小到大排序
+func (s *Syncer) newUIDs(lastUID uint32, full bool) ([]uint32, error) {
+	criteria := imap.NewSearchCriteria()
+	if s.Criteria != nil {
+		c := *s.Criteria
+		criteria = &c
+	}
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(lastUID+1, 0)
+	if full && s.Since.After(criteria.Since) {
+		criteria.Since = s.Since
+	}
+	uids, err := s.Client.UidSearch(criteria)
+	if err != nil {
+		return nil, fmt.Errorf("uid search: %w", err)
+	}
+	// n:* 至少会返回最后一封邮件, 即使它的 UID 小于 n
+	out := uids[:0]
+	for _, uid := range uids {
+		if uid > lastUID {
+			out = append(out, uid)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out, nil
+}
+
+// bodySection 收取完整邮件时取的内容
+var bodySection = &imap.BodySectionName{Peek: true}
+
+// content 填充邮件内容: 完整邮件或两阶段收取的结果
+func (s *Syncer) content(m *imap.Message, msg *Message) (err error) {
+	if s.Partial != nil {
+		msg.Partial, err = s.Partial.Fetch(s.Client, m)
+		return err
+	}
+	if msg.Body = m.GetBody(bodySection); msg.Body == nil {
+		return fmt.Errorf("uid %d: server returned no body", m.Uid)
+	}
+	return nil
+}
+
+// fetch 取一批邮件, 服务器返回的顺序不一定按 UID, 整批取完后按 UID 排序处理.
+// 两阶段收取时这里只取 BODYSTRUCTURE 和邮件头
+func (s *Syncer) fetch(uids []uint32, fn func(m *imap.Message) error) error {
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uids...)
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchFlags, imap.FetchInternalDate, imap.FetchRFC822Size, bodySection.FetchItem()}
+	if s.Partial != nil {
+		items = s.Partial.fetchItems()
+	}
+
+	messages := make(chan *imap.Message, len(uids))
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Client.UidFetch(seqset, items, messages)
+	}()
+	var list []*imap.Message
+	for m := range messages {
+		list = append(list, m)
+	}
+	if err := <-done; err != nil {
+		return fmt.Errorf("uid fetch: %w", err)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Uid < list[j].Uid })
+	for _, m := range list {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package mailbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+)
+
+// newTestServer 启动使用 go-imap 内存后端的 IMAP 服务器, 账号为 username/password,
+// INBOX 中有一封 UID 为 6 的邮件
+func newTestServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(memory.New())
+	s.AllowInsecureAuth = true
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+// dialTestServer 连接并登录测试服务器
+func dialTestServer(t *testing.T, addr string) *client.Client {
+	t.Helper()
+	c, err := client.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Logout() })
+	return c
+}
+
+// appendMessage 向文件夹追加一封邮件
+func appendMessage(t *testing.T, c *client.Client, mailbox, subject string) {
+	t.Helper()
+	body := fmt.Sprintf("From: hr@example.com\r\nTo: username@example.com\r\nSubject: %s\r\n"+
+		"Message-ID: <%s@example.com>\r\nContent-Type: text/plain\r\n\r\nbody of %s\r\n", subject, subject, subject)
+	if err := c.Append(mailbox, nil, time.Now(), bytes.NewBufferString(body)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestStore(t *testing.T) *FileCheckpointStore {
+	t.Helper()
+	store, err := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// collect 记录处理过的 UID 的 Handler
+func collect(uids *[]uint32) Handler {
+	return func(msg *Message) error {
+		if _, err := ioutil.ReadAll(msg.Body); err != nil {
+			return err
+		}
+		*uids = append(*uids, msg.UID)
+		return nil
+	}
+}
+
+func TestSyncIncremental(t *testing.T) {
+	c := dialTestServer(t, newTestServer(t))
+	store := newTestStore(t)
+	s := &Syncer{Client: c, Account: "username", Store: store}
+
+	var uids []uint32
+	res, err := s.Sync("INBOX", collect(&uids))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Full || res.Fetched != 1 || res.LastUID != 6 {
+		t.Fatalf("first sync = %+v, want full sync of uid 6", res)
+	}
+
+	appendMessage(t, c, "INBOX", "second")
+	appendMessage(t, c, "INBOX", "third")
+	uids = nil
+	if res, err = s.Sync("INBOX", collect(&uids)); err != nil {
+		t.Fatal(err)
+	}
+	if res.Full || res.Fetched != 2 || res.LastUID != 8 {
+		t.Fatalf("second sync = %+v, want 2 new messages up to uid 8", res)
+	}
+	if fmt.Sprint(uids) != "[7 8]" {
+		t.Errorf("handled uids %v, want [7 8]", uids)
+	}
+
+	uids = nil
+	if res, err = s.Sync("INBOX", collect(&uids)); err != nil {
+		t.Fatal(err)
+	}
+	if res.Fetched != 0 || len(uids) != 0 {
+		t.Errorf("sync without new mail fetched %v", uids)
+	}
+	cp, ok, _ := store.Load("username", "INBOX")
+	if !ok || cp.UIDValidity != 1 || cp.LastUID != 8 {
+		t.Errorf("checkpoint = %+v", cp)
+	}
+}
+
+func TestSyncUIDValidityChanged(t *testing.T) {
+	c := dialTestServer(t, newTestServer(t))
+	store := newTestStore(t)
+	// 上次同步时的 UIDVALIDITY 与服务器不同, 原来的 UID 已经失效
+	store.Save("username", "INBOX", Checkpoint{UIDValidity: 99, LastUID: 100})
+	s := &Syncer{Client: c, Account: "username", Store: store}
+
+	var uids []uint32
+	res, err := s.Sync("INBOX", collect(&uids))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Full || res.UIDValidity != 1 {
+		t.Errorf("result = %+v, want full sync", res)
+	}
+	if fmt.Sprint(uids) != "[6]" {
+		t.Errorf("handled uids %v, want [6]", uids)
+	}
+	cp, _, _ := store.Load("username", "INBOX")
+	if cp.UIDValidity != 1 || cp.LastUID != 6 {
+		t.Errorf("checkpoint = %+v, want validity 1 last uid 6", cp)
+	}
+}
+
+func TestSyncCheckpointAfterHandler(t *testing.T) {
+	c := dialTestServer(t, newTestServer(t))
+	appendMessage(t, c, "INBOX", "second")
+	appendMessage(t, c, "INBOX", "third")
+	store := newTestStore(t)
+	s := &Syncer{Client: c, Account: "username", Store: store}
+
+	errFailed := errors.New("handler failed")
+	var uids []uint32
+	_, err := s.Sync("INBOX", func(msg *Message) error {
+		// 处理之前进度不能已经越过这封邮件
+		if cp, _, _ := store.Load("username", "INBOX"); cp.LastUID >= msg.UID {
+			t.Errorf("checkpoint %d saved before uid %d was handled", cp.LastUID, msg.UID)
+		}
+		if msg.UID == 7 {
+			return errFailed
+		}
+		uids = append(uids, msg.UID)
+		return nil
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("Sync err = %v, want handler error", err)
+	}
+	cp, _, _ := store.Load("username", "INBOX")
+	if cp.LastUID != 6 {
+		t.Fatalf("checkpoint after failure = %d, want 6", cp.LastUID)
+	}
+
+	// 下次从失败的邮件继续
+	uids = nil
+	res, err := s.Sync("INBOX", collect(&uids))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Full || fmt.Sprint(uids) != "[7 8]" {
+		t.Errorf("resumed sync handled %v (full %v), want [7 8]", uids, res.Full)
+	}
+}
@@ -0,0 +1,270 @@
+package mailbox
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// 默认的轮询、IDLE 和重连参数
+const (
+	DefaultPollInterval = time.Minute
+	// DefaultIdleTimeout RFC 2177 建议不超过 29 分钟重新发起一次 IDLE
+	DefaultIdleTimeout = 25 * time.Minute
+	DefaultMinBackoff  = time.Second
+	DefaultMaxBackoff  = 5 * time.Minute
+)
+
+// errStopped Run 被 stop 结束
+var errStopped = errors.New("watcher stopped")
+
+// Watcher 长时间运行的收件程序: 服务器支持 IDLE 时等待推送, 否则按间隔 NOOP
+// 轮询, 有新邮件时增量同步并交给回调. 连接断开后按指数退避自动重连
+type Watcher struct {
+	// Dial 建立已登录的连接, 每次重连都会调用
+	Dial    func() (*client.Client, error)
+	Account string
+	// Mailbox 监听的文件夹, 为空时为 INBOX
+	Mailbox string
+	Store   CheckpointStore
+	// Since 第一次同步时只取该时间之后收到的邮件, 见 Syncer.Since
+	Since time.Time
+	// Criteria 服务器端的搜索条件
+	Criteria *imap.SearchCriteria
+	// Actions 邮件处理成功后在服务器上执行的操作. 使用 Messages 时邮件
+	// 被接收即视为处理成功
+	Actions *Actions
+	// Partial 不为空时两阶段收取, 见 Syncer.Partial
+	Partial *PartialFetch
+	// PollInterval 服务器不支持 IDLE 时 NOOP 轮询的间隔
+	PollInterval time.Duration
+	// IdleTimeout 重新发起 IDLE 的间隔
+	IdleTimeout time.Duration
+	// MinBackoff、MaxBackoff 重连等待时间的下限和上限
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnError 连接或处理出错时调用, 之后会重连; 为空时写日志
+	OnError func(err error)
+}
+
+// Run 运行直到 stop 关闭. fn 返回错误时该邮件不计入进度, 重连后重新处理
+func (w *Watcher) Run(stop <-chan struct{}, fn Handler) error {
+	failures := 0
+	for {
+		synced, err := w.session(stop, fn)
+		if err == errStopped || isStopped(stop) {
+			return nil
+		}
+		if synced {
+			failures = 0
+		}
+		w.reportError(err)
+		failures++
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(w.backoff(failures)):
+		}
+	}
+}
+
+// Messages 以 channel 的形式返回新邮件, 邮件内容已读入内存.
+// 邮件被接收后即计入进度. stop 关闭后 channel 关闭
+func (w *Watcher) Messages(stop <-chan struct{}) <-chan *Message {
+	ch := make(chan *Message)
+	go func() {
+		defer close(ch)
+		w.Run(stop, func(msg *Message) error {
+			m := *msg
+			if msg.Body != nil {
+				data, err := ioutil.ReadAll(msg.Body)
+				if err != nil {
+					return err
+				}
+				m.Body = bytes.NewReader(data)
+			}
+			select {
+			case ch <- &m:
+				return nil
+			case <-stop:
+				return errStopped
+			}
+		})
+	}()
+	return ch
+}
+
+// session 一次连接的生命周期, synced 表示至少完成过一次同步
+func (w *Watcher) session(stop <-chan struct{}, fn Handler) (synced bool, err error) {
+	c, err := w.Dial()
+	if err != nil {
+		return false, err
+	}
+	defer c.Logout()
+
+	// Updates 必须一直有人读, 否则会阻塞 client 的读循环
+	updates := make(chan client.Update, 16)
+	// newMail 收到过 EXISTS, 需要重新同步; 多个通知合并为一个
+	newMail := make(chan struct{}, 1)
+	// selected 同步时 SELECT 完成后放入 updates 的标记. SELECT 本身会产生 EXISTS,
+	// 它们在标记之前, 而且已经包含在 SELECT 的结果中, 遇到标记时丢弃;
+	// 标记之后的 EXISTS 都是新邮件. 丢弃之后通过 drained 通知同步继续
+	selected := &client.StatusUpdate{}
+	drained := make(chan struct{}, 1)
+	c.Updates = updates
+	go func() {
+		for {
+			select {
+			case u := <-updates:
+				if u == client.Update(selected) {
+					select {
+					case <-newMail:
+					default:
+					}
+					drained <- struct{}{}
+					continue
+				}
+				if _, ok := u.(*client.MailboxUpdate); ok {
+					select {
+					case newMail <- struct{}{}:
+					default:
+					}
+				}
+			case <-c.LoggedOut():
+				return
+			}
+		}
+	}()
+
+	mailbox := w.Mailbox
+	if mailbox == "" {
+		mailbox = "INBOX"
+	}
+	syncer := &Syncer{Client: c, Account: w.Account, Store: w.Store, Since: w.Since, Criteria: w.Criteria,
+		Actions: w.Actions, Partial: w.Partial, afterSelect: func() {
+			select {
+			case updates <- selected:
+			case <-c.LoggedOut():
+				return
+			}
+			select {
+			case <-drained:
+			case <-c.LoggedOut():
+			}
+		}}
+	canIdle, err := c.Support("IDLE")
+	if err != nil {
+		return false, err
+	}
+	for {
+		if _, err = syncer.Sync(mailbox, fn); err != nil {
+			return synced, err
+		}
+		synced = true
+		if canIdle {
+			err = w.idle(c, stop, newMail)
+		} else {
+			err = w.poll(c, stop, newMail)
+		}
+		if err != nil {
+			return synced, err
+		}
+	}
+}
+
+// idle 等待新邮件推送, 超过 IdleTimeout 时返回以便重新发起 IDLE
+func (w *Watcher) idle(c *client.Client, stop <-chan struct{}, newMail <-chan struct{}) error {
+	stopIdle := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- idle(c, stopIdle)
+	}()
+	timer := time.NewTimer(durationOr(w.IdleTimeout, DefaultIdleTimeout))
+	defer timer.Stop()
+	var stopped bool
+wait:
+	for {
+		select {
+		case err := <-done:
+			// 服务器主动结束了 IDLE
+			if err == nil {
+				err = errors.New("idle terminated by server")
+			}
+			return err
+		case <-stop:
+			stopped = true
+			break wait
+		case <-newMail:
+			break wait
+		case <-timer.C:
+			break wait
+		}
+	}
+	close(stopIdle)
+	if err := <-done; err != nil {
+		return err
+	}
+	if stopped {
+		return errStopped
+	}
+	return nil
+}
+
+// poll 按间隔 NOOP 后返回重新同步. 有些服务器在 NOOP 时不报告 EXISTS,
+// 所以到时间总是重新同步; 同步前会比较 UIDNEXT, 没有新邮件时开销很小
+func (w *Watcher) poll(c *client.Client, stop <-chan struct{}, newMail <-chan struct{}) error {
+	timer := time.NewTimer(durationOr(w.PollInterval, DefaultPollInterval))
+	defer timer.Stop()
+	select {
+	case <-stop:
+		return errStopped
+	case <-newMail:
+		return nil
+	case <-timer.C:
+		return c.Noop()
+	}
+}
+
+// backoff 第 n 次连续失败后的等待时间, 指数增长并加入随机抖动
+func (w *Watcher) backoff(n int) time.Duration {
+	min := durationOr(w.MinBackoff, DefaultMinBackoff)
+	max := durationOr(w.MaxBackoff, DefaultMaxBackoff)
+	d := min
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (w *Watcher) reportError(err error) {
+	if w.OnError != nil {
+		w.OnError(err)
+		return
+	}
+	log.Printf("watch %s: %v", w.Account, err)
+}
+
+func isStopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
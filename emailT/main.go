@@ -1,88 +1,420 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
 	net_mail "net/mail"
+	"os"
+	"os/signal"
+	"studyGo/emailT/discover"
+	"studyGo/emailT/extract"
+	"studyGo/emailT/mailbox"
+	"studyGo/emailT/pop3"
 	"studyGo/emailT/tools"
+	"studyGo/emailT/vault"
+	"syscall"
 	"time"
 
-	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
-	"github.com/emersion/go-message"
 )
 
-// 登录函数
+// 登录函数, 连接和登录超时返回错误; 失败原因见 mailbox.LoginStatus
 func loginEmail(Eserver, UserName, Password string) (*client.Client, error) {
-	c, err := client.DialTLS(Eserver, nil)
+	return mailbox.Login(Eserver, UserName, Password, mailbox.DefaultLoginTimeout)
+}
+
+// OAuth2 登录, mech 见 mailbox.Account.Auth. 连接和登录的超时与 mailbox.Login 相同
+func loginOAuth(Eserver, UserName, mech string, ts mailbox.TokenSource) (*client.Client, error) {
+	timeout := mailbox.DefaultLoginTimeout
+	c, err := client.DialWithDialerTLS(&net.Dialer{Timeout: timeout}, Eserver, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.Timeout = timeout
+	if err = mailbox.Authenticate(c, mech, UserName, Eserver, ts); err != nil {
+		c.Logout()
+		return nil, err
+	}
+	// 清除登录命令的超时, 之后的命令不限时
+	c.Timeout = 0
+	if err = c.Noop(); err != nil {
+		c.Logout()
+		return nil, err
+	}
+	return c, nil
+}
+
+// POP3 登录, 995 端口直接使用 TLS, 其它端口用 STLS 升级;
+// 不支持 STLS 的服务器只允许 APOP 登录, 避免明文传输密码
+func loginPOP3(Eserver, UserName, Password string, apop bool) (*pop3.Client, error) {
+	host, port, err := net.SplitHostPort(Eserver)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{ServerName: host}
+	var c *pop3.Client
+	if port == "995" {
+		c, err = pop3.DialTLS(Eserver, config)
+	} else {
+		c, err = pop3.Dial(Eserver)
+	}
 	if err != nil {
 		return nil, err
 	}
-	//登陆
-	if err = c.Login(UserName, Password); err != nil {
+	caps, err := c.Capabilities()
+	if err == nil && !c.IsTLS() {
+		if _, ok := caps["STLS"]; ok {
+			err = c.StartTLS(config)
+		} else if !apop {
+			err = fmt.Errorf("%s does not support STLS, refusing plain text password", Eserver)
+		}
+	}
+	if err == nil {
+		if apop {
+			err = c.APOP(UserName, Password)
+		} else {
+			err = c.Auth(UserName, Password)
+		}
+	}
+	if err != nil {
+		c.Close()
 		return nil, err
 	}
 	return c, nil
 }
 
+const (
+	// seenFile 已处理邮件的记录, 用于跳过重复邮件
+	seenFile = "emailT_seen.json"
+	// checkpointFile 各账号各文件夹的同步进度
+	checkpointFile = "emailT_checkpoint.json"
+	// tokenFile OAuth2 账号的 access token 和 refresh token
+	tokenFile = "emailT_tokens.json"
+)
+
+// credentials 解密账号密码的主密钥, 主密钥文件不存在时为空, 只能使用明文密码
+var credentials vault.Vault
+
+// extractor 从招聘网站的通知邮件中提取候选人, 在 main 中初始化
+var extractor *extract.Extractor
+
+// 从 json 文件读取账号列表
+func loadAccounts(path string) (accounts []mailbox.Account, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &accounts)
+	return accounts, err
+}
+
+// 并发收取多个账号, 一个账号出错不影响其它账号
+func fetchAccounts(accounts []mailbox.Account) (err error) {
+	dedup, err := tools.LoadDeduper(seenFile)
+	if err != nil {
+		fmt.Println("load seen file err: ", err)
+		return
+	}
+	store, err := mailbox.NewFileCheckpointStore(checkpointFile)
+	if err != nil {
+		fmt.Println("load checkpoint file err: ", err)
+		return
+	}
+	tokens, err := mailbox.NewFileTokenStore(tokenFile)
+	if err != nil {
+		fmt.Println("load token file err: ", err)
+		return
+	}
+	// 每个 OAuth2 账号一个 TokenSource, 多轮收取之间复用未过期的 access token
+	sources := make(map[string]mailbox.TokenSource)
+	for _, a := range accounts {
+		if mailbox.IsOAuth(a.Auth) && a.OAuth != nil {
+			sources[a.UserName] = &mailbox.RefreshTokenSource{Config: a.OAuth, Account: a.UserName, Store: tokens}
+		}
+	}
+	s := &mailbox.Scheduler{
+		Dial: func(a mailbox.Account) (*client.Client, error) {
+			if !mailbox.IsOAuth(a.Auth) {
+				// 密码在登录前才解密, 不在内存中长期保存明文
+				password, err := vault.Reveal(credentials, a.Password)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", a.UserName, err)
+				}
+				c, err := loginEmail(a.Server, a.UserName, password)
+				return c, vault.RedactError(err, password)
+			}
+			ts, ok := sources[a.UserName]
+			if !ok {
+				return nil, fmt.Errorf("%s 没有 oauth 配置", a.UserName)
+			}
+			return loginOAuth(a.Server, a.UserName, a.Auth, ts)
+		},
+		DialPOP3: func(a mailbox.Account) (*pop3.Client, error) {
+			if mailbox.IsOAuth(a.Auth) {
+				return nil, fmt.Errorf("%s: pop3 不支持 oauth 登录", a.UserName)
+			}
+			password, err := vault.Reveal(credentials, a.Password)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", a.UserName, err)
+			}
+			c, err := loginPOP3(a.Server, a.UserName, password, a.Auth == mailbox.AuthAPOP)
+			return c, vault.RedactError(err, password)
+		},
+		Store: store,
+		Since: time.Now().Add(-7 * 24 * time.Hour),
+	}
+	statuses := s.Run(accounts, handleMessage(dedup))
+	saveSeen(dedup)
+	for _, st := range statuses {
+		if st.LastError != "" && st.ConsecutiveFailures > 0 {
+			fmt.Printf("%s 收取失败: %s\n", st.Account, vault.Redact(st.LastError))
+			continue
+		}
+		fmt.Printf("%s 收取新邮件%d封\n", st.Account, st.Fetched)
+	}
+	return
+}
+
 // 邮件接收
 
-func emailList(Eserver, UserName, Password string) (err error) {
+func emailList(Eserver, UserName, Password string, actions *mailbox.Actions, partial *mailbox.PartialFetch) (err error) {
 	c, err := loginEmail(Eserver, UserName, Password)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	defer c.Close()
-	// 选择收件箱
-	mbox, err := c.Select("INBOX", false)
+	defer c.Logout()
+	dedup, err := tools.LoadDeduper(seenFile)
 	if err != nil {
-		fmt.Println("select inbox err: ", err)
+		fmt.Println("load seen file err: ", err)
 		return
 	}
-	if mbox.Messages == 0 {
+	store, err := mailbox.NewFileCheckpointStore(checkpointFile)
+	if err != nil {
+		fmt.Println("load checkpoint file err: ", err)
 		return
 	}
-	// 选择收取邮件的时间段
-	criteria := imap.NewSearchCriteria()
-	// 收取7天之内的邮件
-	criteria.Since = time.Now().Add(-7 * 24 * time.Hour)
-	// 按条件查询邮件
-	ids, err := c.Search(criteria)
+	syncer := &mailbox.Syncer{
+		Client:  c,
+		Account: UserName,
+		Store:   store,
+		// 第一次同步只收取7天之内的邮件, 之后按 UID 增量收取
+		Since:   time.Now().Add(-7 * 24 * time.Hour),
+		Actions: actions,
+		Partial: partial,
+	}
+	res, err := syncer.Sync("INBOX", handleMessage(dedup))
+	saveSeen(dedup)
 	if err != nil {
 		fmt.Println(err)
-	}
-	if len(ids) == 0 {
 		return
 	}
-	seqset := new(imap.SeqSet)
-	seqset.AddNum(ids...)
-	sect := &imap.BodySectionName{}
-	messages := make(chan *imap.Message, 100)
-	done := make(chan error, 1)
-	go func() {
-		done <- c.Fetch(seqset, []imap.FetchItem{sect.FetchItem()}, messages)
-	}()
-	for msg := range messages {
+	if res.Full {
+		fmt.Printf("%s 全量同步, UIDVALIDITY=%d\n", res.Mailbox, res.UIDValidity)
+	}
+	fmt.Printf("%s 收取新邮件%d封, 最新 UID %d\n", res.Mailbox, res.Fetched, res.LastUID)
+	return
+}
 
-		r := msg.GetBody(sect)
-		m, err := message.Read(r)
-		if err != nil {
-			fmt.Println(err)
-			return err
+// 处理一封新邮件, 重复的邮件跳过
+func handleMessage(dedup *tools.Deduper) mailbox.Handler {
+	return func(msg *mailbox.Message) (err error) {
+		var m *tools.ParsedMessage
+		if msg.Partial != nil {
+			m = msg.Partial.Parsed
+		} else if m, err = tools.ParseMessage(msg.Body); err != nil {
+			return fmt.Errorf("uid %d: %v", msg.UID, err)
+		}
+		fp := tools.NewFingerprint(m)
+		if kind, original := dedup.CheckAndAdd(fp); kind.Skip() {
+			fmt.Printf("跳过重复邮件 %s, 类型:%s, 原邮件:%s\n", fp.MessageID, kind, original)
+			return nil
+		} else if kind == tools.DupNear {
+			fmt.Printf("疑似重复邮件 %s, 原邮件:%s, 继续处理\n", fp.MessageID, original)
 		}
 
 		header := m.Header
 		emailDate, _ := net_mail.ParseDate(header.Get("Date"))
 		subject := tools.GetSubject(header)
 		from := tools.GetFrom(header)
-		// 读取邮件内容
-		// body, _ := tools.ParseBody(m.Body)
 
-		fmt.Printf("%s 在时间为:%v 发送了主题为:%s的邮件\n", from, emailDate, subject)
+		fmt.Printf("%s 在时间为:%v 发送了主题为:%s的邮件, 附件%d个\n", from, emailDate, subject, len(m.Attachments))
+		if extractor != nil && m.HTML != "" {
+			c, err := extractor.Extract(from, subject, m.HTML)
+			if err == nil {
+				candidate, _ := json.Marshal(c)
+				fmt.Println("候选人:", string(candidate))
+			} else if err != extract.ErrNoTemplate {
+				fmt.Println(err)
+			}
+		}
+		return nil
+	}
+}
+
+// 保存已处理邮件的记录, 一次收取结束后调用
+func saveSeen(dedup *tools.Deduper) {
+	if err := dedup.Save(seenFile); err != nil {
+		fmt.Println("save seen file err: ", err)
+	}
+}
+
+// 导入本地的 mbox、Maildir 或 .eml 文件, 与收取的邮件一样处理
+func importEmail(path string) (err error) {
+	dedup, err := tools.LoadDeduper(seenFile)
+	if err != nil {
+		fmt.Println("load seen file err: ", err)
+		return
+	}
+	im := &mailbox.Importer{Account: "import"}
+	n, err := im.Import(path, handleMessage(dedup))
+	saveSeen(dedup)
+	if err != nil {
+		fmt.Println(err)
 	}
+	fmt.Printf("%s 导入邮件%d封\n", path, n)
 	return
 }
 
+// 常驻运行, 有新邮件时立即收取, 直到收到退出信号
+func watchEmail(Eserver, UserName, Password string, actions *mailbox.Actions, partial *mailbox.PartialFetch) (err error) {
+	dedup, err := tools.LoadDeduper(seenFile)
+	if err != nil {
+		fmt.Println("load seen file err: ", err)
+		return
+	}
+	store, err := mailbox.NewFileCheckpointStore(checkpointFile)
+	if err != nil {
+		fmt.Println("load checkpoint file err: ", err)
+		return
+	}
+	w := &mailbox.Watcher{
+		Dial: func() (*client.Client, error) {
+			return loginEmail(Eserver, UserName, Password)
+		},
+		Account: UserName,
+		Store:   store,
+		Since:   time.Now().Add(-7 * 24 * time.Hour),
+		Actions: actions,
+		Partial: partial,
+	}
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		close(stop)
+	}()
+	// 常驻运行时定时保存已处理邮件的记录, 退出时再保存一次
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				saveSeen(dedup)
+			}
+		}
+	}()
+	defer saveSeen(dedup)
+	return w.Run(stop, handleMessage(dedup))
+}
+
 func main() {
-	emailList("imap.exmail.qq.com:993", "username", "password")
+	watch := flag.Bool("watch", false, "常驻运行, 有新邮件时立即收取")
+	accountsFile := flag.String("accounts", "", "账号列表的 json 文件, 并发收取其中的全部账号")
+	importPath := flag.String("import", "", "导入 mbox 文件、Maildir 目录、.eml 文件或含有 .eml 文件的目录")
+	seen := flag.Bool("seen", false, "处理成功后标记为已读")
+	archive := flag.String("archive", "", "处理成功后移动到该文件夹")
+	attachDir := flag.String("attachdir", "", "两阶段收取, 只下载正文和附件, 附件保存到该目录")
+	maxAttach := flag.Uint("maxattach", 20<<20, "两阶段收取时单个附件的最大字节数")
+	templatesFile := flag.String("templates", "", "招聘网站提取模板的 json 文件, 覆盖或补充内置模板")
+	keyFile := flag.String("masterkey", "emailT_master.key", "解密账号密码的主密钥文件")
+	encrypt := flag.String("encrypt", "", "加密密码并输出密文, 用于账号 json 文件的 password 字段")
+	discoverAddr := flag.String("discover", "", "按邮箱地址查找 IMAP 服务器并输出, 用于账号的 server 字段")
+	flag.Parse()
+	log.SetOutput(vault.NewRedactWriter(os.Stderr))
+	if *discoverAddr != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		settings, err := discover.Discover(ctx, *discoverAddr)
+		if err != nil {
+			fmt.Println("discover err: ", err)
+			return
+		}
+		data, _ := json.MarshalIndent(settings, "", "  ")
+		fmt.Println(settings.Addr())
+		fmt.Println(string(data))
+		return
+	}
+	if *encrypt != "" {
+		v, err := vault.OpenOrCreate(*keyFile)
+		if err != nil {
+			fmt.Println("load master key err: ", err)
+			return
+		}
+		ct, err := v.Encrypt(*encrypt)
+		if err != nil {
+			fmt.Println("encrypt err: ", err)
+			return
+		}
+		fmt.Println(ct)
+		return
+	}
+	if v, err := vault.Open(*keyFile); err == nil {
+		credentials = v
+	} else if !os.IsNotExist(err) {
+		fmt.Println("load master key err: ", err)
+		return
+	}
+	var err error
+	if extractor, err = extract.NewExtractor(extract.Templates...); err != nil {
+		fmt.Println("load templates err: ", err)
+		return
+	}
+	if *templatesFile != "" {
+		templates, err := extract.LoadTemplates(*templatesFile)
+		if err != nil {
+			fmt.Println("load templates err: ", err)
+			return
+		}
+		for _, t := range templates {
+			if err = extractor.Add(t); err != nil {
+				fmt.Println("load templates err: ", err)
+				return
+			}
+		}
+	}
+	// 多账号时在 json 中为每个账号单独配置 actions
+	actions := &mailbox.Actions{Seen: *seen, MoveTo: *archive, CreateFolders: true}
+	var partial *mailbox.PartialFetch
+	if *attachDir != "" {
+		partial = &mailbox.PartialFetch{Dir: *attachDir, MaxAttachmentSize: uint32(*maxAttach)}
+	}
+	if *importPath != "" {
+		importEmail(*importPath)
+		return
+	}
+	if *accountsFile != "" {
+		accounts, err := loadAccounts(*accountsFile)
+		if err != nil {
+			fmt.Println("load accounts err: ", err)
+			return
+		}
+		fetchAccounts(accounts)
+		return
+	}
+	if *watch {
+		watchEmail("imap.exmail.qq.com:993", "username", "password", actions, partial)
+		return
+	}
+	emailList("imap.exmail.qq.com:993", "username", "password", actions, partial)
 }
@@ -0,0 +1,300 @@
+package pop3
+
+import (
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrNoAPOP 服务器的问候中没有时间戳, 不支持 APOP
+var ErrNoAPOP = errors.New("pop3: server does not support APOP")
+
+// Error 服务器返回的 -ERR
+type Error struct {
+	// Code RFC 2449 的响应码, 如 AUTH、IN-USE, 没有时为空
+	Code string
+	Text string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("pop3: [%s] %s", e.Code, e.Text)
+	}
+	return "pop3: " + e.Text
+}
+
+// MessageInfo LIST 和 UIDL 返回的邮件信息
+type MessageInfo struct {
+	// ID 本次会话中的编号, 从 1 开始
+	ID   int
+	Size int64
+	// UID UIDL 返回的唯一编号, 跨会话不变
+	UID string
+}
+
+// Client POP3 客户端 (RFC 1939), 支持 UIDL、APOP、CAPA (RFC 2449) 和
+// STLS (RFC 2595). 不能并发使用
+type Client struct {
+	conn net.Conn
+	text *textproto.Conn
+	// timestamp 问候中的 APOP 时间戳, 如 <1896.697170952@dbc.mtview.ca.us>
+	timestamp string
+	isTLS     bool
+	// body Retr 返回的还没读完的邮件
+	body io.Reader
+}
+
+// timestampReg 问候中的 APOP 时间戳
+var timestampReg = regexp.MustCompile(`<[^<>\s]+@[^<>\s]+>`)
+
+// Dial 建立明文连接, 一般再调用 StartTLS
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn)
+}
+
+// DialTLS 建立 TLS 连接, 一般为 995 端口
+func DialTLS(addr string, config *tls.Config) (*Client, error) {
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn)
+}
+
+// NewClient 在已有的连接上读取问候
+func NewClient(conn net.Conn) (*Client, error) {
+	c := &Client{conn: conn, text: textproto.NewConn(conn)}
+	_, c.isTLS = conn.(*tls.Conn)
+	line, err := c.response()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.timestamp = timestampReg.FindString(line)
+	return c, nil
+}
+
+// IsTLS 连接是否已加密
+func (c *Client) IsTLS() bool {
+	return c.isTLS
+}
+
+// Capabilities 用 CAPA 查询服务器能力, 键为大写的能力名称, 值为参数.
+// 服务器不支持 CAPA 时返回空
+func (c *Client) Capabilities() (map[string][]string, error) {
+	lines, err := c.multiline("CAPA")
+	if err != nil {
+		var perr *Error
+		if errors.As(err, &perr) {
+			return map[string][]string{}, nil
+		}
+		return nil, err
+	}
+	caps := make(map[string][]string)
+	for _, l := range lines {
+		f := strings.Fields(l)
+		if len(f) > 0 {
+			caps[strings.ToUpper(f[0])] = f[1:]
+		}
+	}
+	return caps, nil
+}
+
+// StartTLS 用 STLS 把连接升级为 TLS, 必须在登录前调用
+func (c *Client) StartTLS(config *tls.Config) error {
+	if c.isTLS {
+		return errors.New("pop3: already using TLS")
+	}
+	if _, err := c.cmd("STLS"); err != nil {
+		return err
+	}
+	conn := tls.Client(c.conn, config)
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	c.conn = conn
+	c.text = textproto.NewConn(conn)
+	c.isTLS = true
+	return nil
+}
+
+// Auth 用 USER/PASS 登录
+func (c *Client) Auth(user, password string) error {
+	if _, err := c.cmd("USER %s", user); err != nil {
+		return err
+	}
+	_, err := c.cmd("PASS %s", password)
+	return err
+}
+
+// APOP 用 APOP 登录, 密码不会明文传输
+func (c *Client) APOP(user, password string) error {
+	if c.timestamp == "" {
+		return ErrNoAPOP
+	}
+	sum := md5.Sum([]byte(c.timestamp + password))
+	_, err := c.cmd("APOP %s %s", user, hex.EncodeToString(sum[:]))
+	return err
+}
+
+// Stat 邮件数和总字节数
+func (c *Client) Stat() (count int, size int64, err error) {
+	line, err := c.cmd("STAT")
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err = fmt.Sscan(line, &count, &size); err != nil {
+		return 0, 0, fmt.Errorf("pop3: bad STAT response %q", line)
+	}
+	return count, size, nil
+}
+
+// List 全部邮件的编号和大小
+func (c *Client) List() ([]MessageInfo, error) {
+	lines, err := c.multiline("LIST")
+	if err != nil {
+		return nil, err
+	}
+	out := make([]MessageInfo, 0, len(lines))
+	for _, l := range lines {
+		var m MessageInfo
+		if _, err := fmt.Sscan(l, &m.ID, &m.Size); err != nil {
+			return nil, fmt.Errorf("pop3: bad LIST line %q", l)
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// UIDL 全部邮件的编号、大小和唯一编号
+func (c *Client) UIDL() ([]MessageInfo, error) {
+	lines, err := c.multiline("UIDL")
+	if err != nil {
+		return nil, err
+	}
+	uids := make(map[int]string, len(lines))
+	for _, l := range lines {
+		f := strings.Fields(l)
+		if len(f) != 2 {
+			return nil, fmt.Errorf("pop3: bad UIDL line %q", l)
+		}
+		id, err := strconv.Atoi(f[0])
+		if err != nil {
+			return nil, fmt.Errorf("pop3: bad UIDL line %q", l)
+		}
+		uids[id] = f[1]
+	}
+	list, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		list[i].UID = uids[list[i].ID]
+	}
+	return list, nil
+}
+
+// Retr 取一封邮件, 返回的 reader 已去掉点转义, 在下一个命令之前有效.
+// 没读完的部分在下一个命令之前丢弃
+func (c *Client) Retr(id int) (io.Reader, error) {
+	if _, err := c.cmd("RETR %d", id); err != nil {
+		return nil, err
+	}
+	c.body = c.text.DotReader()
+	return c.body, nil
+}
+
+// Dele 标记删除, QUIT 之后才真正删除
+func (c *Client) Dele(id int) error {
+	_, err := c.cmd("DELE %d", id)
+	return err
+}
+
+// Rset 取消本次会话中的删除标记
+func (c *Client) Rset() error {
+	_, err := c.cmd("RSET")
+	return err
+}
+
+// Noop 保持连接
+func (c *Client) Noop() error {
+	_, err := c.cmd("NOOP")
+	return err
+}
+
+// Quit 提交删除并关闭连接
+func (c *Client) Quit() error {
+	_, err := c.cmd("QUIT")
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Close 直接关闭连接, 本次会话中的删除不会生效
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// cmd 发送命令并读取单行响应, 返回 +OK 之后的内容
+func (c *Client) cmd(format string, args ...interface{}) (string, error) {
+	if c.body != nil {
+		// 读到结束的 "." 行, 否则剩下的正文会被当成下一个命令的响应
+		_, err := io.Copy(ioutil.Discard, c.body)
+		c.body = nil
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return c.response()
+}
+
+// multiline 发送命令并读取以 "." 结束的多行响应
+func (c *Client) multiline(format string, args ...interface{}) ([]string, error) {
+	if _, err := c.cmd(format, args...); err != nil {
+		return nil, err
+	}
+	return c.text.ReadDotLines()
+}
+
+func (c *Client) response() (string, error) {
+	line, err := c.text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case strings.HasPrefix(line, "+OK"):
+		return strings.TrimSpace(line[3:]), nil
+	case strings.HasPrefix(line, "-ERR"):
+		return "", parseError(strings.TrimSpace(line[4:]))
+	}
+	return "", fmt.Errorf("pop3: bad response %q", line)
+}
+
+// parseError 解析 -ERR 之后的内容, 如 "[AUTH] invalid password"
+func parseError(text string) *Error {
+	e := &Error{Text: text}
+	if strings.HasPrefix(text, "[") {
+		if i := strings.Index(text, "]"); i > 0 {
+			e.Code = strings.ToUpper(text[1:i])
+			e.Text = strings.TrimSpace(text[i+1:])
+		}
+	}
+	return e
+}
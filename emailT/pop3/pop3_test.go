@@ -0,0 +1,182 @@
+package pop3
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"studyGo/emailT/pop3/pop3test"
+)
+
+func newTestServer(t *testing.T, msgs ...pop3test.Message) *pop3test.Server {
+	t.Helper()
+	s, err := pop3test.NewServer("hr", "secret", msgs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+var testMessages = []pop3test.Message{
+	{UID: "uid-a", Body: "Subject: a\r\n\r\nfirst\r\n"},
+	// 以 "." 开头的行在传输时会转义
+	{UID: "uid-b", Body: "Subject: b\r\n\r\n.dotted line\r\n"},
+	{UID: "uid-c", Body: "Subject: c\r\n\r\nthird\r\n"},
+}
+
+func TestSession(t *testing.T) {
+	s := newTestServer(t, testMessages...)
+	c, err := Dial(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Auth("hr", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	count, size, err := c.Stat()
+	if err != nil || count != 3 {
+		t.Fatalf("Stat = %d %d %v", count, size, err)
+	}
+	list, err := c.UIDL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 {
+		t.Fatalf("UIDL returned %d messages", len(list))
+	}
+	for i, m := range list {
+		want := testMessages[i]
+		if m.ID != i+1 || m.UID != want.UID || m.Size != int64(len(want.Body)) {
+			t.Errorf("UIDL[%d] = %+v, want uid %s size %d", i, m, want.UID, len(want.Body))
+		}
+	}
+
+	r, err := c.Retr(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.ReplaceAll(string(body), "\r\n", "\n"); got != "Subject: b\n\n.dotted line\n" {
+		t.Errorf("Retr(2) = %q", body)
+	}
+	// 读完邮件后连接可以继续使用
+	if err = c.Noop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.Dele(1); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Dele(1); err == nil {
+		t.Error("deleting a deleted message succeeded")
+	}
+	if len(s.Messages()) != 3 {
+		t.Error("message deleted before QUIT")
+	}
+	if err = c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(s.Messages()); !strings.Contains(got, "uid-b") || strings.Contains(got, "uid-a") {
+		t.Errorf("after QUIT server has %s, want uid-a deleted", got)
+	}
+}
+
+func TestCloseWithoutQuit(t *testing.T) {
+	s := newTestServer(t, testMessages...)
+	c, err := Dial(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Auth("hr", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Dele(1); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if len(s.Messages()) != 3 {
+		t.Error("deletion committed without QUIT")
+	}
+}
+
+func TestAPOP(t *testing.T) {
+	s := newTestServer(t)
+	c, err := Dial(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err = c.APOP("hr", "wrong"); err == nil {
+		t.Fatal("APOP with wrong password succeeded")
+	}
+	var perr *Error
+	if !errors.As(err, &perr) || perr.Code != "AUTH" {
+		t.Errorf("err = %#v, want [AUTH] error", err)
+	}
+	if err = c.APOP("hr", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range s.Commands() {
+		if strings.Contains(cmd, "secret") {
+			t.Errorf("password sent in %q", cmd)
+		}
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	s := newTestServer(t, testMessages...)
+	s.RequireTLS(true)
+	c, err := Dial(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	caps, err := c.Capabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := caps["STLS"]; !ok {
+		t.Fatalf("capabilities %v, want STLS", caps)
+	}
+	if err = c.Auth("hr", "secret"); err == nil {
+		t.Fatal("login before STLS succeeded")
+	}
+	if err = c.StartTLS(s.ClientTLSConfig()); err != nil {
+		t.Fatal(err)
+	}
+	if !c.IsTLS() {
+		t.Error("IsTLS false after StartTLS")
+	}
+	if err = c.StartTLS(s.ClientTLSConfig()); err == nil {
+		t.Error("second StartTLS succeeded")
+	}
+	if err = c.Auth("hr", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if count, _, err := c.Stat(); err != nil || count != 3 {
+		t.Errorf("Stat after STLS = %d, %v", count, err)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		text string
+		want Error
+	}{
+		{"[in-use] mailbox locked", Error{Code: "IN-USE", Text: "mailbox locked"}},
+		{"no such message", Error{Text: "no such message"}},
+		{"[unterminated", Error{Text: "[unterminated"}},
+	}
+	for _, tt := range tests {
+		if got := parseError(tt.text); *got != tt.want {
+			t.Errorf("parseError(%q) = %+v, want %+v", tt.text, *got, tt.want)
+		}
+	}
+}
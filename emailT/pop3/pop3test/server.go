@@ -0,0 +1,386 @@
+package pop3test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timestamp 问候中的 APOP 时间戳
+const Timestamp = "<1896.697170952@pop3test>"
+
+// Message 服务器上的一封邮件
+type Message struct {
+	UID  string
+	Body string
+}
+
+// Server 测试用的 POP3 服务器, 监听本机的随机端口. 支持 CAPA、STLS、USER/PASS、APOP、
+// STAT、LIST、UIDL、RETR、DELE、RSET、NOOP 和 QUIT, QUIT 时才删除标记的邮件
+type Server struct {
+	// Addr 监听的地址, 如 127.0.0.1:1234
+	Addr string
+
+	user, password string
+	listener       net.Listener
+	tlsConfig      *tls.Config
+	roots          *x509.CertPool
+
+	mu         sync.Mutex
+	requireTLS bool
+	msgs       []Message
+	commands   []string
+	conns      map[net.Conn]bool
+}
+
+// NewServer 启动服务器, 账号为 user 和 password, 收件箱中有 msgs
+func NewServer(user, password string, msgs ...Message) (*Server, error) {
+	cert, roots, err := selfSigned()
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:      l.Addr().String(),
+		user:      user,
+		password:  password,
+		listener:  l,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		roots:     roots,
+		msgs:      append([]Message(nil), msgs...),
+		conns:     make(map[net.Conn]bool),
+	}
+	go s.serve()
+	return s, nil
+}
+
+// ClientTLSConfig 信任服务器证书的客户端配置, 用于 STLS
+func (s *Server) ClientTLSConfig() *tls.Config {
+	return &tls.Config{RootCAs: s.roots, ServerName: "127.0.0.1"}
+}
+
+// RequireTLS 为 true 时 STLS 之前拒绝 USER 和 APOP
+func (s *Server) RequireTLS(v bool) {
+	s.mu.Lock()
+	s.requireTLS = v
+	s.mu.Unlock()
+}
+
+// Deliver 收件箱中新增一封邮件, 之后登录的会话才能看到
+func (s *Server) Deliver(m Message) {
+	s.mu.Lock()
+	s.msgs = append(s.msgs, m)
+	s.mu.Unlock()
+}
+
+// Messages 服务器上现有的邮件
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.msgs...)
+}
+
+// Commands 收到的全部命令, 按顺序排列
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Close 停止监听并断开所有连接
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	return err
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go func() {
+			s.handle(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// session 一个连接的状态
+type session struct {
+	s     *Server
+	conn  net.Conn
+	text  *textproto.Conn
+	isTLS bool
+	user  string
+	// box 登录时的收件箱, 会话中编号不变
+	box     []Message
+	deleted map[int]bool
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	ss := &session{s: s, conn: conn, text: textproto.NewConn(conn)}
+	ss.ok("POP3 server ready %s", Timestamp)
+	for {
+		line, err := ss.text.ReadLine()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			ss.fail("empty command")
+			continue
+		}
+		if quit := ss.exec(strings.ToUpper(f[0]), f[1:]); quit {
+			return
+		}
+	}
+}
+
+// exec 执行一条命令, QUIT 时返回 true
+func (ss *session) exec(cmd string, args []string) (quit bool) {
+	switch cmd {
+	case "CAPA":
+		ss.ok("capability list follows")
+		caps := []string{"USER", "UIDL"}
+		if !ss.isTLS && ss.box == nil {
+			caps = append(caps, "STLS")
+		}
+		ss.lines(caps)
+		return false
+	case "STLS":
+		if ss.isTLS || ss.box != nil {
+			ss.fail("STLS not allowed now")
+			return false
+		}
+		ss.ok("begin TLS negotiation")
+		conn := tls.Server(ss.conn, ss.s.tlsConfig)
+		if err := conn.Handshake(); err != nil {
+			return true
+		}
+		ss.conn, ss.text, ss.isTLS = conn, textproto.NewConn(conn), true
+		return false
+	case "USER":
+		if !ss.canLogin() {
+			return false
+		}
+		if len(args) != 1 {
+			ss.fail("syntax error")
+			return false
+		}
+		ss.user = args[0]
+		ss.ok("send PASS")
+		return false
+	case "APOP":
+		if !ss.canLogin() {
+			return false
+		}
+		sum := md5.Sum([]byte(Timestamp + ss.s.password))
+		ss.login(len(args) == 2 && args[0] == ss.s.user && args[1] == hex.EncodeToString(sum[:]))
+		return false
+	case "PASS":
+		if ss.box != nil || ss.user == "" {
+			ss.fail("send USER first")
+			return false
+		}
+		ss.login(ss.user == ss.s.user && len(args) == 1 && args[0] == ss.s.password)
+		return false
+	case "QUIT":
+		if ss.box != nil {
+			ss.commit()
+		}
+		ss.ok("bye")
+		return true
+	}
+
+	if ss.box == nil {
+		ss.fail("not authenticated")
+		return false
+	}
+	switch cmd {
+	case "STAT":
+		var count, size int
+		for i, m := range ss.box {
+			if !ss.deleted[i+1] {
+				count++
+				size += len(m.Body)
+			}
+		}
+		ss.ok("%d %d", count, size)
+	case "LIST", "UIDL":
+		var lines []string
+		for i, m := range ss.box {
+			if ss.deleted[i+1] {
+				continue
+			}
+			if cmd == "LIST" {
+				lines = append(lines, fmt.Sprintf("%d %d", i+1, len(m.Body)))
+			} else {
+				lines = append(lines, fmt.Sprintf("%d %s", i+1, m.UID))
+			}
+		}
+		ss.ok("%d messages", len(lines))
+		ss.lines(lines)
+	case "RETR", "DELE":
+		n, ok := ss.message(args)
+		if !ok {
+			ss.fail("no such message")
+			return false
+		}
+		if cmd == "DELE" {
+			ss.deleted[n] = true
+			ss.ok("message %d deleted", n)
+			return false
+		}
+		body := ss.box[n-1].Body
+		ss.ok("%d octets", len(body))
+		w := ss.text.DotWriter()
+		w.Write([]byte(body))
+		w.Close()
+	case "RSET":
+		ss.deleted = make(map[int]bool)
+		ss.ok("")
+	case "NOOP":
+		ss.ok("")
+	default:
+		ss.fail("unknown command %s", cmd)
+	}
+	return false
+}
+
+// canLogin 是否可以开始登录, 不可以时回复 -ERR
+func (ss *session) canLogin() bool {
+	if ss.box != nil {
+		ss.fail("already authenticated")
+		return false
+	}
+	ss.s.mu.Lock()
+	requireTLS := ss.s.requireTLS
+	ss.s.mu.Unlock()
+	if requireTLS && !ss.isTLS {
+		ss.fail("[AUTH] TLS required")
+		return false
+	}
+	return true
+}
+
+// login 登录成功时取出收件箱
+func (ss *session) login(ok bool) {
+	if !ok {
+		ss.user = ""
+		ss.fail("[AUTH] invalid user name or password")
+		return
+	}
+	ss.box = ss.s.Messages()
+	if ss.box == nil {
+		ss.box = []Message{}
+	}
+	ss.deleted = make(map[int]bool)
+	ss.ok("logged in")
+}
+
+// message 解析邮件编号, 删除的邮件不存在
+func (ss *session) message(args []string) (int, bool) {
+	if len(args) != 1 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(ss.box) || ss.deleted[n] {
+		return 0, false
+	}
+	return n, true
+}
+
+// commit QUIT 时从服务器删除标记的邮件
+func (ss *session) commit() {
+	gone := make(map[string]bool)
+	for n := range ss.deleted {
+		gone[ss.box[n-1].UID] = true
+	}
+	ss.s.mu.Lock()
+	defer ss.s.mu.Unlock()
+	msgs := ss.s.msgs[:0]
+	for _, m := range ss.s.msgs {
+		if !gone[m.UID] {
+			msgs = append(msgs, m)
+		}
+	}
+	ss.s.msgs = msgs
+}
+
+func (ss *session) ok(format string, args ...interface{}) {
+	ss.text.PrintfLine("+OK "+format, args...)
+}
+
+func (ss *session) fail(format string, args ...interface{}) {
+	ss.text.PrintfLine("-ERR "+format, args...)
+}
+
+// lines 写多行响应的内容和结束的 "."
+func (ss *session) lines(lines []string) {
+	w := ss.text.DotWriter()
+	for _, l := range lines {
+		fmt.Fprintf(w, "%s\n", l)
+	}
+	w.Close()
+}
+
+// selfSigned 生成 127.0.0.1 的自签名证书
+func selfSigned() (tls.Certificate, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pop3test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, roots, nil
+}
@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"studyGo/emailT/vault"
+)
+
+var (
+	// ErrNotFound 账号不存在
+	ErrNotFound = errors.New("account not found")
+	// ErrDuplicate 同一服务器上已有相同用户名的账号
+	ErrDuplicate = errors.New("account already exists")
+)
+
+// Account 一个收信账号
+type Account struct {
+	ID       int64  `json:"id"`
+	UserName string `json:"user_name"`
+	// Password 保存时为 vault 密文, 接口只返回 vault.Masked
+	Password vault.Secret `json:"password,omitempty"`
+	// Server IMAP 服务器地址, 如 imap.exmail.qq.com:993
+	Server string `json:"server"`
+	// LastTime 上次收取的时间
+	LastTime time.Time `json:"last_time"`
+	// IsDeleted 软删除标记, 删除后不再出现在默认的列表中
+	IsDeleted bool      `json:"is_deleted"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Health 最近一次登录检查的结果, 只能通过 /emails/Verify 修改
+	Health
+}
+
+// Health 账号的登录检查结果
+type Health struct {
+	// Status 为空表示还没有检查过, 其它取值见 mailbox.StatusOK 等
+	Status    string `json:"status,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+	// LastSuccess 最近一次登录成功的时间
+	LastSuccess time.Time `json:"last_success"`
+	CheckedAt   time.Time `json:"checked_at"`
+}
+
+// ValidationError 请求参数错误, Fields 为字段名到原因
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	var parts []string
+	for _, f := range sortedKeys(e.Fields) {
+		parts = append(parts, f+": "+e.Fields[f])
+	}
+	return "invalid account: " + strings.Join(parts, "; ")
+}
+
+func (e *ValidationError) add(field, reason string) {
+	if e.Fields == nil {
+		e.Fields = make(map[string]string)
+	}
+	e.Fields[field] = reason
+}
+
+// Validate 检查账号字段. 新账号的 ID 必须为 0, 修改时必须大于 0, 密码为空表示不修改
+func (a *Account) Validate(isNew bool) error {
+	e := &ValidationError{}
+	switch {
+	case isNew && a.ID != 0:
+		e.add("id", "must be empty when adding")
+	case !isNew && a.ID <= 0:
+		e.add("id", "required")
+	}
+	a.UserName = strings.TrimSpace(a.UserName)
+	switch {
+	case a.UserName == "":
+		e.add("user_name", "required")
+	case len(a.UserName) > 254:
+		e.add("user_name", "too long")
+	case strings.ContainsAny(a.UserName, " \t\r\n"):
+		e.add("user_name", "must not contain whitespace")
+	}
+	if isNew && a.Password == "" {
+		e.add("password", "required")
+	}
+	a.Server = strings.TrimSpace(a.Server)
+	if a.Server != "" {
+		if err := checkServer(a.Server); err != nil {
+			e.add("server", err.Error())
+		}
+	}
+	if len(e.Fields) > 0 {
+		return e
+	}
+	return nil
+}
+
+// checkServer 服务器地址必须为 host:port
+func checkServer(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("must be host:port")
+	}
+	if host == "" {
+		return fmt.Errorf("missing host")
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("bad port %q", port)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"studyGo/emailT/discover"
+	"studyGo/httpclient"
+)
+
+// APIError 接口返回的错误
+type APIError struct {
+	StatusCode int
+	Code       int
+	Msg        string
+	// Fields 参数错误时为字段名到原因
+	Fields map[string]string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("emails api: %s (http %d)", e.Msg, e.StatusCode)
+	for _, f := range sortedKeys(e.Fields) {
+		msg += fmt.Sprintf("; %s: %s", f, e.Fields[f])
+	}
+	return msg
+}
+
+// Client /emails 接口的客户端
+type Client struct {
+	// BaseURL 服务地址, 如 http://127.0.0.1:63334
+	BaseURL string
+	// HTTPClient 为空时使用 httpclient 的默认配置, 每次请求 10 秒超时
+	HTTPClient *httpclient.Client
+}
+
+// NewClient 创建客户端
+func NewClient(baseURL string) *Client {
+	hc := httpclient.New()
+	hc.Timeout = 10 * time.Second
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), HTTPClient: hc}
+}
+
+// Add 新增账号, 返回分配了 ID 的账号
+func (c *Client) Add(a *Account) (*Account, error) {
+	out := new(Account)
+	return out, c.do(http.MethodPost, "/emails/Add", nil, a, out)
+}
+
+// AddVerify 新增账号并登录检查, 登录失败时账号仍然保存, 原因见返回账号的 Health
+func (c *Client) AddVerify(a *Account) (*Account, error) {
+	out := new(Account)
+	return out, c.do(http.MethodPost, "/emails/Add", url.Values{"verify": {"1"}}, a, out)
+}
+
+// Save 修改账号, Password 为空时不修改密码
+func (c *Client) Save(a *Account) (*Account, error) {
+	out := new(Account)
+	return out, c.do(http.MethodPost, "/emails/Save", nil, a, out)
+}
+
+// Get 读取账号
+func (c *Client) Get(id int64) (*Account, error) {
+	out := new(Account)
+	return out, c.do(http.MethodGet, "/emails/Get", url.Values{"id": {strconv.FormatInt(id, 10)}}, nil, out)
+}
+
+// List 列出账号, includeDeleted 为 true 时包括已删除的账号
+func (c *Client) List(includeDeleted bool) ([]*Account, error) {
+	var q url.Values
+	if includeDeleted {
+		q = url.Values{"include_deleted": {"1"}}
+	}
+	var out []*Account
+	return out, c.do(http.MethodGet, "/emails/List", q, nil, &out)
+}
+
+// Verify 登录检查账号, 返回带有检查结果的账号
+func (c *Client) Verify(id int64) (*Account, error) {
+	out := new(Account)
+	return out, c.do(http.MethodPost, "/emails/Verify", nil, &idRequest{ID: id}, out)
+}
+
+// ListStatus 列出检查结果为 status 的账号, 如 mailbox.StatusAuth
+func (c *Client) ListStatus(status string) ([]*Account, error) {
+	var out []*Account
+	return out, c.do(http.MethodGet, "/emails/List", url.Values{"status": {status}}, nil, &out)
+}
+
+// Discover 按邮箱地址查找 IMAP 服务器, 没有找到时返回 StatusCode 为 404 的 *APIError
+func (c *Client) Discover(email string) (*discover.Settings, error) {
+	out := new(discover.Settings)
+	return out, c.do(http.MethodGet, "/emails/Discover", url.Values{"email": {email}}, nil, out)
+}
+
+// Delete 软删除账号
+func (c *Client) Delete(id int64) error {
+	return c.do(http.MethodPost, "/emails/Delete", nil, &idRequest{ID: id}, nil)
+}
+
+// idempotentAPIs 可以安全重试的 POST 接口, Add 重试可能重复新增账号
+var idempotentAPIs = map[string]bool{
+	"/emails/Save":   true,
+	"/emails/Delete": true,
+	"/emails/Verify": true,
+}
+
+func (c *Client) do(method, path string, query url.Values, in, out interface{}) error {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idempotentAPIs[path] {
+		req = req.WithContext(httpclient.Idempotent(req.Context()))
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = &httpclient.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := hc.Do(req)
+	var serr *httpclient.StatusError
+	if err != nil && !errors.As(err, &serr) {
+		return err
+	}
+	// 接口的错误也是 json, 状态码不是 200 时同样解析
+	var r Response
+	if err := resp.JSON(&r); err != nil {
+		if serr != nil {
+			return &APIError{StatusCode: resp.StatusCode, Code: resp.StatusCode, Msg: serr.Error()}
+		}
+		snippet := string(resp.Body)
+		if len(snippet) > 200 {
+			snippet = snippet[:200]
+		}
+		return &APIError{StatusCode: resp.StatusCode, Code: resp.StatusCode, Msg: "bad response: " + snippet}
+	}
+	if r.Code != 0 || resp.StatusCode != http.StatusOK {
+		return &APIError{StatusCode: resp.StatusCode, Code: r.Code, Msg: r.Msg, Fields: r.Errors}
+	}
+	if out != nil && len(r.Data) > 0 {
+		return json.Unmarshal(r.Data, out)
+	}
+	return nil
+}
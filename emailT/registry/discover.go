@@ -0,0 +1,45 @@
+package registry
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"studyGo/emailT/discover"
+)
+
+// discoverTimeout 查找服务器的最长时间, 短于 Client 的超时
+const discoverTimeout = 8 * time.Second
+
+// DiscoverFunc 根据邮箱地址查找 IMAP 服务器
+type DiscoverFunc func(ctx context.Context, email string) (*discover.Settings, error)
+
+// fillServer 账号没有填写服务器时按邮箱地址查找, 同时按找到的配置把 UserName 换成登录名.
+// 登录只支持直接 TLS, 找到的是 STARTTLS 或明文的服务器时不填写; 找不到时不报错, 账号照常保存
+func (s *Server) fillServer(ctx context.Context, a *Account) {
+	if a.Server != "" || s.Discover == nil || !strings.Contains(a.UserName, "@") {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, discoverTimeout)
+	defer cancel()
+	settings, err := s.Discover(ctx, a.UserName)
+	if err != nil || settings.TLS != discover.TLSImplicit {
+		return
+	}
+	a.Server = settings.Addr()
+	a.UserName = settings.LoginName(a.UserName)
+}
+
+// discover 查找邮箱地址的服务器配置, 没有找到时返回 404
+func (s *Server) discover(ctx context.Context, email string) (*discover.Settings, error) {
+	email = strings.TrimSpace(email)
+	if !strings.Contains(email, "@") {
+		return nil, &ValidationError{Fields: map[string]string{"email": "must be an email address"}}
+	}
+	if s.Discover == nil {
+		return nil, ErrNotFound
+	}
+	ctx, cancel := context.WithTimeout(ctx, discoverTimeout)
+	defer cancel()
+	return s.Discover(ctx, email)
+}
@@ -0,0 +1,39 @@
+package registry
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"studyGo/emailT/discover"
+)
+
+func TestFillServer(t *testing.T) {
+	found := map[string]*discover.Settings{
+		"example.com":  {Host: "imap.example.com", Port: 993, TLS: discover.TLSImplicit, Username: "%EMAILLOCALPART%"},
+		"default.com":  {Host: "imap.default.com", Port: 993, TLS: discover.TLSImplicit},
+		"starttls.com": {Host: "imap.starttls.com", Port: 143, TLS: discover.TLSStartTLS},
+	}
+	s := &Server{Discover: func(ctx context.Context, email string) (*discover.Settings, error) {
+		return found[strings.TrimPrefix(email, "hr@")], nil
+	}}
+
+	tests := []struct {
+		user, server   string
+		wantUser, want string
+	}{
+		{"hr@example.com", "", "hr", "imap.example.com:993"},
+		{"hr@default.com", "", "hr@default.com", "imap.default.com:993"},
+		// 不支持 STARTTLS, 账号保持原样
+		{"hr@starttls.com", "", "hr@starttls.com", ""},
+		// 填写了服务器时不查找
+		{"hr@example.com", "mail.example.com:993", "hr@example.com", "mail.example.com:993"},
+	}
+	for _, tt := range tests {
+		a := &Account{UserName: tt.user, Server: tt.server}
+		s.fillServer(context.Background(), a)
+		if a.UserName != tt.wantUser || a.Server != tt.want {
+			t.Errorf("%s %q: got %s %q, want %s %q", tt.user, tt.server, a.UserName, a.Server, tt.wantUser, tt.want)
+		}
+	}
+}
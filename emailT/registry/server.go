@@ -0,0 +1,284 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"studyGo/emailT/discover"
+	"studyGo/emailT/vault"
+)
+
+// DefaultAddr 账号服务的默认地址
+const DefaultAddr = "127.0.0.1:63334"
+
+// maxBodySize 请求体的最大字节数
+const maxBodySize = 1 << 20
+
+// Response 接口返回的 JSON. 成功时 Code 为 0, 失败时为 HTTP 状态码,
+// 参数错误时 Errors 为字段名到原因
+type Response struct {
+	Code   int               `json:"code"`
+	Msg    string            `json:"msg"`
+	Data   json.RawMessage   `json:"data,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// idRequest Get、Delete 的参数, 也可以放在查询参数 id 中
+type idRequest struct {
+	ID int64 `json:"id"`
+}
+
+// Server /emails 接口
+//
+//	POST /emails/Add     新增账号, ?verify=1 时同时登录检查
+//	POST /emails/Save    修改账号, password 为空时不修改密码
+//	GET  /emails/Get     ?id= 读取账号
+//	GET  /emails/List    ?include_deleted=1 列出账号, ?status= 只列出该检查结果的账号
+//	POST /emails/Delete  软删除账号
+//	POST /emails/Verify  登录检查账号, 结果见返回账号的 status、last_error 和 last_success
+//	GET  /emails/Discover ?email= 按邮箱地址查找 IMAP 服务器
+//
+// 密码用 Vault 加密后保存, 接口返回的密码总是 vault.Masked.
+// 新增账号没有填写 server 时用 Discover 自动查找
+type Server struct {
+	Store Store
+	Vault vault.Vault
+	// Login 登录检查使用的函数, 默认为 Login
+	Login LoginFunc
+	// Discover 查找服务器使用的函数, 默认为 discover.Discover, 为空时不查找
+	Discover DiscoverFunc
+	mux      *http.ServeMux
+}
+
+// NewServer 创建接口
+func NewServer(store Store, v vault.Vault) *Server {
+	s := &Server{Store: store, Vault: v, Login: Login, Discover: discover.Discover, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/emails/Add", s.method(http.MethodPost, s.add))
+	s.mux.HandleFunc("/emails/Save", s.method(http.MethodPost, s.save))
+	s.mux.HandleFunc("/emails/Get", s.method(http.MethodGet, s.get))
+	s.mux.HandleFunc("/emails/List", s.method(http.MethodGet, s.list))
+	s.mux.HandleFunc("/emails/Delete", s.method(http.MethodPost, s.delete))
+	s.mux.HandleFunc("/emails/Verify", s.method(http.MethodPost, s.verify))
+	s.mux.HandleFunc("/emails/Discover", s.method(http.MethodGet, func(r *http.Request) (interface{}, error) {
+		return s.discover(r.Context(), r.URL.Query().Get("email"))
+	}))
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such api %s", r.URL.Path))
+	})
+	return s
+}
+
+// ServeHTTP 实现 http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+type handlerFunc func(r *http.Request) (interface{}, error)
+
+// method 检查请求方法, 把 handler 的结果或错误写成 Response
+func (s *Server) method(method string, h handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed, use %s", r.Method, method))
+			return
+		}
+		data, err := h(r)
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		raw, err := json.Marshal(data)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, &Response{Msg: "ok", Data: raw})
+	}
+}
+
+func (s *Server) add(r *http.Request) (interface{}, error) {
+	var a Account
+	if err := decode(r, &a); err != nil {
+		return nil, err
+	}
+	if err := a.Validate(true); err != nil {
+		return nil, err
+	}
+	a.IsDeleted = false
+	s.fillServer(r.Context(), &a)
+	if err := s.encrypt(&a); err != nil {
+		return nil, err
+	}
+	if err := s.Store.Add(&a); err != nil {
+		return nil, err
+	}
+	if verify, _ := strconv.ParseBool(r.URL.Query().Get("verify")); verify && a.Server != "" {
+		return s.Verify(a.ID)
+	}
+	return masked(&a), nil
+}
+
+func (s *Server) save(r *http.Request) (interface{}, error) {
+	var a Account
+	if err := decode(r, &a); err != nil {
+		return nil, err
+	}
+	if err := a.Validate(false); err != nil {
+		return nil, err
+	}
+	// 调用方可能把读到的 Masked 原样传回来
+	if a.Password == "" || a.Password == vault.Masked {
+		old, err := s.Store.Get(a.ID)
+		if err != nil {
+			return nil, err
+		}
+		a.Password = old.Password
+	} else if err := s.encrypt(&a); err != nil {
+		return nil, err
+	}
+	if err := s.Store.Save(&a); err != nil {
+		return nil, err
+	}
+	return masked(&a), nil
+}
+
+func (s *Server) get(r *http.Request) (interface{}, error) {
+	id, err := requestID(r)
+	if err != nil {
+		return nil, err
+	}
+	a, err := s.Store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return masked(a), nil
+}
+
+func (s *Server) list(r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	includeDeleted, _ := strconv.ParseBool(q.Get("include_deleted"))
+	list, err := s.Store.List(includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	status, filter := q["status"]
+	out := list[:0]
+	for _, a := range list {
+		if !filter || a.Status == status[0] {
+			out = append(out, masked(a))
+		}
+	}
+	return out, nil
+}
+
+func (s *Server) verify(r *http.Request) (interface{}, error) {
+	id, err := requestID(r)
+	if err != nil {
+		return nil, err
+	}
+	return s.Verify(id)
+}
+
+func (s *Server) delete(r *http.Request) (interface{}, error) {
+	id, err := requestID(r)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.Store.Delete(id); err != nil {
+		return nil, err
+	}
+	return &idRequest{ID: id}, nil
+}
+
+// encrypt 加密请求中的明文密码
+func (s *Server) encrypt(a *Account) error {
+	ct, err := s.Vault.Encrypt(string(a.Password))
+	if err != nil {
+		return err
+	}
+	a.Password = vault.Secret(ct)
+	return nil
+}
+
+// masked 把密码换成 vault.Masked
+func masked(a *Account) *Account {
+	if a.Password != "" {
+		a.Password = vault.Masked
+	}
+	return a
+}
+
+// requestID 从查询参数或 json 请求体中读取 id
+func requestID(r *http.Request) (int64, error) {
+	var req idRequest
+	if v := r.URL.Query().Get("id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, &ValidationError{Fields: map[string]string{"id": "must be a number"}}
+		}
+		req.ID = id
+	} else if r.Method == http.MethodPost {
+		if err := decode(r, &req); err != nil {
+			return 0, err
+		}
+	}
+	if req.ID <= 0 {
+		return 0, &ValidationError{Fields: map[string]string{"id": "required"}}
+	}
+	return req.ID, nil
+}
+
+// requestError 请求体无法解析
+type requestError struct {
+	err error
+}
+
+func (e *requestError) Error() string {
+	return "bad request body: " + e.err.Error()
+}
+
+// decode 解析 json 请求体, 不允许未知字段
+func decode(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return &requestError{err}
+	}
+	return nil
+}
+
+// statusOf 错误对应的 HTTP 状态码
+func statusOf(err error) int {
+	var verr *ValidationError
+	var rerr *requestError
+	switch {
+	case errors.As(err, &verr), errors.As(err, &rerr):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotFound), errors.Is(err, discover.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrDuplicate):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	resp := &Response{Code: status, Msg: err.Error()}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		resp.Msg = "invalid request"
+		resp.Errors = verr.Fields
+	}
+	writeJSON(w, status, resp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, resp *Response) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"studyGo/emailT/registry"
+	"studyGo/emailT/vault"
+	"time"
+)
+
+// 定时登录检查全部账号, 打印登录失败的账号
+func checkAccounts(srv *registry.Server, interval time.Duration) {
+	for {
+		failed, err := srv.VerifyAll()
+		if err != nil {
+			fmt.Println("verify accounts err: ", err)
+		}
+		for _, a := range failed {
+			fmt.Printf("%s 登录检查失败 %s: %s\n", a.UserName, a.Status, a.LastError)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func main() {
+	addr := flag.String("addr", registry.DefaultAddr, "监听地址")
+	dataFile := flag.String("data", "emailT_accounts.json", "账号数据文件")
+	keyFile := flag.String("masterkey", "emailT_master.key", "主密钥文件, 不存在时生成")
+	rotate := flag.Bool("rotate", false, "轮换主密钥, 重新加密全部密码后退出")
+	check := flag.Duration("check", 0, "定时登录检查全部账号的间隔, 为 0 时不检查")
+	flag.Parse()
+	log.SetOutput(vault.NewRedactWriter(os.Stderr))
+
+	v, err := vault.OpenOrCreate(*keyFile)
+	if err != nil {
+		fmt.Println("load master key err: ", err)
+		return
+	}
+	store, err := registry.NewFileStore(*dataFile)
+	if err != nil {
+		fmt.Println("load accounts err: ", err)
+		return
+	}
+	if *rotate {
+		old := v.KeyID()
+		id, err := v.Rotate()
+		if err != nil {
+			fmt.Println("rotate master key err: ", err)
+			return
+		}
+		n, err := registry.Rewrap(store, v)
+		if err != nil {
+			fmt.Println("rewrap passwords err: ", err)
+			return
+		}
+		fmt.Printf("主密钥 %s 轮换为 %s, 重新加密密码%d个\n", old, id, n)
+		return
+	}
+	// 旧版本保存的明文密码在启动时加密
+	if n, err := registry.Rewrap(store, v); err != nil {
+		fmt.Println("encrypt passwords err: ", err)
+		return
+	} else if n > 0 {
+		fmt.Printf("加密明文密码%d个\n", n)
+	}
+	srv := registry.NewServer(store, v)
+	if *check > 0 {
+		go checkAccounts(srv, *check)
+	}
+	fmt.Printf("----start server http://%s/emails ------\n", *addr)
+	if err = http.ListenAndServe(*addr, srv); err != nil {
+		fmt.Println("http listen failed: ", err)
+	}
+}
@@ -0,0 +1,233 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"studyGo/emailT/tools"
+	"studyGo/emailT/vault"
+)
+
+// Store 账号的存储
+type Store interface {
+	// Add 保存新账号, 分配 ID 并填写创建时间
+	Add(a *Account) error
+	// Save 替换已有的账号, 保留创建时间和 Health; 不存在时返回 ErrNotFound
+	Save(a *Account) error
+	// SaveHealth 只修改账号的登录检查结果
+	SaveHealth(id int64, h Health) error
+	// Get 读取账号, 包括已删除的账号, 不存在时返回 ErrNotFound
+	Get(id int64) (*Account, error)
+	// List 按 ID 列出账号, includeDeleted 为 false 时跳过已删除的账号
+	List(includeDeleted bool) ([]*Account, error)
+	// Delete 软删除, 把 IsDeleted 置为 true
+	Delete(id int64) error
+}
+
+// FileStore 保存在 JSON 文件中的账号, 可以并发使用
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+	data fileData
+}
+
+type fileData struct {
+	NextID   int64              `json:"next_id"`
+	Accounts map[int64]*Account `json:"accounts"`
+}
+
+// NewFileStore 打开账号文件, 文件不存在时在第一次保存时创建
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, data: fileData{NextID: 1, Accounts: make(map[int64]*Account)}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &s.data); err != nil {
+		return nil, err
+	}
+	if s.data.Accounts == nil {
+		s.data.Accounts = make(map[int64]*Account)
+	}
+	return s, nil
+}
+
+// Add 保存新账号
+func (s *FileStore) Add(a *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.duplicate(a) {
+		return ErrDuplicate
+	}
+	now := time.Now()
+	t := *a
+	t.ID = s.data.NextID
+	t.CreatedAt, t.UpdatedAt = now, now
+	t.Health = Health{}
+	s.data.Accounts[t.ID] = &t
+	s.data.NextID++
+	if err := s.flush(); err != nil {
+		delete(s.data.Accounts, t.ID)
+		s.data.NextID--
+		return err
+	}
+	*a = t
+	return nil
+}
+
+// Save 替换已有的账号, 保留创建时间和 Health
+func (s *FileStore) Save(a *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.data.Accounts[a.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if s.duplicate(a) {
+		return ErrDuplicate
+	}
+	t := *a
+	t.CreatedAt, t.UpdatedAt = old.CreatedAt, time.Now()
+	t.Health = old.Health
+	s.data.Accounts[t.ID] = &t
+	if err := s.flush(); err != nil {
+		s.data.Accounts[t.ID] = old
+		return err
+	}
+	*a = t
+	return nil
+}
+
+// SaveHealth 修改账号的登录检查结果
+func (s *FileStore) SaveHealth(id int64, h Health) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.data.Accounts[id]
+	if !ok {
+		return ErrNotFound
+	}
+	t := *old
+	t.Health = h
+	s.data.Accounts[id] = &t
+	if err := s.flush(); err != nil {
+		s.data.Accounts[id] = old
+		return err
+	}
+	return nil
+}
+
+// Get 读取账号
+func (s *FileStore) Get(id int64) (*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.data.Accounts[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	t := *a
+	return &t, nil
+}
+
+// List 按 ID 列出账号
+func (s *FileStore) List(includeDeleted bool) ([]*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]*Account, 0, len(s.data.Accounts))
+	for _, a := range s.data.Accounts {
+		if a.IsDeleted && !includeDeleted {
+			continue
+		}
+		t := *a
+		list = append(list, &t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// Delete 软删除账号, 已删除时不报错
+func (s *FileStore) Delete(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.data.Accounts[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if old.IsDeleted {
+		return nil
+	}
+	t := *old
+	t.IsDeleted, t.UpdatedAt = true, time.Now()
+	s.data.Accounts[id] = &t
+	if err := s.flush(); err != nil {
+		s.data.Accounts[id] = old
+		return err
+	}
+	return nil
+}
+
+// duplicate 没有删除的账号中是否已有同一服务器上的同名账号
+func (s *FileStore) duplicate(a *Account) bool {
+	if a.IsDeleted {
+		return false
+	}
+	for _, o := range s.data.Accounts {
+		if o.ID != a.ID && !o.IsDeleted && strings.EqualFold(o.UserName, a.UserName) && strings.EqualFold(o.Server, a.Server) {
+			return true
+		}
+	}
+	return false
+}
+
+// flush 写入文件, 先写临时文件再改名. 文件中有密码, 权限为 0600
+func (s *FileStore) flush() error {
+	data, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return tools.WriteFileAtomic(s.path, data, 0600)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Rewrap 主密钥轮换后用当前主密钥重新加密全部账号的密码, 包括已删除的账号;
+// 旧版本保存的明文密码同时被加密. 返回修改的账号数
+func Rewrap(store Store, v *vault.FileVault) (n int, err error) {
+	list, err := store.List(true)
+	if err != nil {
+		return 0, err
+	}
+	for _, a := range list {
+		if a.Password == "" {
+			continue
+		}
+		ct, err := v.Rewrap(string(a.Password))
+		if err != nil {
+			return n, fmt.Errorf("account %d: %v", a.ID, err)
+		}
+		if ct == string(a.Password) {
+			continue
+		}
+		a.Password = vault.Secret(ct)
+		if err = store.Save(a); err != nil {
+			return n, fmt.Errorf("account %d: %v", a.ID, err)
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"studyGo/emailT/mailbox"
+	"studyGo/emailT/vault"
+)
+
+// LoginFunc 用账号登录一次并退出, 返回的错误用 mailbox.LoginStatus 分类
+type LoginFunc func(server, user, password string) error
+
+// Login 默认的 LoginFunc, 用 mailbox.Login 建立 TLS 连接登录后立即退出
+func Login(server, user, password string) error {
+	c, err := mailbox.Login(server, user, password, mailbox.DefaultLoginTimeout)
+	if err != nil {
+		return err
+	}
+	c.Logout()
+	return nil
+}
+
+// StatusConfig 账号配置错误, 如没有服务器地址或密码无法解密, 不需要登录就能确定
+const StatusConfig = "config_error"
+
+// errNoServer 账号没有配置服务器地址
+var errNoServer = errors.New("no server configured")
+
+// Verify 登录检查账号, 结果保存到账号的 Health. 登录失败不返回错误, 原因见 Health
+func (s *Server) Verify(id int64) (*Account, error) {
+	a, err := s.Store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if a.Server == "" {
+		return nil, &ValidationError{Fields: map[string]string{"server": "required for verification"}}
+	}
+	return s.verifyAccount(a)
+}
+
+// verifyAccount 登录检查账号并保存 Health, 只有保存失败时返回错误
+func (s *Server) verifyAccount(a *Account) (*Account, error) {
+	status, err := s.checkLogin(a)
+	h := a.Health
+	h.CheckedAt = time.Now()
+	h.Status = status
+	if err == nil {
+		h.LastError = ""
+		h.LastSuccess = h.CheckedAt
+	} else {
+		h.LastError = err.Error()
+	}
+	if err = s.Store.SaveHealth(a.ID, h); err != nil {
+		return nil, err
+	}
+	a.Health = h
+	return masked(a), nil
+}
+
+// checkLogin 登录一次, 返回结果的类型和去掉了密码的错误
+func (s *Server) checkLogin(a *Account) (string, error) {
+	if a.Server == "" {
+		return StatusConfig, errNoServer
+	}
+	password, err := vault.Reveal(s.Vault, a.Password)
+	if err != nil {
+		return StatusConfig, err
+	}
+	err = s.Login(a.Server, a.UserName, password)
+	return mailbox.LoginStatus(err), vault.RedactError(err, password)
+}
+
+// VerifyAll 检查全部没有删除的账号, 返回检查失败的账号. 一个账号没有服务器地址或
+// 密码无法解密时记录在它的 Health 中, 继续检查其它账号; 保存 Health 失败时
+// 同样继续, 最后返回第一个错误
+func (s *Server) VerifyAll() (failed []*Account, err error) {
+	list, err := s.Store.List(false)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range list {
+		checked, verr := s.verifyAccount(a)
+		if verr != nil {
+			if err == nil {
+				err = fmt.Errorf("save health of %s: %v", a.UserName, verr)
+			}
+			continue
+		}
+		if checked.Status != mailbox.StatusOK {
+			failed = append(failed, checked)
+		}
+	}
+	return failed, err
+}
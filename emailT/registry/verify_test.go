@@ -0,0 +1,81 @@
+package registry
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"studyGo/emailT/mailbox"
+	"studyGo/emailT/vault"
+)
+
+func TestVerifyAllContinuesAfterAccountErrors(t *testing.T) {
+	dir := t.TempDir()
+	v, err := vault.Create(filepath.Join(dir, "master.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 另一个主密钥加密的密码无法解密
+	other, err := vault.Create(filepath.Join(dir, "other.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileStore(filepath.Join(dir, "accounts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := func(user, server, password string, v vault.Vault) int64 {
+		t.Helper()
+		enc, err := v.Encrypt(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := &Account{UserName: user, Server: server, Password: vault.Secret(enc)}
+		if err = store.Add(a); err != nil {
+			t.Fatal(err)
+		}
+		return a.ID
+	}
+	undecryptable := add("a@example.com", "imap.example.com:993", "pass-a", other)
+	noServer := add("b@example.com", "", "pass-b", v)
+	wrongPassword := add("c@example.com", "imap.example.com:993", "pass-c-wrong", v)
+	ok := add("d@example.com", "imap.example.com:993", "pass-d", v)
+
+	srv := NewServer(store, v)
+	srv.Login = func(server, user, password string) error {
+		if user == "c@example.com" {
+			return &mailbox.LoginError{Status: mailbox.StatusAuth, Err: errors.New("invalid password " + password)}
+		}
+		return nil
+	}
+	failed, err := srv.VerifyAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 3 {
+		t.Errorf("%d failed accounts, want 3", len(failed))
+	}
+
+	want := map[int64]string{
+		undecryptable: StatusConfig,
+		noServer:      StatusConfig,
+		wrongPassword: mailbox.StatusAuth,
+		ok:            mailbox.StatusOK,
+	}
+	for id, status := range want {
+		a, err := store.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Status != status || a.CheckedAt.IsZero() {
+			t.Errorf("%s: status %q checked %v, want %q", a.UserName, a.Status, a.CheckedAt, status)
+		}
+		if (status == mailbox.StatusOK) != (a.LastError == "") {
+			t.Errorf("%s: last error %q", a.UserName, a.LastError)
+		}
+		if strings.Contains(a.LastError, "pass-") {
+			t.Errorf("%s: password in last error %q", a.UserName, a.LastError)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-message"
+	"golang.org/x/net/idna"
+)
+
+// ErrNoAddress 邮件头中没有地址
+var ErrNoAddress = errors.New("no address")
+
+// AddressError 地址解析失败
+type AddressError struct {
+	Field string
+	Value string
+	Err   error
+}
+
+func (e *AddressError) Error() string {
+	return fmt.Sprintf("parse %s %q: %v", e.Field, e.Value, e.Err)
+}
+
+func (e *AddressError) Unwrap() error {
+	return e.Err
+}
+
+// Address 解析后的邮件地址
+type Address struct {
+	// Name 已解码的显示名
+	Name string
+	// Address 邮箱地址, IDN 域名转为 unicode 形式
+	Address string
+	// Group 所属的组名, 不在组中时为空
+	Group string
+}
+
+func (a Address) String() string {
+	return (&mail.Address{Name: a.Name, Address: a.Address}).String()
+}
+
+// Domain 地址的域名部分
+func (a Address) Domain() string {
+	return a.Address[strings.LastIndex(a.Address, "@")+1:]
+}
+
+// ASCII 域名转为 punycode 后的地址, 用于 SMTP 等只接受 ascii 的场合
+func (a Address) ASCII() (string, error) {
+	at := strings.LastIndex(a.Address, "@")
+	domain, err := idna.Lookup.ToASCII(a.Address[at+1:])
+	if err != nil {
+		return "", err
+	}
+	return a.Address[:at+1] + domain, nil
+}
+
+// GetAddresses 解析 From/To/Cc/Reply-To 等地址头. 部分地址无法解析时
+// 返回能解析的部分以及 *AddressError
+func GetAddresses(header message.Header, field string) ([]Address, error) {
+	value := strings.Join(header.Values(field), ", ")
+	addrs, err := ParseAddressList(value)
+	if err != nil {
+		return addrs, &AddressError{Field: field, Value: value, Err: err}
+	}
+	return addrs, nil
+}
+
+// ParseAddressList 解析 RFC 5322 地址列表, 支持 RFC 2047 编码的显示名、组、
+// 带引号的 local part、注释和 IDN 域名
+func ParseAddressList(value string) (addrs []Address, err error) {
+	// 有些发信方直接在头里放未编码的 gbk 等字节
+	if !utf8.ValidString(value) {
+		value = string(decodeCharset("", []byte(value)))
+	}
+	if strings.TrimSpace(value) == "" {
+		return nil, ErrNoAddress
+	}
+	parser := mail.AddressParser{WordDecoder: DecHeader()}
+	for _, seg := range splitAddressGroups(value) {
+		list, segErr := parseAddressSegment(&parser, seg.list)
+		if segErr != nil && err == nil {
+			err = segErr
+		}
+		for _, a := range list {
+			name := a.Name
+			// 不规范但很常见: 编码字被放在了引号里, net/mail 不会解码
+			if strings.Contains(name, "=?") {
+				if dec, err := DecHeader().DecodeHeader(name); err == nil {
+					name = dec
+				}
+			}
+			addrs = append(addrs, Address{Name: name, Address: unicodeDomain(a.Address), Group: seg.group})
+		}
+	}
+	if len(addrs) == 0 && err == nil {
+		err = ErrNoAddress
+	}
+	return addrs, err
+}
+
+// parseAddressSegment 先整体解析, 失败时逐个地址解析, 保留能解析的部分
+func parseAddressSegment(parser *mail.AddressParser, list string) ([]*mail.Address, error) {
+	if strings.Trim(list, " \t\r\n,") == "" {
+		return nil, nil
+	}
+	addrs, err := parser.ParseList(list)
+	if err == nil {
+		return addrs, nil
+	}
+	addrs, err = nil, nil
+	for _, s := range splitTopLevel(list, ',') {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		a, e := parser.Parse(s)
+		if e != nil {
+			err = fmt.Errorf("%q: %v", strings.TrimSpace(s), e)
+			continue
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs, err
+}
+
+func unicodeDomain(addr string) string {
+	at := strings.LastIndex(addr, "@")
+	if at < 0 || !strings.Contains(strings.ToLower(addr[at:]), "xn--") {
+		return addr
+	}
+	domain, err := idna.Lookup.ToUnicode(addr[at+1:])
+	if err != nil {
+		return addr
+	}
+	return addr[:at+1] + domain
+}
+
+type addressSegment struct {
+	group string
+	list  string
+}
+
+// splitAddressGroups 拆出 "组名: a, b;" 形式的组, 组外的地址归入组名为空的段
+func splitAddressGroups(s string) (segs []addressSegment) {
+	var plain []string
+	start, groupStart := 0, -1
+	group := ""
+	scanTopLevel(s, func(i int, c byte) {
+		switch {
+		case c == ':' && groupStart < 0:
+			nameStart := strings.LastIndex(s[start:i], ",") + start + 1
+			plain = appendNonEmpty(plain, s[start:nameStart])
+			group = strings.TrimSpace(s[nameStart:i])
+			if dec, err := DecHeader().DecodeHeader(group); err == nil {
+				group = dec
+			}
+			groupStart = i + 1
+		case c == ';' && groupStart >= 0:
+			segs = append(segs, addressSegment{group: group, list: s[groupStart:i]})
+			start, groupStart = i+1, -1
+		}
+	})
+	if groupStart >= 0 {
+		// 缺少结尾的 ';'
+		segs = append(segs, addressSegment{group: group, list: s[groupStart:]})
+	} else {
+		plain = appendNonEmpty(plain, s[start:])
+	}
+	return append([]addressSegment{{list: strings.Join(plain, ", ")}}, segs...)
+}
+
+func appendNonEmpty(list []string, s string) []string {
+	if s = strings.Trim(s, " \t\r\n,"); s != "" {
+		list = append(list, s)
+	}
+	return list
+}
+
+// splitTopLevel 按不在引号、注释和尖括号中的 sep 拆分
+func splitTopLevel(s string, sep byte) (parts []string) {
+	start := 0
+	scanTopLevel(s, func(i int, c byte) {
+		if c == sep {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	})
+	return append(parts, s[start:])
+}
+
+// scanTopLevel 对不在引号、注释和尖括号中的每个字符调用 fn
+func scanTopLevel(s string, fn func(i int, c byte)) {
+	inQuote, inAngle, depth := false, false, 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && (inQuote || depth > 0):
+			i++
+		case inQuote:
+			inQuote = c != '"'
+		case depth > 0:
+			if c == '(' {
+				depth++
+			} else if c == ')' {
+				depth--
+			}
+		case c == '"':
+			inQuote = true
+		case c == '(':
+			depth++
+		case c == '<':
+			inAngle = true
+		case c == '>':
+			inAngle = false
+		case !inAngle:
+			fn(i, c)
+		}
+	}
+}
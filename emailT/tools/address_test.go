@@ -0,0 +1,77 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message"
+)
+
+func TestParseAddressList(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		// want 每个地址为 "组名|显示名|地址"
+		want    string
+		wantErr bool
+	}{
+		{"plain", "hr@example.com", "[|hr@example.com]", false},
+		{"encoded name", "=?UTF-8?B?5byg55Cz?= <hr@example.com>", "[|张琳|hr@example.com]", false},
+		// 编码字放在引号里不规范, 但很常见
+		{"quoted encoded name", `"=?gb18030?B?wLXV5Mjj?=" <lai@vip.sohu.com>`, "[|赖珍茹|lai@vip.sohu.com]", false},
+		{"list", "a@example.com, B <b@example.com>", "[|a@example.com |B|b@example.com]", false},
+		{"comment", "hr@example.com (HR)", "[|HR|hr@example.com]", false},
+		// net/mail 去掉 local part 的引号
+		{"quoted local part", `"john doe"@example.com`, "[|john doe@example.com]", false},
+		{"idn", "hr@xn--fiqs8s.cn", "[|hr@中国.cn]", false},
+		// 组外的地址排在前面
+		{"group", "a@example.com, hr: b@example.com, c@example.com;, d@example.com",
+			"[|a@example.com |d@example.com hr|b@example.com hr|c@example.com]", false},
+		{"empty group", "undisclosed-recipients:;", "[]", true},
+		// testReg 中的畸形地址
+		{"double at", `"=?gb18030?B?wLXV5Mjj?=" <rigphiojfs@@vip.sohu.com>`, "[]", true},
+		{"double at with others", `"=?gb18030?B?wLXV5Mjj?=" <rigphiojfs@@vip.sohu.com>, =?UTF-8?B?5byg55Cz?= <hr@example.com>`,
+			"[|张琳|hr@example.com]", true},
+		{"empty", " ", "[]", true},
+	}
+	for _, tt := range tests {
+		addrs, err := ParseAddressList(tt.value)
+		var got []string
+		for _, a := range addrs {
+			s := a.Group + "|" + a.Address
+			if a.Name != "" {
+				s = a.Group + "|" + a.Name + "|" + a.Address
+			}
+			got = append(got, s)
+		}
+		if fmt.Sprint(got) != tt.want {
+			t.Errorf("%s: got %v, want %s", tt.name, got, tt.want)
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+	}
+}
+
+func TestGetAddresses(t *testing.T) {
+	var h message.Header
+	h.Add("To", "a@example.com")
+	h.Add("To", `"=?gb18030?B?wLXV5Mjj?=" <rigphiojfs@@vip.sohu.com>`)
+	addrs, err := GetAddresses(h, "To")
+	if len(addrs) != 1 || addrs[0].Address != "a@example.com" {
+		t.Errorf("addrs %v, want a@example.com", addrs)
+	}
+	var aerr *AddressError
+	if !errors.As(err, &aerr) || aerr.Field != "To" || !strings.Contains(err.Error(), "rigphiojfs@@vip.sohu.com") {
+		t.Errorf("err = %v, want *AddressError naming the bad address", err)
+	}
+
+	if _, err = GetAddresses(h, "Cc"); !errors.Is(err, ErrNoAddress) {
+		t.Errorf("missing Cc: err = %v, want ErrNoAddress", err)
+	}
+	if got := GetFrom(h); got != "" {
+		t.Errorf("GetFrom without From = %q", got)
+	}
+}
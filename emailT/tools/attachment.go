@@ -0,0 +1,224 @@
+package tools
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/emersion/go-message"
+)
+
+// maxFilenameLen 落盘文件名的最大字节数
+const maxFilenameLen = 200
+
+// Attachment 邮件附件
+type Attachment struct {
+	Filename    string
+	ContentType string
+	ContentID   string
+	// Size 解码后的字节数, 跳过的附件也是实际的大小
+	Size int64
+	// SHA256 解码后内容的 sha256, 十六进制
+	SHA256 string
+	// Path 落盘后的文件路径, 未落盘时为空
+	Path string
+	// Skipped 为 true 时附件未被保存, 原因见 SkipReason
+	Skipped    bool
+	SkipReason string
+
+	data []byte
+}
+
+// Open 打开附件内容
+func (a *Attachment) Open() (io.ReadCloser, error) {
+	if a.Skipped {
+		return nil, fmt.Errorf("attachment %q skipped: %s", a.Filename, a.SkipReason)
+	}
+	if a.Path != "" {
+		return os.Open(a.Path)
+	}
+	return ioutil.NopCloser(bytes.NewReader(a.data)), nil
+}
+
+// AttachmentExtractor 附件提取配置
+type AttachmentExtractor struct {
+	// Dir 附件落盘目录, 为空时附件保存在内存中
+	Dir string
+	// MaxSize 单个附件的最大字节数, 超过的附件跳过, 0 为不限制
+	MaxSize int64
+	// Security 不为空时先解密再提取加密邮件中的附件
+	Security *Security
+}
+
+// ExtractAttachments 从原始邮件中提取附件, 附件内容保存在内存中
+func ExtractAttachments(r io.Reader) ([]Attachment, error) {
+	return new(AttachmentExtractor).Extract(r)
+}
+
+// Extract 流式遍历邮件并提取附件, 超过 MaxSize 的附件会被跳过并注明原因
+func (x *AttachmentExtractor) Extract(r io.Reader) (atts []Attachment, err error) {
+	if x.Dir != "" {
+		if err = os.MkdirAll(x.Dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	_, err = x.Security.WalkMessage(r, func(p *Part, body io.Reader) error {
+		if !p.IsAttachment() {
+			return nil
+		}
+		att, err := x.Save(p, body)
+		if err != nil {
+			return err
+		}
+		atts = append(atts, att)
+		return nil
+	})
+	return atts, err
+}
+
+// Save 保存一个附件节点, body 为解码传输编码后的内容
+func (x *AttachmentExtractor) Save(p *Part, body io.Reader) (att Attachment, err error) {
+	att = Attachment{
+		Filename:    p.Filename,
+		ContentType: attachmentType(p),
+		ContentID:   p.ContentID,
+	}
+	src := body
+	if x.MaxSize > 0 {
+		body = io.LimitReader(body, x.MaxSize+1)
+	}
+	hash := sha256.New()
+	var w io.Writer
+	var buf bytes.Buffer
+	var f *os.File
+	if x.Dir != "" {
+		if f, err = createUnique(x.Dir, SafeFilename(p.Filename, att.ContentType)); err != nil {
+			return att, err
+		}
+		defer f.Close()
+		w = io.MultiWriter(f, hash)
+	} else {
+		w = io.MultiWriter(&buf, hash)
+	}
+
+	att.Size, err = io.Copy(w, body)
+	if err != nil {
+		if f != nil {
+			os.Remove(f.Name())
+		}
+		return att, fmt.Errorf("attachment %q: %v", p.Filename, err)
+	}
+	if x.MaxSize > 0 && att.Size > x.MaxSize {
+		if f != nil {
+			os.Remove(f.Name())
+		}
+		// 剩下的内容不保存, 只计算大小
+		rest, err := io.Copy(ioutil.Discard, src)
+		att.Size += rest
+		if err != nil {
+			return att, fmt.Errorf("attachment %q: %v", p.Filename, err)
+		}
+		att.Skipped = true
+		att.SkipReason = fmt.Sprintf("size exceeds limit of %d bytes", x.MaxSize)
+		return att, nil
+	}
+	att.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if f != nil {
+		att.Path = f.Name()
+	} else {
+		att.data = buf.Bytes()
+	}
+	return att, nil
+}
+
+// attachmentType application/octet-stream 时根据扩展名推断类型
+func attachmentType(p *Part) string {
+	if p.ContentType != "application/octet-stream" {
+		return p.ContentType
+	}
+	if t := mime.TypeByExtension(filepath.Ext(p.Filename)); t != "" {
+		mediaType, _, _ := mime.ParseMediaType(t)
+		return mediaType
+	}
+	return p.ContentType
+}
+
+// DecodeFilename 取出节点的文件名, 依次尝试 Content-Disposition 的 filename
+// 和 Content-Type 的 name, 支持 RFC 2231 和 RFC 2047 两种编码
+func DecodeFilename(header message.Header) (filename string) {
+	disp, _ := ParseMediaType(header.Get("Content-Disposition"))
+	if filename = disp.Params["filename"]; filename == "" {
+		filename = GetMediaType(header).Params["name"]
+	}
+	filename = decodeRFC2047(filename)
+	if !utf8.ValidString(filename) {
+		filename = string(decodeCharset("", []byte(filename)))
+	}
+	return strings.TrimSpace(filename)
+}
+
+// SafeFilename 把附件名转换为可以安全落盘的文件名: 去掉路径和非法字符,
+// 限制长度, 为空时按类型生成
+func SafeFilename(name, contentType string) string {
+	name = strings.ReplaceAll(name, `\`, "/")
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+	if name == "" {
+		name = "attachment"
+		if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+			name += exts[0]
+		}
+	}
+	if len(name) > maxFilenameLen {
+		ext := filepath.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		name = truncateUTF8(strings.TrimSuffix(name, ext), maxFilenameLen-len(ext)) + ext
+	}
+	return name
+}
+
+func truncateUTF8(s string, n int) string {
+	for len(s) > n {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	return s
+}
+
+// createUnique 在 dir 下创建文件, 重名时依次加上 (1)、(2)...
+func createUnique(dir, name string) (*os.File, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; i < 10000; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+		f, err := os.OpenFile(filepath.Join(dir, candidate), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("too many files named %q in %s", name, dir)
+}
@@ -0,0 +1,40 @@
+package tools
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestExtractSkipsOversize(t *testing.T) {
+	data := readBoss(t)
+	atts, err := ExtractAttachments(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(atts) != 1 || atts[0].Skipped || atts[0].Size == 0 {
+		t.Fatalf("attachments %+v, want the resume", atts)
+	}
+	size := atts[0].Size
+
+	for _, dir := range []string{"", t.TempDir()} {
+		x := &AttachmentExtractor{Dir: dir, MaxSize: size / 2}
+		atts, err = x.Extract(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := atts[0]
+		// 跳过的附件保留实际大小, 但不保存内容
+		if !a.Skipped || a.SkipReason == "" || a.Size != size || a.Path != "" || a.SHA256 != "" {
+			t.Errorf("dir %q: got %+v, want skipped with size %d", dir, a, size)
+		}
+		if _, err = a.Open(); err == nil {
+			t.Errorf("dir %q: opened a skipped attachment", dir)
+		}
+		if dir != "" {
+			if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+				t.Errorf("%d files left in %s", len(files), dir)
+			}
+		}
+	}
+}
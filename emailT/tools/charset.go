@@ -0,0 +1,131 @@
+package tools
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/emersion/go-message"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// CharsetRegistry 字符集注册表, 字符集名称和别名均不区分大小写
+type CharsetRegistry struct {
+	mu       sync.RWMutex
+	charsets map[string]encoding.Encoding
+}
+
+// NewCharsetRegistry 创建一个空的字符集注册表
+func NewCharsetRegistry() *CharsetRegistry {
+	return &CharsetRegistry{charsets: make(map[string]encoding.Encoding)}
+}
+
+// DefaultCharsets 邮件头和正文共用的默认字符集注册表
+var DefaultCharsets = NewDefaultCharsetRegistry()
+
+func init() {
+	// 让 go-message 解码邮件头时也走同一个注册表
+	message.CharsetReader = DefaultCharsets.NewReader
+}
+
+// NewDefaultCharsetRegistry 创建包含常见中日韩及西文字符集的注册表
+func NewDefaultCharsetRegistry() *CharsetRegistry {
+	r := NewCharsetRegistry()
+	r.Register(unicode.UTF8, "utf-8", "utf8", "us-ascii", "ascii", "ansi_x3.4-1968", "unicode-1-1-utf-8")
+	r.Register(unicode.UTF16(unicode.BigEndian, unicode.UseBOM), "utf-16", "utf16")
+	r.Register(unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), "utf-16be")
+	r.Register(unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), "utf-16le")
+	r.Register(UTF7, "utf-7", "utf7", "unicode-1-1-utf-7", "csunicode11utf7")
+
+	// 简体中文, gb2312 和 gbk 统一按 gbk 解码 (gbk 是 gb2312 的超集)
+	r.Register(simplifiedchinese.GBK, "gbk", "gb2312", "gb_2312-80", "csgb2312", "cp936", "ms936", "windows-936", "x-gbk", "euc-cn", "x-euc-cn")
+	r.Register(simplifiedchinese.GB18030, "gb18030", "gb-18030")
+	r.Register(simplifiedchinese.HZGB2312, "hz-gb-2312", "hz")
+	// 繁体中文
+	r.Register(traditionalchinese.Big5, "big5", "big-5", "big5-hkscs", "cn-big5", "csbig5", "x-x-big5", "cp950")
+	// 日文
+	r.Register(japanese.ISO2022JP, "iso-2022-jp", "csiso2022jp")
+	r.Register(japanese.ShiftJIS, "shift_jis", "shift-jis", "sjis", "x-sjis", "ms_kanji", "csshiftjis", "cp932", "windows-31j")
+	r.Register(japanese.EUCJP, "euc-jp", "x-euc-jp", "cseucpkdfmtjapanese")
+	// 韩文, 韩国邮件常用 ks_c_5601-1987 表示 cp949
+	r.Register(korean.EUCKR, "euc-kr", "cseuckr", "ks_c_5601-1987", "ks_c_5601-1989", "ksc5601", "ksc_5601", "cp949", "windows-949", "uhc")
+
+	// 俄文
+	r.Register(charmap.KOI8R, "koi8-r", "koi8r", "cskoi8r")
+	r.Register(charmap.KOI8U, "koi8-u", "koi8u")
+
+	windows := []*charmap.Charmap{
+		charmap.Windows1250, charmap.Windows1251, charmap.Windows1252,
+		charmap.Windows1253, charmap.Windows1254, charmap.Windows1255,
+		charmap.Windows1256, charmap.Windows1257, charmap.Windows1258,
+	}
+	for i, enc := range windows {
+		n := fmt.Sprint(1250 + i)
+		r.Register(enc, "windows-"+n, "cp"+n, "x-cp"+n)
+	}
+
+	iso := map[int]*charmap.Charmap{
+		1: charmap.ISO8859_1, 2: charmap.ISO8859_2, 3: charmap.ISO8859_3,
+		4: charmap.ISO8859_4, 5: charmap.ISO8859_5, 6: charmap.ISO8859_6,
+		7: charmap.ISO8859_7, 8: charmap.ISO8859_8, 9: charmap.ISO8859_9,
+		10: charmap.ISO8859_10, 13: charmap.ISO8859_13, 14: charmap.ISO8859_14,
+		15: charmap.ISO8859_15, 16: charmap.ISO8859_16,
+	}
+	for n, enc := range iso {
+		r.Register(enc, fmt.Sprintf("iso-8859-%d", n), fmt.Sprintf("iso8859-%d", n), fmt.Sprintf("iso_8859-%d", n))
+	}
+	r.Register(charmap.ISO8859_1, "latin1", "l1")
+	r.Register(charmap.ISO8859_2, "latin2", "l2")
+	r.Register(charmap.ISO8859_15, "latin-9", "latin9")
+	return r
+}
+
+// Register 注册字符集, names 为字符集名称及其别名
+func (r *CharsetRegistry) Register(enc encoding.Encoding, names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, name := range names {
+		r.charsets[normalizeCharset(name)] = enc
+	}
+}
+
+// Lookup 按名称或别名查找字符集
+func (r *CharsetRegistry) Lookup(name string) (enc encoding.Encoding, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	enc, ok = r.charsets[normalizeCharset(name)]
+	return enc, ok
+}
+
+// NewReader 返回将 charset 转为 utf-8 的 reader, 可直接用作
+// mime.WordDecoder.CharsetReader
+func (r *CharsetRegistry) NewReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, ok := r.Lookup(charset)
+	if !ok {
+		return nil, fmt.Errorf("unhandle charset:%s", charset)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// Decode 将 data 从 charset 转为 utf-8
+func (r *CharsetRegistry) Decode(charset string, data []byte) ([]byte, error) {
+	rd, err := r.NewReader(charset, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(rd)
+}
+
+// normalizeCharset 统一字符集名称: 小写, 去掉引号和空白
+func normalizeCharset(name string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(name), `"'`))
+}
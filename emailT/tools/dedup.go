@@ -0,0 +1,292 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
+	"io/ioutil"
+	"math/bits"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// DupKind 重复的类型
+type DupKind int
+
+const (
+	// NotDuplicate 不是重复邮件
+	NotDuplicate DupKind = iota
+	// DupMessageID Message-ID 相同
+	DupMessageID
+	// DupExact 规范化后的正文和附件完全相同
+	DupExact
+	// DupNear 正文近似相同, 如转发或重发时加了几句话. 招聘网站的通知邮件用同一个模板,
+	// 不同候选人的邮件也会近似相同, 只能作为提示
+	DupNear
+)
+
+// Skip 是否应该跳过该邮件, 只有 Message-ID 或内容完全相同时才跳过
+func (k DupKind) Skip() bool {
+	return k == DupMessageID || k == DupExact
+}
+
+func (k DupKind) String() string {
+	switch k {
+	case DupMessageID:
+		return "message-id"
+	case DupExact:
+		return "exact"
+	case DupNear:
+		return "near"
+	default:
+		return "none"
+	}
+}
+
+// minSimHashFeatures 特征太少的正文不做近似判断, 避免误判
+const minSimHashFeatures = 8
+
+// DefaultMaxDistance simhash 汉明距离不超过该值视为近似重复. 邮件正文普遍较短,
+// 改动几个字就会有 5~7 位不同, 而无关文本平均相差 32 位
+const DefaultMaxDistance = 7
+
+// quoteHeaderReg 转发/回复时客户端插入的邮件头行
+var quoteHeaderReg = regexp.MustCompile(`(?im)^\s*(from|to|cc|sent|date|subject|发件人|收件人|抄送|发送时间|时间|日期|主题)\s*[:：].*$`)
+
+// quoteSeparatorReg 转发/回复分隔线
+var quoteSeparatorReg = regexp.MustCompile(`(?m)^\s*(-{2,}|_{5,}|={5,}).*$`)
+
+// Fingerprint 邮件指纹
+type Fingerprint struct {
+	MessageID string `json:"message_id"`
+	// Hash 规范化正文和附件内容的 sha256
+	Hash string `json:"hash"`
+	// SimHash 正文的 simhash, 0 表示正文太短不参与近似判断
+	SimHash uint64 `json:"simhash"`
+}
+
+// NewFingerprint 计算邮件指纹
+func NewFingerprint(msg *ParsedMessage) Fingerprint {
+	body := NormalizeBody(msg.PlainText())
+	h := sha256.New()
+	h.Write([]byte(body))
+	var sums []string
+	for _, a := range msg.Attachments {
+		if a.Body == nil && a.SHA256 != "" {
+			sums = append(sums, a.SHA256)
+			continue
+		}
+		sum := sha256.Sum256(a.Body)
+		sums = append(sums, hex.EncodeToString(sum[:]))
+	}
+	sort.Strings(sums)
+	for _, s := range sums {
+		h.Write([]byte(s))
+	}
+	return Fingerprint{
+		MessageID: firstMsgID(GetMessageID(msg.Header)),
+		Hash:      hex.EncodeToString(h.Sum(nil)),
+		SimHash:   SimHash(body),
+	}
+}
+
+// NormalizeBody 规范化正文: 去掉引用符号、转发时插入的邮件头和分隔线,
+// 转为小写并合并空白
+func NormalizeBody(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimLeft(l, "> \t")
+	}
+	text = strings.Join(lines, "\n")
+	text = quoteHeaderReg.ReplaceAllString(text, "")
+	text = quoteSeparatorReg.ReplaceAllString(text, "")
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// SimHash 计算 64 位 simhash, 英文按单词、中日韩文字按相邻两个字取特征
+func SimHash(text string) uint64 {
+	features := make(map[string]int)
+	var word []rune
+	var prev rune
+	flushWord := func() {
+		if len(word) > 0 {
+			features[string(word)]++
+			word = word[:0]
+		}
+	}
+	for _, c := range text {
+		switch {
+		case isWide(c):
+			flushWord()
+			if prev != 0 {
+				features[string([]rune{prev, c})]++
+			}
+			prev = c
+			continue
+		case unicode.IsLetter(c) || unicode.IsDigit(c):
+			word = append(word, c)
+		default:
+			flushWord()
+		}
+		prev = 0
+	}
+	flushWord()
+	if len(features) < minSimHashFeatures {
+		return 0
+	}
+
+	var v [64]int
+	for f, weight := range features {
+		h := fnv.New64a()
+		h.Write([]byte(f))
+		sum := h.Sum64()
+		for i := 0; i < 64; i++ {
+			if sum&(1<<uint(i)) != 0 {
+				v[i] += weight
+			} else {
+				v[i] -= weight
+			}
+		}
+	}
+	var out uint64
+	for i := 0; i < 64; i++ {
+		if v[i] > 0 {
+			out |= 1 << uint(i)
+		}
+	}
+	return out
+}
+
+// Deduper 记录已处理过的邮件, 判断新邮件是否重复
+type Deduper struct {
+	// MaxDistance simhash 汉明距离不超过该值视为近似重复
+	MaxDistance int
+
+	mu      sync.Mutex
+	byID    map[string]bool
+	byHash  map[string]string
+	entries []Fingerprint
+	// dirty 上次保存之后有新的记录
+	dirty bool
+	// saveMu 保证写文件按顺序进行, 旧的快照不会覆盖新的文件
+	saveMu sync.Mutex
+}
+
+// NewDeduper 创建空的 Deduper
+func NewDeduper() *Deduper {
+	return &Deduper{
+		MaxDistance: DefaultMaxDistance,
+		byID:        make(map[string]bool),
+		byHash:      make(map[string]string),
+	}
+}
+
+// LoadDeduper 从文件加载已处理邮件的记录, 文件不存在时返回空的 Deduper
+func LoadDeduper(path string) (*Deduper, error) {
+	d := NewDeduper()
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []Fingerprint
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	for _, fp := range entries {
+		d.add(fp)
+	}
+	d.dirty = false
+	return d, nil
+}
+
+// Save 把记录写入文件, 先写临时文件再改名, 避免写到一半时文件损坏.
+// 没有新记录时不写文件. 可以和 Check、Add 并发调用, 一次收取结束后调用一次即可
+func (d *Deduper) Save(path string) error {
+	d.saveMu.Lock()
+	defer d.saveMu.Unlock()
+	d.mu.Lock()
+	if !d.dirty {
+		d.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(d.entries)
+	d.dirty = false
+	d.mu.Unlock()
+	if err == nil {
+		err = WriteFileAtomic(path, data, 0600)
+	}
+	if err != nil {
+		d.mu.Lock()
+		d.dirty = true
+		d.mu.Unlock()
+	}
+	return err
+}
+
+// SeenID Message-ID 是否已经处理过, 可以在下载正文之前调用
+func (d *Deduper) SeenID(messageID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return messageID != "" && d.byID[messageID]
+}
+
+// Check 判断邮件是否重复, 重复时返回原邮件的 Message-ID
+func (d *Deduper) Check(fp Fingerprint) (kind DupKind, original string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.check(fp)
+}
+
+// CheckAndAdd 判断邮件是否重复, 不需要跳过时同时记录. 多个协程同时处理同一封邮件时只有一个得到不跳过的结果
+func (d *Deduper) CheckAndAdd(fp Fingerprint) (kind DupKind, original string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if kind, original = d.check(fp); !kind.Skip() {
+		d.add(fp)
+	}
+	return kind, original
+}
+
+// Add 记录已处理的邮件
+func (d *Deduper) Add(fp Fingerprint) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.add(fp)
+}
+
+func (d *Deduper) check(fp Fingerprint) (kind DupKind, original string) {
+	if fp.MessageID != "" && d.byID[fp.MessageID] {
+		return DupMessageID, fp.MessageID
+	}
+	if id, ok := d.byHash[fp.Hash]; ok {
+		return DupExact, id
+	}
+	if fp.SimHash == 0 {
+		return NotDuplicate, ""
+	}
+	for _, e := range d.entries {
+		if e.SimHash != 0 && bits.OnesCount64(e.SimHash^fp.SimHash) <= d.MaxDistance {
+			return DupNear, e.MessageID
+		}
+	}
+	return NotDuplicate, ""
+}
+
+func (d *Deduper) add(fp Fingerprint) {
+	if fp.MessageID != "" {
+		d.byID[fp.MessageID] = true
+	}
+	if _, ok := d.byHash[fp.Hash]; !ok {
+		d.byHash[fp.Hash] = fp.MessageID
+	}
+	d.entries = append(d.entries, fp)
+	d.dirty = true
+}
@@ -0,0 +1,69 @@
+package tools
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readBoss 读取 BOSS 直聘转发简历的邮件, 按 replace 成对替换原文
+func readBoss(t *testing.T, replace ...string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "boss.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(data)
+	for i := 0; i+1 < len(replace); i += 2 {
+		if !strings.Contains(s, replace[i]) {
+			t.Fatalf("%q not found in boss.eml", replace[i])
+		}
+		s = strings.Replace(s, replace[i], replace[i+1], -1)
+	}
+	return []byte(s)
+}
+
+func parseBoss(t *testing.T, replace ...string) *ParsedMessage {
+	t.Helper()
+	m, err := ParseMessage(bytes.NewReader(readBoss(t, replace...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestDeduperKeepsTemplateMail(t *testing.T) {
+	first := NewFingerprint(parseBoss(t))
+	// 同一个职位的另一个候选人, 邮件模板相同, 只有姓名、职位、薪资和链接不同
+	other := NewFingerprint(parseBoss(t,
+		"<span>=E6=\r\n=9D=8E=E6=B5=A9=E7=84=B6</span>", "<span>=E7=8E=8B=E5=B0=8F=E6=98=8E</span>",
+		"| =E6=9D=AD=E5=B7=9E 6-10K", "| =E4=B8=8A=E6=B5=B7 8-15K",
+		"<span>6-7K<=", "<span>9-12K<=",
+		"uuid=3Ddc9f268c7916e16e03", "uuid=3D5a0b1c2d3e4f5a6b7c",
+		"<1597889716168.49505531@zhipin.com>", "<1597890012345.49505877@zhipin.com>",
+	))
+	if first.Hash == other.Hash || first.MessageID == other.MessageID {
+		t.Fatal("the two candidates have the same fingerprint")
+	}
+
+	d := NewDeduper()
+	if kind, _ := d.CheckAndAdd(first); kind != NotDuplicate {
+		t.Fatalf("first mail: %s, want none", kind)
+	}
+	// simhash 很接近, 只提示近似重复, 不能跳过
+	if kind, original := d.CheckAndAdd(other); kind != DupNear || kind.Skip() || original != first.MessageID {
+		t.Errorf("other candidate: %s of %s, want near of %s and the mail kept", kind, original, first.MessageID)
+	}
+
+	// 重新投递的同一封邮件和换了 Message-ID 的相同内容仍然跳过
+	if kind, _ := d.CheckAndAdd(first); kind != DupMessageID || !kind.Skip() {
+		t.Errorf("redelivered mail: %s, want message-id", kind)
+	}
+	resent := first
+	resent.MessageID = "<resent@zhipin.com>"
+	if kind, original := d.CheckAndAdd(resent); kind != DupExact || original != first.MessageID {
+		t.Errorf("resent mail: %s of %s, want exact of %s", kind, original, first.MessageID)
+	}
+}
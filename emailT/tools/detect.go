@@ -0,0 +1,116 @@
+package tools
+
+import (
+	"bytes"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/saintfish/chardet"
+)
+
+// metaCharsetReg 匹配 <meta charset="x"> 和 <meta http-equiv content="...; charset=x">
+var metaCharsetReg = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([\w.:-]+)`)
+
+// metaSniffLen 只在前面这么多字节里找 <meta charset>
+const metaSniffLen = 4096
+
+// detectCandidates 统计检测时接受的字符集, chardet 名称 -> 注册表名称
+var detectCandidates = map[string]string{
+	"UTF-8":       "utf-8",
+	"GB-18030":    "gb18030",
+	"Big5":        "big5",
+	"Shift_JIS":   "shift_jis",
+	"EUC-KR":      "euc-kr",
+	"EUC-JP":      "euc-jp",
+	"ISO-2022-JP": "iso-2022-jp",
+}
+
+// DetectCharset 检测 data 的字符集, 依次检查 HTML <meta charset>、BOM,
+// 最后做统计检测. 无法判断时返回空字符串
+func DetectCharset(data []byte) (name string, confidence float64) {
+	return DetectCharsetDeclared("", data)
+}
+
+// DetectCharsetDeclared 同 DetectCharset, 但优先采用 Content-Type 中声明的 charset
+func DetectCharsetDeclared(declared string, data []byte) (name string, confidence float64) {
+	if name = checkDeclared(declared, data); name != "" {
+		return name, 1
+	}
+	head := data
+	if len(head) > metaSniffLen {
+		head = head[:metaSniffLen]
+	}
+	if m := metaCharsetReg.FindSubmatch(head); m != nil {
+		if name = checkDeclared(string(m[1]), data); name != "" {
+			return name, 0.95
+		}
+	}
+	if name = sniffBOM(data); name != "" {
+		return name, 1
+	}
+	return detectStatistical(data)
+}
+
+// checkDeclared 声明的字符集能识别且与内容不矛盾时返回规范化的名称
+func checkDeclared(declared string, data []byte) string {
+	declared = normalizeCharset(declared)
+	if declared == "" {
+		return ""
+	}
+	if _, ok := DefaultCharsets.Lookup(declared); !ok {
+		return ""
+	}
+	// 很多发信方不管实际编码一律声明 utf-8 或 us-ascii
+	if (declared == "utf-8" || declared == "us-ascii") && !utf8.Valid(data) {
+		return ""
+	}
+	return declared
+}
+
+func sniffBOM(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xef, 0xbb, 0xbf}):
+		return "utf-8"
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		return "utf-16be"
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		return "utf-16le"
+	}
+	return ""
+}
+
+func detectStatistical(data []byte) (name string, confidence float64) {
+	if len(data) == 0 {
+		return "", 0
+	}
+	// iso-2022-jp 是 7bit 编码, 要在纯 ascii 判断之前识别
+	if bytes.Contains(data, []byte("\x1b$B")) || bytes.Contains(data, []byte("\x1b$@")) {
+		return "iso-2022-jp", 0.9
+	}
+	if isASCII(data) {
+		return "us-ascii", 1
+	}
+	// 随机的多字节编码文本几乎不可能恰好是合法的 utf-8
+	if utf8.Valid(data) {
+		return "utf-8", 0.95
+	}
+	results, err := chardet.NewTextDetector().DetectAll(data)
+	if err != nil {
+		return "", 0
+	}
+	for _, r := range results {
+		if name, ok := detectCandidates[r.Charset]; ok {
+			return name, float64(r.Confidence) / 100
+		}
+	}
+	return "", 0
+}
+
+func isASCII(data []byte) bool {
+	for _, c := range data {
+		if c >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
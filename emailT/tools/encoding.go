@@ -0,0 +1,170 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"strings"
+)
+
+// ErrUnknownEncoding 不支持的 Content-Transfer-Encoding
+var ErrUnknownEncoding = errors.New("unknown transfer encoding")
+
+// DecodeTransfer 按 Content-Transfer-Encoding 返回流式解码的 reader,
+// 不会把整个内容读入内存
+func DecodeTransfer(enc string, r io.Reader) (io.Reader, error) {
+	switch normalizeEncoding(enc) {
+	case "", "7bit", "8bit", "binary":
+		return r, nil
+	case "base64":
+		return NewBase64Reader(r), nil
+	case "quoted-printable":
+		return quotedprintable.NewReader(r), nil
+	case "uuencode", "x-uuencode", "x-uue", "uue":
+		return NewUUReader(r), nil
+	default:
+		return r, fmt.Errorf("%w: %s", ErrUnknownEncoding, enc)
+	}
+}
+
+func normalizeEncoding(enc string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(enc), `"'`))
+}
+
+var base64Index [256]byte
+
+func init() {
+	for i := range base64Index {
+		base64Index[i] = 0xff
+	}
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	for i := 0; i < len(alphabet); i++ {
+		base64Index[alphabet[i]] = byte(i)
+	}
+	// 兼容 url-safe 字符集
+	base64Index['-'] = 62
+	base64Index['_'] = 63
+}
+
+// base64Reader 宽松的 base64 解码: 忽略换行及其它非法字符, 允许缺少 padding,
+// 也能处理多段拼接在一起的 base64
+type base64Reader struct {
+	r    io.Reader
+	buf  [4096]byte
+	quad [4]byte
+	n    int
+	dec  []byte
+	out  []byte
+	err  error
+}
+
+// NewBase64Reader 返回宽松的 base64 解码 reader
+func NewBase64Reader(r io.Reader) io.Reader {
+	return &base64Reader{r: r}
+}
+
+func (d *base64Reader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.dec = d.dec[:0]
+		n, err := d.r.Read(d.buf[:])
+		for _, c := range d.buf[:n] {
+			if c == '=' {
+				d.flush()
+				continue
+			}
+			v := base64Index[c]
+			if v == 0xff {
+				continue
+			}
+			d.quad[d.n] = v
+			d.n++
+			if d.n == 4 {
+				d.flush()
+			}
+		}
+		if err != nil {
+			d.flush()
+			d.err = err
+		}
+		d.out = d.dec
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// flush 解码当前攒下的 2~4 个字符
+func (d *base64Reader) flush() {
+	q := d.quad
+	switch d.n {
+	case 4:
+		d.dec = append(d.dec, q[0]<<2|q[1]>>4, q[1]<<4|q[2]>>2, q[2]<<6|q[3])
+	case 3:
+		d.dec = append(d.dec, q[0]<<2|q[1]>>4, q[1]<<4|q[2]>>2)
+	case 2:
+		d.dec = append(d.dec, q[0]<<2|q[1]>>4)
+	}
+	d.n = 0
+}
+
+// uuReader 流式解码 uuencode, 跳过 begin 行之前的内容, 遇到 end 结束
+type uuReader struct {
+	br      *bufio.Reader
+	started bool
+	out     []byte
+	err     error
+}
+
+// NewUUReader 返回 uuencode 解码 reader
+func NewUUReader(r io.Reader) io.Reader {
+	return &uuReader{br: bufio.NewReader(r)}
+}
+
+func (u *uuReader) Read(p []byte) (int, error) {
+	for len(u.out) == 0 {
+		if u.err != nil {
+			return 0, u.err
+		}
+		line, err := u.br.ReadBytes('\n')
+		if err != nil {
+			u.err = err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if !u.started {
+			u.started = bytes.HasPrefix(line, []byte("begin "))
+			continue
+		}
+		if bytes.Equal(line, []byte("end")) {
+			u.err = io.EOF
+			continue
+		}
+		u.out = decodeUULine(line)
+	}
+	n := copy(p, u.out)
+	u.out = u.out[n:]
+	return n, nil
+}
+
+func decodeUULine(line []byte) []byte {
+	if len(line) == 0 {
+		return nil
+	}
+	length := int((line[0] - ' ') & 0x3f)
+	out := make([]byte, 0, length+2)
+	for i := 1; len(out) < length; i += 4 {
+		var c [4]byte
+		for j := 0; j < 4; j++ {
+			if i+j < len(line) {
+				c[j] = (line[i+j] - ' ') & 0x3f
+			}
+		}
+		out = append(out, c[0]<<2|c[1]>>4, c[1]<<4|c[2]>>2, c[2]<<6|c[3])
+	}
+	return out[:length]
+}
@@ -0,0 +1,56 @@
+package tools
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecodeTransfer(t *testing.T) {
+	tests := []struct {
+		name string
+		enc  string
+		in   string
+		want string
+	}{
+		{"7bit", "7bit", "plain text", "plain text"},
+		{"empty", "", "plain text", "plain text"},
+		{"case and quotes", ` "Base64" `, "aGVsbG8=", "hello"},
+		{"qp soft break", "quoted-printable", "=E6=9D=8E=E6=\r\n=B5=A9=E7=84=B6", "李浩然"},
+		{"qp escapes", "quoted-printable", "a=3Db c=20\r\nd", "a=b c \r\nd"},
+		{"qp trailing space", "quoted-printable", "line   \r\nnext", "line\r\nnext"},
+		{"qp lowercase hex", "quoted-printable", "=e6=9d=ad=e5=b7=9e", "杭州"},
+		{"base64 lines", "base64", "5p2t5bee\r\n5p2O5rWp\r\n54S2", "杭州李浩然"},
+		{"base64 no padding", "base64", "aGVsbG8", "hello"},
+		{"base64 concatenated", "base64", "aGk=\r\nYnll", "hibye"},
+		{"base64 url-safe", "base64", "-_8=", "\xfb\xff"},
+		{"base64 garbage", "base64", "aGV*sb#G8=", "hello"},
+		{"uuencode", "x-uuencode", "preamble\nbegin 644 a.txt\n%:&5L;&\\`\n`\nend\n", "hello"},
+	}
+	for _, tt := range tests {
+		r, err := DecodeTransfer(tt.enc, iotest.OneByteReader(strings.NewReader(tt.in)))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("%s: read: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// 未知的编码原样返回
+	r, err := DecodeTransfer("x-custom", strings.NewReader("raw"))
+	if !errors.Is(err, ErrUnknownEncoding) {
+		t.Errorf("x-custom: err = %v, want ErrUnknownEncoding", err)
+	}
+	if got, _ := ioutil.ReadAll(r); string(got) != "raw" {
+		t.Errorf("x-custom: got %q, want raw", got)
+	}
+}
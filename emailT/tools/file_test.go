@@ -0,0 +1,38 @@
+package tools
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "seen.json")
+	for _, data := range []string{"first", "second"} {
+		if err := WriteFileAtomic(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(path)
+		if err != nil || string(got) != data {
+			t.Fatalf("read %q, %v; want %q", got, err, data)
+		}
+	}
+	if fi, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", fi.Mode().Perm())
+	}
+	// 临时文件已经改名或删除
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("%d files in dir, want only the target", len(files))
+	}
+
+	// 目录不存在时返回错误, 不留下临时文件
+	if err := WriteFileAtomic(filepath.Join(dir, "missing", "x.json"), []byte("x"), 0600); err == nil {
+		t.Error("write into a missing directory succeeded")
+	}
+}
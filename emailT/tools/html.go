@@ -0,0 +1,311 @@
+package tools
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTMLToText 把 html 邮件正文转为纯文本: 去掉 style/script, 表格按列对齐,
+// 链接地址以脚注形式列在末尾, 解码实体并合并空白
+func HTMLToText(s string) string {
+	doc, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		return ""
+	}
+	r := &textRenderer{links: &linkNotes{index: map[string]int{}}}
+	r.render(doc)
+	text := strings.TrimSpace(r.String())
+	if len(r.links.urls) > 0 {
+		var b strings.Builder
+		b.WriteString(text)
+		b.WriteString("\n\n")
+		for i, u := range r.links.urls {
+			fmt.Fprintf(&b, "[%d] %s\n", i+1, u)
+		}
+		text = b.String()
+	}
+	return strings.TrimSpace(text)
+}
+
+// PlainText 纯文本正文, 没有 text/plain 时由 html 正文转换得到
+func (msg *ParsedMessage) PlainText() string {
+	if strings.TrimSpace(msg.Text) != "" || msg.HTML == "" {
+		return msg.Text
+	}
+	return HTMLToText(msg.HTML)
+}
+
+// linkNotes 链接脚注, 同一个地址只出现一次
+type linkNotes struct {
+	urls  []string
+	index map[string]int
+}
+
+func (l *linkNotes) add(u string) int {
+	if n, ok := l.index[u]; ok {
+		return n
+	}
+	l.urls = append(l.urls, u)
+	l.index[u] = len(l.urls)
+	return len(l.urls)
+}
+
+// textRenderer 负责合并空白: 连续空白只输出一个空格, 块级元素之间换行
+type textRenderer struct {
+	b        strings.Builder
+	links    *linkNotes
+	space    bool
+	newlines int
+	pre      int
+}
+
+func (r *textRenderer) String() string {
+	return r.b.String()
+}
+
+func (r *textRenderer) text(s string) {
+	if r.pre > 0 {
+		r.flush()
+		r.b.WriteString(s)
+		return
+	}
+	for _, c := range s {
+		if unicode.IsSpace(c) {
+			r.space = true
+			continue
+		}
+		r.flush()
+		r.b.WriteRune(c)
+	}
+}
+
+func (r *textRenderer) flush() {
+	if r.b.Len() == 0 {
+		r.newlines, r.space = 0, false
+		return
+	}
+	if r.newlines > 0 {
+		r.b.WriteString(strings.Repeat("\n", r.newlines))
+	} else if r.space {
+		r.b.WriteByte(' ')
+	}
+	r.newlines, r.space = 0, false
+}
+
+func (r *textRenderer) newline(n int) {
+	if n > r.newlines {
+		r.newlines = n
+	}
+	r.space = false
+}
+
+var skipAtoms = map[atom.Atom]bool{
+	atom.Head: true, atom.Style: true, atom.Script: true, atom.Noscript: true,
+	atom.Template: true, atom.Title: true, atom.Iframe: true, atom.Object: true,
+}
+
+var blockAtoms = map[atom.Atom]int{
+	atom.P: 2, atom.H1: 2, atom.H2: 2, atom.H3: 2, atom.H4: 2, atom.H5: 2, atom.H6: 2,
+	atom.Blockquote: 2, atom.Pre: 2, atom.Ul: 1, atom.Ol: 1, atom.Div: 1,
+	atom.Section: 1, atom.Article: 1, atom.Header: 1, atom.Footer: 1, atom.Tr: 1,
+	atom.Table: 1, atom.Dl: 1, atom.Dt: 1, atom.Dd: 1, atom.Hr: 1, atom.Form: 1,
+}
+
+func (r *textRenderer) render(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		r.text(n.Data)
+		return
+	case html.ElementNode:
+	case html.DocumentNode:
+		r.renderChildren(n)
+		return
+	default:
+		return
+	}
+	if skipAtoms[n.DataAtom] || isHidden(n) {
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		r.newline(1)
+		r.flushLine()
+		return
+	case atom.Img:
+		if alt := strings.TrimSpace(attr(n, "alt")); alt != "" {
+			r.text(" " + alt + " ")
+		}
+		return
+	case atom.Table:
+		if !hasDescendant(n, atom.Table) {
+			r.newline(1)
+			r.renderTable(n)
+			r.newline(1)
+			return
+		}
+	case atom.Td, atom.Th:
+		// 布局表格中的单元格当作普通的块处理
+		r.newline(1)
+	case atom.Li:
+		r.newline(1)
+		r.flush()
+		r.b.WriteString("- ")
+		r.renderChildren(n)
+		r.newline(1)
+		return
+	case atom.Pre:
+		r.newline(2)
+		r.pre++
+		defer func() { r.pre-- }()
+	case atom.A:
+		before := r.b.Len()
+		r.renderChildren(n)
+		// 只包着图片等没有文字的链接不加脚注
+		if r.b.Len() > before {
+			r.linkNote(n)
+		}
+		return
+	}
+
+	if nl, ok := blockAtoms[n.DataAtom]; ok {
+		r.newline(nl)
+		r.renderChildren(n)
+		r.newline(nl)
+		return
+	}
+	r.renderChildren(n)
+}
+
+func (r *textRenderer) renderChildren(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		r.render(c)
+	}
+}
+
+// flushLine 输出 <br> 产生的换行, 连续的 <br> 保留为空行
+func (r *textRenderer) flushLine() {
+	if r.b.Len() > 0 {
+		r.b.WriteByte('\n')
+	}
+	r.newlines = 0
+}
+
+func (r *textRenderer) linkNote(n *html.Node) {
+	href := strings.TrimSpace(attr(n, "href"))
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		return
+	}
+	if strings.HasSuffix(strings.TrimSpace(r.b.String()), href) {
+		return
+	}
+	r.text(fmt.Sprintf("[%d]", r.links.add(href)))
+}
+
+// renderTable 渲染不含嵌套表格的数据表格, 各列按显示宽度对齐
+func (r *textRenderer) renderTable(table *html.Node) {
+	var rows [][]string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || skipAtoms[c.DataAtom] || isHidden(c) {
+				continue
+			}
+			if c.DataAtom != atom.Tr {
+				walk(c)
+				continue
+			}
+			var row []string
+			for td := c.FirstChild; td != nil; td = td.NextSibling {
+				if td.Type != html.ElementNode || (td.DataAtom != atom.Td && td.DataAtom != atom.Th) || isHidden(td) {
+					continue
+				}
+				cell := &textRenderer{links: r.links}
+				cell.renderChildren(td)
+				row = append(row, strings.Join(strings.Fields(cell.String()), " "))
+			}
+			if strings.TrimSpace(strings.Join(row, "")) != "" {
+				rows = append(rows, row)
+			}
+		}
+	}
+	walk(table)
+
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if w := displayWidth(cell); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+	for _, row := range rows {
+		var line strings.Builder
+		for i, cell := range row {
+			line.WriteString(cell)
+			if i < len(row)-1 {
+				line.WriteString(strings.Repeat(" ", widths[i]-displayWidth(cell)+2))
+			}
+		}
+		r.newline(1)
+		r.flush()
+		r.b.WriteString(strings.TrimRight(line.String(), " "))
+		r.newline(1)
+	}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func isHidden(n *html.Node) bool {
+	style := strings.ToLower(strings.Join(strings.Fields(attr(n, "style")), ""))
+	return strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden")
+}
+
+func hasDescendant(n *html.Node, a atom.Atom) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == a || hasDescendant(c, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// displayWidth 按等宽字体估算显示宽度, 中日韩全角字符占两列
+func displayWidth(s string) (w int) {
+	for len(s) > 0 {
+		c, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		if isWide(c) {
+			w += 2
+		} else {
+			w++
+		}
+	}
+	return w
+}
+
+func isWide(c rune) bool {
+	return c >= 0x1100 && (c <= 0x115f || c == 0x2329 || c == 0x232a ||
+		c >= 0x2e80 && c <= 0xa4cf && c != 0x303f ||
+		c >= 0xac00 && c <= 0xd7a3 ||
+		c >= 0xf900 && c <= 0xfaff ||
+		c >= 0xfe30 && c <= 0xfe4f ||
+		c >= 0xff00 && c <= 0xff60 ||
+		c >= 0xffe0 && c <= 0xffe6 ||
+		c >= 0x20000 && c <= 0x3fffd)
+}
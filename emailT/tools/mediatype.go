@@ -0,0 +1,97 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/emersion/go-message"
+)
+
+// ErrInvalidMediaType 头部值中找不到 type/subtype
+var ErrInvalidMediaType = errors.New("invalid media type")
+
+// MediaType 解析后的 Content-Type / Content-Disposition
+type MediaType struct {
+	// Type 小写的类型, 如 multipart/mixed、attachment
+	Type string
+	// Params 参数, key 为小写, RFC 2231 形式的参数已解码
+	Params map[string]string
+}
+
+// IsMultipart 是否为 multipart/*
+func (m MediaType) IsMultipart() bool {
+	return strings.HasPrefix(m.Type, "multipart/")
+}
+
+// ParseMediaType 解析 Content-Type 这类带参数的头部值. 先按 mime.ParseMediaType
+// 解析, 失败时退回到宽松模式, 兼容未加引号的分号、多余的空白和重复参数.
+// 宽松模式下也无法解析时返回 ErrInvalidMediaType, 但 Params 仍然可用
+func ParseMediaType(v string) (MediaType, error) {
+	mediaType, params, err := mime.ParseMediaType(v)
+	if err == nil {
+		m := MediaType{Type: strings.ToLower(mediaType), Params: params}
+		// mime 包会丢掉 utf-8 以外字符集的 RFC 2231 参数, 分段时只丢掉其中的一段,
+		// 这里全部重新解码
+		if strings.Contains(v, "*") {
+			_, raw := splitRawParams(v)
+			decoded := decodeRawParams(raw)
+			for k := range raw {
+				if star := strings.IndexByte(k, '*'); star > 0 {
+					m.Params[k[:star]] = decoded[k[:star]]
+				}
+			}
+		}
+		return m, nil
+	}
+
+	value, raw := splitRawParams(v)
+	m := MediaType{
+		Type:   strings.ToLower(strings.Join(strings.Fields(value), "")),
+		Params: decodeRawParams(raw),
+	}
+	if !validMediaType(m.Type) {
+		return m, fmt.Errorf("%w: %q", ErrInvalidMediaType, v)
+	}
+	return m, nil
+}
+
+// validMediaType Content-Disposition 没有 '/', 也视为合法
+func validMediaType(t string) bool {
+	if t == "" {
+		return false
+	}
+	for _, part := range strings.SplitN(t, "/", 2) {
+		if part == "" || strings.ContainsAny(part, "()<>@,;:\\\"/[]?= ") {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeRawParams 合并 RFC 2231 分段参数, 其它参数原样保留
+func decodeRawParams(raw map[string]string) map[string]string {
+	params := make(map[string]string, len(raw))
+	for k, v := range raw {
+		star := strings.IndexByte(k, '*')
+		if star < 0 {
+			if _, ok := params[k]; !ok {
+				params[k] = v
+			}
+			continue
+		}
+		base := k[:star]
+		params[base] = decodeRFC2231(raw, base)
+	}
+	return params
+}
+
+// GetMediaType 解析邮件头的 Content-Type, 缺省为 text/plain
+func GetMediaType(header message.Header) MediaType {
+	m, err := ParseMediaType(header.Get("Content-Type"))
+	if err != nil || m.Type == "" {
+		m.Type = "text/plain"
+	}
+	return m
+}
@@ -0,0 +1,147 @@
+package tools
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// splitRawParams 宽松地拆分头部参数, 返回原始的 key(小写)/value(去掉引号).
+// 不做 RFC 2231 解码, 用于 mime.ParseMediaType 处理不了的情况
+func splitRawParams(s string) (value string, params map[string]string) {
+	params = make(map[string]string)
+	fields := splitTopLevelQuoted(s, ';')
+	value = strings.TrimSpace(fields[0])
+	lastKey := ""
+	for _, f := range fields[1:] {
+		f = strings.TrimSpace(f)
+		eq := strings.IndexByte(f, '=')
+		if eq <= 0 {
+			// 未加引号的值里带了分号, 如 name=简历;2020.doc
+			if lastKey != "" && f != "" {
+				params[lastKey] += ";" + f
+			}
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(f[:eq]))
+		val := unquoteParam(strings.TrimSpace(f[eq+1:]))
+		// 重复参数以第一个为准
+		if _, ok := params[key]; ok {
+			lastKey = ""
+			continue
+		}
+		params[key] = val
+		lastKey = key
+	}
+	return value, params
+}
+
+// splitTopLevelQuoted 按不在双引号中的 sep 拆分
+func splitTopLevelQuoted(s string, sep byte) (parts []string) {
+	start, inQuote := 0, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && inQuote:
+			i++
+		case c == '"':
+			inQuote = !inQuote
+		case c == sep && !inQuote:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unquoteParam(v string) string {
+	if len(v) < 2 || v[0] != '"' || v[len(v)-1] != '"' {
+		return strings.Trim(v, `"`)
+	}
+	v = v[1 : len(v)-1]
+	if !strings.Contains(v, `\`) {
+		return v
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] == '\\' && i+1 < len(v) {
+			i++
+		}
+		b.WriteByte(v[i])
+	}
+	return b.String()
+}
+
+// decodeRFC2231 从原始参数中取出 name 的值, 处理 RFC 2231 的 name*、
+// name*0*、name*1 等形式以及任意字符集, 最后再做 RFC 2047 解码
+func decodeRFC2231(raw map[string]string, name string) string {
+	if v, ok := raw[name+"*"]; ok {
+		return decodeRFC2047(decodeExtValue(v))
+	}
+	type section struct {
+		n       int
+		encoded bool
+		value   string
+	}
+	var sections []section
+	prefix := name + "*"
+	for k, v := range raw {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		idx := k[len(prefix):]
+		encoded := strings.HasSuffix(idx, "*")
+		n, err := strconv.Atoi(strings.TrimSuffix(idx, "*"))
+		if err != nil {
+			continue
+		}
+		sections = append(sections, section{n, encoded, v})
+	}
+	if len(sections) == 0 {
+		return decodeRFC2047(raw[name])
+	}
+	sort.Slice(sections, func(i, j int) bool { return sections[i].n < sections[j].n })
+
+	charset := ""
+	var buf []byte
+	for i, s := range sections {
+		v := s.value
+		if s.encoded && i == 0 {
+			// 只有第一段带 charset'lang'
+			if parts := strings.SplitN(v, "'", 3); len(parts) == 3 {
+				charset, v = parts[0], parts[2]
+			}
+		}
+		if s.encoded {
+			if dec, err := url.PathUnescape(v); err == nil {
+				v = dec
+			}
+		}
+		buf = append(buf, v...)
+	}
+	return decodeRFC2047(string(decodeCharset(normalizeCharset(charset), buf)))
+}
+
+// decodeExtValue 解码 charset'lang'%XX 形式的扩展参数值
+func decodeExtValue(v string) string {
+	charset := ""
+	if parts := strings.SplitN(v, "'", 3); len(parts) == 3 {
+		charset, v = parts[0], parts[2]
+	}
+	dec, err := url.PathUnescape(v)
+	if err != nil {
+		return v
+	}
+	return string(decodeCharset(normalizeCharset(charset), []byte(dec)))
+}
+
+// decodeRFC2047 解码 =?charset?B?...?= 形式的编码字, 失败时原样返回
+func decodeRFC2047(s string) string {
+	if !strings.Contains(s, "=?") {
+		return s
+	}
+	if dec, err := DecHeader().DecodeHeader(s); err == nil {
+		return dec
+	}
+	return s
+}
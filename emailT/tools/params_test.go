@@ -0,0 +1,84 @@
+package tools
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/emersion/go-message"
+)
+
+func TestParseMediaTypeParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		typ   string
+		param string
+		want  string
+	}{
+		{"simple", `text/plain; charset="UTF-8"`, "text/plain", "charset", "UTF-8"},
+		{"upper case", `Multipart/Mixed; BOUNDARY=abc`, "multipart/mixed", "boundary", "abc"},
+		{"rfc 2231 utf-8", `attachment; filename*=UTF-8''%E5%BC%A0%E7%90%B3%E7%9A%84%E7%AE%80%E5%8E%86.doc`,
+			"attachment", "filename", "张琳的简历.doc"},
+		// mime 包不支持 utf-8 以外的字符集
+		{"rfc 2231 gbk", `attachment; filename*=gbk'zh-cn'%D5%C5%C1%D5%B5%C4%BC%F2%C0%FA.doc`,
+			"attachment", "filename", "张琳的简历.doc"},
+		{"continuations", "attachment;\r\n filename*0*=UTF-8''%E5%BC%A0%E7%90%B3;\r\n filename*1*=%E7%9A%84%E7%AE%80%E5%8E%86;\r\n filename*2=.doc",
+			"attachment", "filename", "张琳的简历.doc"},
+		{"gbk continuations out of order", `attachment; filename*1*=%BC%F2%C0%FA.doc; filename*0*=gb2312''%D5%C5%C1%D5%B5%C4`,
+			"attachment", "filename", "张琳的简历.doc"},
+		{"plain continuations", `application/octet-stream; name*0="resume "; name*1="2020.pdf"`,
+			"application/octet-stream", "name", "resume 2020.pdf"},
+		// 分段里放的是 RFC 2047 编码字
+		{"rfc 2047 in continuations", `attachment; filename*0="=?UTF-8?B?5byg55Cz?="; filename*1=".doc"`,
+			"attachment", "filename", "张琳.doc"},
+		{"unquoted semicolon", `application/msword; name=简历;2020.doc`, "application/msword", "name", "简历;2020.doc"},
+		{"duplicate", `text/plain; charset=gbk; charset=utf-8`, "text/plain", "charset", "gbk"},
+		{"spaces", `text / html ; charset = "gb2312"`, "text/html", "charset", "gb2312"},
+		{"escaped quote", `attachment; filename="a \"b\".txt"`, "attachment", "filename", `a "b".txt`},
+	}
+	for _, tt := range tests {
+		m, err := ParseMediaType(tt.value)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if m.Type != tt.typ || m.Params[tt.param] != tt.want {
+			t.Errorf("%s: got %s %s=%q, want %s %q", tt.name, m.Type, tt.param, m.Params[tt.param], tt.typ, tt.want)
+		}
+	}
+
+	m, err := ParseMediaType(`; name="a.txt"`)
+	if !errors.Is(err, ErrInvalidMediaType) || m.Params["name"] != "a.txt" {
+		t.Errorf("missing type: %+v, %v; want ErrInvalidMediaType with params", m, err)
+	}
+}
+
+func TestDecodeFilename(t *testing.T) {
+	const bossName = "=?UTF-8?B?44CQ5Lq65Yqb6LWE5rqQ77yI5qyi6L+OMjDlsYrvvIkgfCDmna3lt54=?= =?UTF-8?B?Ni0xMEvjgJHmnY7mtannhLYg5LiA5bm05Lul5YaFLmRvYw==?="
+	tests := []struct {
+		name        string
+		disposition string
+		contentType string
+		want        string
+	}{
+		{"boss rfc 2047", `attachment; filename="` + bossName + `"`, "application/octet-stream",
+			"【人力资源（欢迎20届） | 杭州6-10K】李浩然 一年以内.doc"},
+		{"name fallback", "", `application/msword; name="=?gb2312?B?1cXB1bXEvPLA+i5kb2M=?="`, "张琳的简历.doc"},
+		{"filename first", `attachment; filename="a.doc"`, `application/msword; name="b.doc"`, "a.doc"},
+		{"rfc 2231", "attachment; filename*0*=UTF-8''%E7%AE%80; filename*1*=%E5%8E%86.doc", "", "简历.doc"},
+		{"raw gbk", "attachment; filename=\"\xd5\xc5\xc1\xd5\xb5\xc4\xbc\xf2\xc0\xfa\xa3\xa8\xc8\xcb\xc1\xa6\xd7\xca\xd4\xb4\xa3\xa9.doc\"", "",
+			"张琳的简历（人力资源）.doc"},
+	}
+	for _, tt := range tests {
+		var h message.Header
+		if tt.disposition != "" {
+			h.Set("Content-Disposition", tt.disposition)
+		}
+		if tt.contentType != "" {
+			h.Set("Content-Type", tt.contentType)
+		}
+		if got := DecodeFilename(h); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
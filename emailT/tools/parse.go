@@ -0,0 +1,394 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+	"go.mozilla.org/pkcs7"
+)
+
+// maxPartDepth multipart 最大嵌套层数, 防止畸形邮件无限递归
+const maxPartDepth = 32
+
+// ErrPartTooDeep multipart 嵌套层数超过 maxPartDepth
+var ErrPartTooDeep = errors.New("multipart nested too deep")
+
+// Part 邮件树中的一个叶子节点
+type Part struct {
+	// Path 与 IMAP BODY[section] 一致的节点编号, 如 "1.2"
+	Path             string
+	Header           message.Header
+	ContentType      string
+	Params           map[string]string
+	Charset          string
+	TransferEncoding string
+	Disposition      string
+	Filename         string
+	ContentID        string
+	// Body 解码后的内容, text/* 已转为 utf-8; 只有 ParseMessage 会填充
+	Body []byte
+	// SHA256 内容没有读入内存 (如附件直接落盘) 时内容的 sha256, 十六进制
+	SHA256 string
+	// Security 节点位于签名或加密内容中时的验证结果, 否则为空
+	Security *SecurityStatus
+}
+
+// IsText 是否为 text/plain 或 text/html
+func (p *Part) IsText() bool {
+	return p.ContentType == "text/plain" || p.ContentType == "text/html"
+}
+
+// IsAttachment 是否为附件
+func (p *Part) IsAttachment() bool {
+	if p.Disposition == "attachment" {
+		return true
+	}
+	if p.Disposition == "inline" {
+		return false
+	}
+	// 没有 Content-Disposition 时, 带文件名的非正文节点也按附件处理
+	return p.Filename != "" && !p.IsText()
+}
+
+// ParsedMessage 解析后的邮件
+type ParsedMessage struct {
+	Header      message.Header
+	Text        string
+	HTML        string
+	Inlines     []*Part
+	Attachments []*Part
+	// Parts 按出现顺序排列的全部叶子节点
+	Parts []*Part
+	// Security 整封邮件签名验证和解密的结果, 只有根节点是签名或加密的内容时才有;
+	// 签名或加密的附件等嵌套节点的结果见 Part.Security
+	Security *SecurityStatus
+}
+
+// PartFunc 遍历邮件时对每个叶子节点的回调, body 为解码传输编码后的内容,
+// 只在回调期间有效
+type PartFunc func(p *Part, body io.Reader) error
+
+// WalkMessage 递归遍历邮件的 MIME 树, 对每个叶子节点调用 fn.
+// 签名的邮件只遍历被签名的内容, 不验证签名; 需要验证或解密时使用 Security.WalkMessage
+func WalkMessage(r io.Reader, fn PartFunc) (header message.Header, err error) {
+	header, _, err = walkMessage(r, nil, fn)
+	return header, err
+}
+
+func walkMessage(r io.Reader, sec *Security, fn PartFunc) (header message.Header, status *SecurityStatus, err error) {
+	br := bufio.NewReader(r)
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		return header, nil, err
+	}
+	header = message.Header{Header: h}
+	w := &walker{sec: sec, fn: fn, whole: true}
+	err = w.walkEntity(header, br, "", 0)
+	return header, w.status, err
+}
+
+// walker 遍历 MIME 树, 遇到 multipart/signed、multipart/encrypted 和
+// application/pkcs7-mime 时验证签名、解密后继续遍历其中的内容
+type walker struct {
+	sec *Security
+	fn  PartFunc
+	// status 整封邮件的验证结果, cur 为当前所在的签名或加密内容的结果
+	status *SecurityStatus
+	cur    *SecurityStatus
+	// whole 下一个实体是整封邮件, 或者是签名、加密内容的全部
+	whole bool
+}
+
+func (w *walker) walkEntity(header message.Header, body io.Reader, path string, depth int) error {
+	if depth > maxPartDepth {
+		return ErrPartTooDeep
+	}
+	whole := w.whole
+	w.whole = false
+	mt := GetMediaType(header)
+	switch {
+	case mt.Type == "multipart/signed" && mt.Params["boundary"] != "":
+		return w.walkSigned(mt, body, path, depth, whole)
+	case mt.Type == "multipart/encrypted" && mt.Params["boundary"] != "":
+		return w.walkEncrypted(mt, body, path, depth, whole)
+	case mt.Type == "application/pkcs7-mime" || mt.Type == "application/x-pkcs7-mime":
+		return w.walkPKCS7(header, mt, body, path, depth, whole)
+	case mt.IsMultipart() && mt.Params["boundary"] != "":
+		return w.walkMultipart(mt.Params["boundary"], body, path, depth)
+	}
+	return w.leaf(header, mt, body, path)
+}
+
+func (w *walker) walkMultipart(boundary string, body io.Reader, path string, depth int) error {
+	mr := textproto.NewMultipartReader(body, boundary)
+	for i := 1; ; i++ {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("part %s: %v", childPath(path, i), err)
+		}
+		if err = w.walkEntity(message.Header{Header: p.Header}, p, childPath(path, i), depth+1); err != nil {
+			return err
+		}
+	}
+}
+
+func (w *walker) leaf(header message.Header, mt MediaType, body io.Reader, path string) error {
+	if path == "" {
+		path = "1"
+	}
+	part := newPart(header, path, mt)
+	part.Security = w.cur
+	// 未知的传输编码按原样交给回调, 由调用方根据 TransferEncoding 自行处理
+	decoded, _ := DecodeTransfer(part.TransferEncoding, body)
+	return w.fn(part, decoded)
+}
+
+// enter 进入签名或加密的内容. whole 为 true 时这一层包含外层的全部内容,
+// 结果与外层合并; 根实体的结果作为整封邮件的结果. 嵌套在其它节点中的签名或加密
+// 只影响其中节点的 Part.Security, 不能让整封邮件显示为已签名
+func (w *walker) enter(protocol string, whole bool) *SecurityStatus {
+	if whole && w.cur != nil {
+		return w.cur
+	}
+	status := &SecurityStatus{Protocol: protocol}
+	if whole {
+		w.status = status
+	}
+	return status
+}
+
+// walkInner 遍历签名或加密内容中的邮件实体
+func (w *walker) walkInner(status *SecurityStatus, data []byte, path string, depth int) error {
+	br := bufio.NewReader(bytes.NewReader(data))
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		return fmt.Errorf("part %s: %v", path, err)
+	}
+	return w.within(status, func() error {
+		w.whole = true
+		return w.walkEntity(message.Header{Header: h}, br, path, depth+1)
+	})
+}
+
+// within 执行 fn 期间交给回调的节点都带上 status
+func (w *walker) within(status *SecurityStatus, fn func() error) error {
+	outer := w.cur
+	w.cur = status
+	defer func() { w.cur = outer }()
+	return fn()
+}
+
+// walkSigned 处理 multipart/signed: 第一部分为签名的内容, 第二部分为签名.
+// 验证要用原始字节, 所以不能用 MultipartReader
+func (w *walker) walkSigned(mt MediaType, body io.Reader, path string, depth int, whole bool) error {
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	protocol := signatureProtocol(mt.Params["protocol"])
+	status := w.enter(protocol, whole)
+	status.Signed = true
+	parts := splitMultipart(raw, mt.Params["boundary"])
+	if len(parts) == 0 {
+		return fmt.Errorf("part %s: multipart/signed without content", path)
+	}
+	if len(parts) < 2 {
+		status.setErr(errors.New("signature part missing"))
+	} else if sig, err := signatureBody(parts[1]); err != nil {
+		status.setErr(err)
+	} else {
+		content := canonicalCRLF(parts[0])
+		switch protocol {
+		case ProtocolSMIME:
+			w.sec.verifySMIME(status, content, sig)
+		case ProtocolPGP:
+			w.sec.verifyPGP(status, content, sig)
+		default:
+			status.setErr(fmt.Errorf("%w: %q", ErrUnknownProtocol, mt.Params["protocol"]))
+		}
+	}
+	// 签名不作为附件, 只遍历签名的内容
+	return w.walkInner(status, parts[0], childPath(path, 1), depth)
+}
+
+// signatureBody 取出签名节点解码后的内容
+func signatureBody(raw []byte) ([]byte, error) {
+	br := bufio.NewReader(bytes.NewReader(raw))
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		return nil, fmt.Errorf("signature part: %v", err)
+	}
+	dec, err := DecodeTransfer(normalizeEncoding(h.Get("Content-Transfer-Encoding")), br)
+	if err != nil {
+		return nil, fmt.Errorf("signature part: %v", err)
+	}
+	return ioutil.ReadAll(dec)
+}
+
+// walkEncrypted 处理 PGP/MIME 的 multipart/encrypted: 第一部分为版本信息,
+// 第二部分为加密的内容. 无法解密时把各部分按普通节点交给回调
+func (w *walker) walkEncrypted(mt MediaType, body io.Reader, path string, depth int, whole bool) error {
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	status := w.enter(ProtocolPGP, whole)
+	status.Encrypted = true
+	parts := splitMultipart(raw, mt.Params["boundary"])
+	var data []byte
+	switch {
+	case !strings.EqualFold(mt.Params["protocol"], "application/pgp-encrypted"):
+		err = fmt.Errorf("%w: %q", ErrUnknownProtocol, mt.Params["protocol"])
+	case len(parts) < 2:
+		err = errors.New("encrypted part missing")
+	default:
+		if data, err = signatureBody(parts[1]); err == nil {
+			data, err = w.sec.decryptPGP(status, data)
+		}
+	}
+	if err != nil {
+		status.setErr(err)
+		return w.within(status, func() error {
+			return w.walkMultipart(mt.Params["boundary"], bytes.NewReader(raw), path, depth)
+		})
+	}
+	status.Decrypted = true
+	return w.walkInner(status, data, path, depth)
+}
+
+// walkPKCS7 处理 S/MIME 的 application/pkcs7-mime, 包括加密的 enveloped-data
+// 和内容与签名在一起的 signed-data. 无法处理时按普通附件交给回调
+func (w *walker) walkPKCS7(header message.Header, mt MediaType, body io.Reader, path string, depth int, whole bool) error {
+	dec, _ := DecodeTransfer(normalizeEncoding(header.Get("Content-Transfer-Encoding")), body)
+	der, err := ioutil.ReadAll(dec)
+	if err != nil {
+		return err
+	}
+	status := w.enter(ProtocolSMIME, whole)
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		status.setErr(fmt.Errorf("smime: %v", err))
+		return w.pkcs7Leaf(status, header, mt, der, path)
+	}
+	if strings.EqualFold(mt.Params["smime-type"], "signed-data") || len(p7.Signers) > 0 {
+		status.Signed = true
+		w.sec.checkSMIME(status, p7)
+		return w.walkInner(status, p7.Content, path, depth)
+	}
+	status.Encrypted = true
+	data, err := w.sec.decryptSMIME(p7)
+	if err != nil {
+		status.setErr(err)
+		return w.pkcs7Leaf(status, header, mt, der, path)
+	}
+	status.Decrypted = true
+	return w.walkInner(status, data, path, depth)
+}
+
+// pkcs7Leaf 把无法处理的 pkcs7 内容作为附件交给回调, 内容已解码传输编码
+func (w *walker) pkcs7Leaf(status *SecurityStatus, header message.Header, mt MediaType, der []byte, path string) error {
+	header = header.Copy()
+	header.Del("Content-Transfer-Encoding")
+	return w.within(status, func() error {
+		return w.leaf(header, mt, bytes.NewReader(der), path)
+	})
+}
+
+func childPath(path string, i int) string {
+	if path == "" {
+		return strconv.Itoa(i)
+	}
+	return path + "." + strconv.Itoa(i)
+}
+
+// NewPart 根据节点的 MIME 头创建叶子节点, path 为 IMAP 的节点编号
+func NewPart(header message.Header, path string) *Part {
+	return newPart(header, path, GetMediaType(header))
+}
+
+func newPart(header message.Header, path string, mt MediaType) *Part {
+	p := &Part{
+		Path:             path,
+		Header:           header,
+		ContentType:      mt.Type,
+		Params:           mt.Params,
+		Charset:          normalizeCharset(mt.Params["charset"]),
+		TransferEncoding: normalizeEncoding(header.Get("Content-Transfer-Encoding")),
+		ContentID:        strings.Trim(header.Get("Content-Id"), "<> "),
+	}
+	disp, _ := ParseMediaType(header.Get("Content-Disposition"))
+	p.Disposition = disp.Type
+	p.Filename = DecodeFilename(header)
+	return p
+}
+
+// ParseMessage 解析整封邮件, 正文按 charset 转为 utf-8.
+// 不验证签名也不解密, 需要时使用 Security.ParseMessage
+func ParseMessage(r io.Reader) (msg *ParsedMessage, err error) {
+	return parseMessage(r, nil)
+}
+
+func parseMessage(r io.Reader, sec *Security) (msg *ParsedMessage, err error) {
+	msg = new(ParsedMessage)
+	msg.Header, msg.Security, err = walkMessage(r, sec, func(p *Part, body io.Reader) error {
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return fmt.Errorf("part %s: %v", p.Path, err)
+		}
+		p.SetBody(data)
+		msg.AddPart(p)
+		return nil
+	})
+	return msg, err
+}
+
+// SetBody 设置解码传输编码后的内容, text/* 按 charset 转为 utf-8
+func (p *Part) SetBody(data []byte) {
+	if strings.HasPrefix(p.ContentType, "text/") {
+		p.Charset, _ = DetectCharsetDeclared(p.Charset, data)
+		data = decodeCharset(p.Charset, data)
+	}
+	p.Body = data
+}
+
+// AddPart 按节点类型加入正文、内嵌资源或附件
+func (msg *ParsedMessage) AddPart(p *Part) {
+	msg.Parts = append(msg.Parts, p)
+	switch {
+	case p.IsAttachment():
+		msg.Attachments = append(msg.Attachments, p)
+	case p.ContentType == "text/plain" && msg.Text == "":
+		msg.Text = string(p.Body)
+	case p.ContentType == "text/html" && msg.HTML == "":
+		msg.HTML = string(p.Body)
+	default:
+		msg.Inlines = append(msg.Inlines, p)
+	}
+}
+
+// decodeCharset 将正文从 charset 转为 utf-8, charset 为空时自动检测,
+// 无法识别的原样返回
+func decodeCharset(charset string, data []byte) []byte {
+	if charset == "" {
+		charset, _ = DetectCharset(data)
+	}
+	switch charset {
+	case "", "utf-8", "us-ascii":
+		return data
+	}
+	if dec, err := DefaultCharsets.Decode(charset, data); err == nil {
+		return dec
+	}
+	return data
+}
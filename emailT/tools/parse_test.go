@@ -0,0 +1,114 @@
+package tools
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseBossMessage(t *testing.T) {
+	m := parseBoss(t)
+	if got := GetSubject(m.Header); got != "【人力资源（欢迎20届） | 杭州 6-10K】李浩然 一年以内" {
+		t.Errorf("subject %q", got)
+	}
+
+	parts := []struct {
+		path, contentType, encoding, filename string
+	}{
+		{"1.1", "text/html", "quoted-printable", ""},
+		{"2", "application/octet-stream", "base64", "【人力资源（欢迎20届） | 杭州6-10K】李浩然 一年以内.doc"},
+	}
+	if len(m.Parts) != len(parts) {
+		t.Fatalf("%d parts, want %d", len(m.Parts), len(parts))
+	}
+	for i, want := range parts {
+		p := m.Parts[i]
+		if p.Path != want.path || p.ContentType != want.contentType || p.TransferEncoding != want.encoding || p.Filename != want.filename {
+			t.Errorf("part %d: %s %s %s %q, want %+v", i, p.Path, p.ContentType, p.TransferEncoding, p.Filename, want)
+		}
+	}
+
+	// quoted-printable 的软换行在多字节字符中间
+	for _, s := range []string{"李浩然", "杭州 6-10K", "<span>6-7K</span>", "uuid=dc9f268c7916e16e03"} {
+		if !strings.Contains(m.HTML, s) {
+			t.Errorf("html does not contain %q", s)
+		}
+	}
+	if len(m.Attachments) != 1 || len(m.Inlines) != 0 {
+		t.Fatalf("%d attachments %d inlines, want the resume only", len(m.Attachments), len(m.Inlines))
+	}
+	// .doc 是 OLE 复合文档
+	if doc := m.Attachments[0].Body; !bytes.HasPrefix(doc, []byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1}) {
+		t.Errorf("attachment starts with % x, want an OLE header", doc[:8])
+	}
+}
+
+func TestParseMessageStructure(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		// parts 叶子节点的编号和类型
+		parts string
+		text  string
+	}{
+		{
+			name:  "single part",
+			msg:   "Content-Type: text/plain; charset=utf-8\r\n\r\nhello\r\n",
+			parts: "[1 text/plain]",
+			text:  "hello\r\n",
+		},
+		{
+			name:  "no content type",
+			msg:   "Subject: x\r\n\r\nhello",
+			parts: "[1 text/plain]",
+			text:  "hello",
+		},
+		{
+			name: "nested",
+			msg: "Content-Type: multipart/mixed; boundary=outer\r\n\r\n" +
+				"--outer\r\nContent-Type: multipart/alternative; boundary=inner\r\n\r\n" +
+				"--inner\r\nContent-Type: text/plain\r\n\r\nplain\r\n" +
+				"--inner\r\nContent-Type: text/html\r\n\r\n<b>html</b>\r\n" +
+				"--inner--\r\n" +
+				"--outer\r\nContent-Type: image/png; name=a.png\r\nContent-Disposition: inline\r\n\r\npng\r\n" +
+				"--outer--\r\n",
+			parts: "[1.1 text/plain 1.2 text/html 2 image/png]",
+			text:  "plain",
+		},
+		{
+			// 没有 boundary 的 multipart 按普通节点处理
+			name:  "multipart without boundary",
+			msg:   "Content-Type: multipart/mixed\r\n\r\nbody",
+			parts: "[1 multipart/mixed]",
+		},
+	}
+	for _, tt := range tests {
+		m, err := ParseMessage(strings.NewReader(tt.msg))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var parts []string
+		for _, p := range m.Parts {
+			parts = append(parts, p.Path, p.ContentType)
+		}
+		if got := fmt.Sprint(parts); got != tt.parts {
+			t.Errorf("%s: parts %s, want %s", tt.name, got, tt.parts)
+		}
+		if m.Text != tt.text {
+			t.Errorf("%s: text %q, want %q", tt.name, m.Text, tt.text)
+		}
+	}
+}
+
+func TestParseTooDeep(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i <= maxPartDepth+1; i++ {
+		fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=b%d\r\n\r\n--b%d\r\n", i, i)
+	}
+	b.WriteString("Content-Type: text/plain\r\n\r\ndeep\r\n")
+	if _, err := ParseMessage(strings.NewReader(b.String())); err != ErrPartTooDeep {
+		t.Errorf("err = %v, want ErrPartTooDeep", err)
+	}
+}
@@ -0,0 +1,341 @@
+package tools
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"go.mozilla.org/pkcs7"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// 签名/加密协议
+const (
+	ProtocolSMIME = "smime"
+	ProtocolPGP   = "pgp"
+)
+
+var (
+	// ErrNoTrustStore 没有配置用于验证签名的信任库
+	ErrNoTrustStore = errors.New("no trust store configured")
+	// ErrNoDecryptionKey 没有配置用于解密的本地密钥
+	ErrNoDecryptionKey = errors.New("no decryption key configured")
+	// ErrUnknownProtocol multipart/signed 或 multipart/encrypted 的 protocol 无法识别
+	ErrUnknownProtocol = errors.New("unknown security protocol")
+)
+
+// SecurityStatus 签名验证和解密的结果. 先签名后加密的邮件两层结果合并在一起
+type SecurityStatus struct {
+	// Protocol ProtocolSMIME 或 ProtocolPGP
+	Protocol string
+	Signed   bool
+	// Verified 签名有效, 并且签名者在信任库中
+	Verified bool
+	// Signer 签名者, S/MIME 为证书主题, PGP 为密钥的身份
+	Signer      string
+	SignerEmail string
+	Encrypted   bool
+	Decrypted   bool
+	// Err 验证或解密失败的原因
+	Err error
+}
+
+func (s *SecurityStatus) setErr(err error) {
+	if s.Err == nil {
+		s.Err = err
+	}
+}
+
+// Security 验证签名使用的信任库和解密使用的本地密钥
+type Security struct {
+	// Roots 受信任的 S/MIME 根证书, 为空时使用系统根证书
+	Roots *x509.CertPool
+	// Certificate、PrivateKey 本地的 S/MIME 证书和私钥, 用于解密
+	Certificate *x509.Certificate
+	PrivateKey  crypto.PrivateKey
+	// PGPKeyring 受信任的 PGP 公钥, 以及用于解密的私钥
+	PGPKeyring openpgp.EntityList
+	// PGPPassphrase 私钥的口令, 私钥没有加密时为空
+	PGPPassphrase []byte
+	// Time 校验证书有效期的时间, 为零时取当前时间
+	Time time.Time
+}
+
+// LoadCertPool 从 PEM 文件加载受信任的根证书
+func LoadCertPool(paths ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", path)
+		}
+	}
+	return pool, nil
+}
+
+// LoadKeyPair 从 PEM 文件加载本地的 S/MIME 证书和私钥
+func (s *Security) LoadKeyPair(certFile, keyFile string) error {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return err
+	}
+	s.Certificate, s.PrivateKey = cert, pair.PrivateKey
+	return nil
+}
+
+// LoadPGPKeyring 加载 PGP 公钥或私钥文件, 支持 ASCII armor 和二进制格式
+func (s *Security) LoadPGPKeyring(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var keys openpgp.EntityList
+	if bytes.Contains(data, []byte("-----BEGIN PGP")) {
+		keys, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keys, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	s.PGPKeyring = append(s.PGPKeyring, keys...)
+	return nil
+}
+
+// ParseMessage 解析邮件, 验证签名并解密, 结果见 ParsedMessage.Security
+func (s *Security) ParseMessage(r io.Reader) (*ParsedMessage, error) {
+	return parseMessage(r, s)
+}
+
+// WalkMessage 与 WalkMessage 相同, 但会验证签名并解密
+func (s *Security) WalkMessage(r io.Reader, fn PartFunc) (message.Header, error) {
+	header, _, err := walkMessage(r, s, fn)
+	return header, err
+}
+
+// signatureProtocol multipart/signed 的 protocol 参数对应的协议
+func signatureProtocol(protocol string) string {
+	switch strings.ToLower(protocol) {
+	case "application/pkcs7-signature", "application/x-pkcs7-signature":
+		return ProtocolSMIME
+	case "application/pgp-signature":
+		return ProtocolPGP
+	}
+	return ""
+}
+
+// verifySMIME 验证 S/MIME 分离签名, content 为已转为 CRLF 的签名内容
+func (s *Security) verifySMIME(status *SecurityStatus, content, sig []byte) {
+	p7, err := pkcs7.Parse(sig)
+	if err != nil {
+		status.setErr(fmt.Errorf("smime: %v", err))
+		return
+	}
+	p7.Content = content
+	s.checkSMIME(status, p7)
+}
+
+// checkSMIME 验证 PKCS#7 签名, 并校验签名者证书链
+func (s *Security) checkSMIME(status *SecurityStatus, p7 *pkcs7.PKCS7) {
+	if signer := p7.GetOnlySigner(); signer != nil {
+		status.Signer = signer.Subject.String()
+		if len(signer.EmailAddresses) > 0 {
+			status.SignerEmail = signer.EmailAddresses[0]
+		}
+	}
+	if s == nil {
+		status.setErr(ErrNoTrustStore)
+		return
+	}
+	roots := s.Roots
+	if roots == nil {
+		var err error
+		if roots, err = x509.SystemCertPool(); err != nil {
+			status.setErr(fmt.Errorf("smime: %v", err))
+			return
+		}
+	}
+	if err := p7.VerifyWithChainAtTime(roots, s.Time); err != nil {
+		status.setErr(fmt.Errorf("smime: %v", err))
+		return
+	}
+	status.Verified = true
+}
+
+// verifyPGP 验证 PGP/MIME 分离签名
+func (s *Security) verifyPGP(status *SecurityStatus, content, sig []byte) {
+	if s == nil || len(s.PGPKeyring) == 0 {
+		status.setErr(ErrNoTrustStore)
+		return
+	}
+	check := openpgp.CheckDetachedSignature
+	if bytes.Contains(sig, []byte("-----BEGIN PGP")) {
+		check = openpgp.CheckArmoredDetachedSignature
+	}
+	signer, err := check(s.PGPKeyring, bytes.NewReader(content), bytes.NewReader(sig))
+	if err != nil {
+		status.setErr(fmt.Errorf("pgp: %v", err))
+		return
+	}
+	setPGPSigner(status, signer)
+	status.Verified = true
+}
+
+func setPGPSigner(status *SecurityStatus, signer *openpgp.Entity) {
+	for _, id := range signer.Identities {
+		status.Signer = id.Name
+		if id.UserId != nil {
+			if id.UserId.Name != "" {
+				status.Signer = id.UserId.Name
+			}
+			status.SignerEmail = id.UserId.Email
+		}
+		return
+	}
+	status.Signer = signer.PrimaryKey.KeyIdString()
+}
+
+// decryptSMIME 解密 application/pkcs7-mime 的 enveloped-data
+func (s *Security) decryptSMIME(p7 *pkcs7.PKCS7) ([]byte, error) {
+	if s == nil || s.Certificate == nil || s.PrivateKey == nil {
+		return nil, ErrNoDecryptionKey
+	}
+	data, err := p7.Decrypt(s.Certificate, s.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("smime: %v", err)
+	}
+	return data, nil
+}
+
+// decryptPGP 解密 PGP/MIME 加密内容, 同时验证加密内容中的签名
+func (s *Security) decryptPGP(status *SecurityStatus, data []byte) ([]byte, error) {
+	if s == nil || len(s.PGPKeyring.DecryptionKeys()) == 0 {
+		return nil, ErrNoDecryptionKey
+	}
+	r := bytes.NewReader(data)
+	if bytes.Contains(data, []byte("-----BEGIN PGP")) {
+		block, err := armor.Decode(r)
+		if err != nil {
+			return nil, fmt.Errorf("pgp: %v", err)
+		}
+		md, err := openpgp.ReadMessage(block.Body, s.PGPKeyring, s.promptFunc(), nil)
+		return s.readPGP(status, md, err)
+	}
+	md, err := openpgp.ReadMessage(r, s.PGPKeyring, s.promptFunc(), nil)
+	return s.readPGP(status, md, err)
+}
+
+func (s *Security) readPGP(status *SecurityStatus, md *openpgp.MessageDetails, err error) ([]byte, error) {
+	if err != nil {
+		return nil, fmt.Errorf("pgp: %v", err)
+	}
+	// 签名要读完内容后才能验证
+	data, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, fmt.Errorf("pgp: %v", err)
+	}
+	if md.IsSigned {
+		status.Signed = true
+		switch {
+		case md.SignedBy == nil:
+			status.setErr(fmt.Errorf("pgp: signed by unknown key %X", md.SignedByKeyId))
+		case md.SignatureError != nil:
+			status.setErr(fmt.Errorf("pgp: %v", md.SignatureError))
+		default:
+			setPGPSigner(status, md.SignedBy.Entity)
+			status.Verified = true
+		}
+	}
+	return data, nil
+}
+
+// promptFunc 用 PGPPassphrase 解开加密的私钥. 口令错误时 openpgp 会反复调用,
+// 所以只尝试一次
+func (s *Security) promptFunc() openpgp.PromptFunction {
+	tried := false
+	return func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if tried || symmetric || len(s.PGPPassphrase) == 0 {
+			return nil, ErrNoDecryptionKey
+		}
+		tried = true
+		for _, k := range keys {
+			if k.PrivateKey != nil && k.PrivateKey.Encrypted {
+				k.PrivateKey.Decrypt(s.PGPPassphrase)
+			}
+		}
+		return nil, nil
+	}
+}
+
+// splitMultipart 按分隔符切分 multipart 的原始内容, 不做任何转换,
+// 用于需要原始字节验证签名的 multipart/signed
+func splitMultipart(raw []byte, boundary string) (parts [][]byte) {
+	delim := []byte("--" + boundary)
+	var starts []int
+	for i := 0; i < len(raw); {
+		j := bytes.Index(raw[i:], delim)
+		if j < 0 {
+			break
+		}
+		j += i
+		if j == 0 || raw[j-1] == '\n' {
+			starts = append(starts, j)
+		}
+		i = j + len(delim)
+	}
+	for k := 0; k+1 < len(starts); k++ {
+		line := raw[starts[k]:]
+		if bytes.HasPrefix(line[len(delim):], []byte("--")) {
+			break
+		}
+		nl := bytes.IndexByte(line, '\n')
+		if nl < 0 {
+			break
+		}
+		begin, end := starts[k]+nl+1, starts[k+1]
+		// 分隔符前的换行属于分隔符
+		if end > begin && raw[end-1] == '\n' {
+			end--
+			if end > begin && raw[end-1] == '\r' {
+				end--
+			}
+		}
+		if begin > end {
+			begin = end
+		}
+		parts = append(parts, raw[begin:end])
+	}
+	return parts
+}
+
+// canonicalCRLF 把换行统一为 CRLF, 签名是对 CRLF 格式的内容计算的
+func canonicalCRLF(data []byte) []byte {
+	if !bytes.Contains(data, []byte("\n")) {
+		return data
+	}
+	var b bytes.Buffer
+	b.Grow(len(data) + len(data)/32)
+	for i, c := range data {
+		if c == '\n' && (i == 0 || data[i-1] != '\r') {
+			b.WriteByte('\r')
+		}
+		b.WriteByte(c)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,315 @@
+package tools
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mozilla.org/pkcs7"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// testPKI 自签名的根证书和用它签发的 S/MIME 证书
+type testPKI struct {
+	root *x509.CertPool
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+var (
+	pkiOnce   sync.Once
+	pki       [2]*testPKI
+	pgpOnce   sync.Once
+	pgpAlice  *openpgp.Entity
+	pgpMallet *openpgp.Entity
+)
+
+// testPKIs 两套互不信任的证书, 生成 RSA 密钥较慢, 所有测试共用
+func testPKIs(t *testing.T) (trusted, untrusted *testPKI) {
+	pkiOnce.Do(func() {
+		for i := range pki {
+			pki[i] = newTestPKI(t, "alice@example.com")
+		}
+	})
+	return pki[0], pki[1]
+}
+
+func newTestPKI(t *testing.T, email string) *testPKI {
+	t.Helper()
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		Subject:        pkix.Name{CommonName: "Alice"},
+		EmailAddresses: []string{email},
+		NotBefore:      now.Add(-time.Hour),
+		NotAfter:       now.Add(24 * time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return &testPKI{root: pool, cert: cert, key: key}
+}
+
+func testPGPKeys(t *testing.T) (alice, mallet *openpgp.Entity) {
+	pgpOnce.Do(func() {
+		var err error
+		if pgpAlice, err = openpgp.NewEntity("Alice", "", "alice@example.com", nil); err != nil {
+			t.Fatal(err)
+		}
+		if pgpMallet, err = openpgp.NewEntity("Mallet", "", "mallet@example.com", nil); err != nil {
+			t.Fatal(err)
+		}
+		// 新密钥没有声明首选的哈希算法时 openpgp 加密会选 RIPEMD160, 而它没有编译进来
+		for _, e := range []*openpgp.Entity{pgpAlice, pgpMallet} {
+			for _, id := range e.Identities {
+				id.SelfSignature.PreferredHash = []uint8{8} // SHA256
+			}
+		}
+	})
+	return pgpAlice, pgpMallet
+}
+
+// signedContent 被签名的 MIME 实体, 使用 CRLF
+const signedContent = "Content-Type: text/plain; charset=utf-8\r\n\r\nhello, signed world\r\n"
+
+const testHeader = "From: alice@example.com\r\nTo: bob@example.com\r\nSubject: test\r\nMIME-Version: 1.0\r\n"
+
+func wrapBase64(data []byte) string {
+	enc := base64.StdEncoding.EncodeToString(data)
+	var b strings.Builder
+	for len(enc) > 76 {
+		b.WriteString(enc[:76] + "\r\n")
+		enc = enc[76:]
+	}
+	b.WriteString(enc + "\r\n")
+	return b.String()
+}
+
+// multipartSigned 把 content 和签名组成 multipart/signed 实体, 不含邮件头
+func multipartSigned(protocol, content, sigPart string) string {
+	return "Content-Type: multipart/signed; protocol=\"" + protocol + "\"; boundary=\"SIG\"\r\n\r\n" +
+		"--SIG\r\n" + content + "\r\n--SIG\r\n" + sigPart + "\r\n--SIG--\r\n"
+}
+
+func smimeSignature(t *testing.T, p *testPKI, content string) string {
+	t.Helper()
+	sd, err := pkcs7.NewSignedData([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err = sd.AddSigner(p.cert, p.key, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	sd.Detach()
+	der, err := sd.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Content-Type: application/pkcs7-signature; name=smime.p7s\r\nContent-Transfer-Encoding: base64\r\n\r\n" + wrapBase64(der)
+}
+
+func pgpSignature(t *testing.T, signer *openpgp.Entity, content string) string {
+	t.Helper()
+	var sig bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&sig, signer, strings.NewReader(content), nil); err != nil {
+		t.Fatal(err)
+	}
+	return "Content-Type: application/pgp-signature; name=signature.asc\r\n\r\n" + sig.String()
+}
+
+func TestVerifySignature(t *testing.T) {
+	trusted, untrusted := testPKIs(t)
+	alice, mallet := testPGPKeys(t)
+	tampered := strings.Replace(signedContent, "hello", "HELLO", 1)
+	tests := []struct {
+		name     string
+		msg      string
+		sec      *Security
+		verified bool
+		signer   string
+	}{
+		{"smime valid", multipartSigned("application/pkcs7-signature", signedContent, smimeSignature(t, trusted, signedContent)),
+			&Security{Roots: trusted.root}, true, "alice@example.com"},
+		{"smime tampered", multipartSigned("application/pkcs7-signature", tampered, smimeSignature(t, trusted, signedContent)),
+			&Security{Roots: trusted.root}, false, "alice@example.com"},
+		{"smime untrusted", multipartSigned("application/pkcs7-signature", signedContent, smimeSignature(t, untrusted, signedContent)),
+			&Security{Roots: trusted.root}, false, "alice@example.com"},
+		{"pgp valid", multipartSigned("application/pgp-signature", signedContent, pgpSignature(t, alice, signedContent)),
+			&Security{PGPKeyring: openpgp.EntityList{alice}}, true, "alice@example.com"},
+		{"pgp tampered", multipartSigned("application/pgp-signature", tampered, pgpSignature(t, alice, signedContent)),
+			&Security{PGPKeyring: openpgp.EntityList{alice}}, false, ""},
+		{"pgp untrusted", multipartSigned("application/pgp-signature", signedContent, pgpSignature(t, mallet, signedContent)),
+			&Security{PGPKeyring: openpgp.EntityList{alice}}, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := tt.sec.ParseMessage(strings.NewReader(testHeader + tt.msg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			st := msg.Security
+			if st == nil || !st.Signed {
+				t.Fatalf("Security = %+v, want signed", st)
+			}
+			if st.Verified != tt.verified {
+				t.Errorf("Verified = %v, want %v (err %v)", st.Verified, tt.verified, st.Err)
+			}
+			if !tt.verified && st.Err == nil {
+				t.Error("Err is nil for an unverified signature")
+			}
+			if st.SignerEmail != tt.signer {
+				t.Errorf("SignerEmail = %q, want %q", st.SignerEmail, tt.signer)
+			}
+			if !strings.Contains(msg.Text, "signed world") {
+				t.Errorf("Text = %q", msg.Text)
+			}
+			if len(msg.Attachments) != 0 {
+				t.Errorf("signature returned as attachment: %d", len(msg.Attachments))
+			}
+		})
+	}
+}
+
+// 签名的节点嵌套在没有签名的邮件中时, 整封邮件不能显示为已签名
+func TestNestedSignatureNotMessageLevel(t *testing.T) {
+	trusted, _ := testPKIs(t)
+	signed := multipartSigned("application/pkcs7-signature", signedContent, smimeSignature(t, trusted, signedContent))
+	raw := testHeader + "Content-Type: multipart/mixed; boundary=\"MIX\"\r\n\r\n" +
+		"--MIX\r\nContent-Type: text/plain\r\n\r\nunsigned text\r\n" +
+		"--MIX\r\n" + signed + "\r\n--MIX--\r\n"
+	msg, err := (&Security{Roots: trusted.root}).ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Security != nil {
+		t.Errorf("message Security = %+v, want nil", msg.Security)
+	}
+	if len(msg.Parts) != 2 {
+		t.Fatalf("got %d parts, want 2", len(msg.Parts))
+	}
+	if msg.Parts[0].Security != nil {
+		t.Errorf("unsigned part Security = %+v", msg.Parts[0].Security)
+	}
+	if st := msg.Parts[1].Security; st == nil || !st.Verified {
+		t.Errorf("signed part Security = %+v, want verified", st)
+	}
+}
+
+const secretContent = "Content-Type: text/plain; charset=utf-8\r\n\r\ntop secret\r\n"
+
+func TestDecryptSMIME(t *testing.T) {
+	trusted, untrusted := testPKIs(t)
+	der, err := pkcs7.Encrypt([]byte(secretContent), []*x509.Certificate{trusted.cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := testHeader + "Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=smime.p7m\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\n" + wrapBase64(der)
+
+	msg, err := (&Security{Certificate: trusted.cert, PrivateKey: trusted.key}).ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := msg.Security; st == nil || !st.Encrypted || !st.Decrypted || st.Err != nil {
+		t.Fatalf("Security = %+v, want decrypted", st)
+	}
+	if !strings.Contains(msg.Text, "top secret") {
+		t.Errorf("Text = %q", msg.Text)
+	}
+
+	// 密钥不对时作为附件返回
+	msg, err = (&Security{Certificate: untrusted.cert, PrivateKey: untrusted.key}).ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := msg.Security; st == nil || st.Decrypted || st.Err == nil {
+		t.Errorf("Security = %+v, want decryption error", st)
+	}
+	if len(msg.Attachments) != 1 || !bytes.Equal(msg.Attachments[0].Body, der) {
+		t.Errorf("encrypted content not returned as attachment")
+	}
+}
+
+func TestDecryptPGP(t *testing.T) {
+	alice, mallet := testPGPKeys(t)
+	var enc bytes.Buffer
+	aw, err := armor.Encode(&enc, "PGP MESSAGE", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw, err := openpgp.Encrypt(aw, openpgp.EntityList{alice}, alice, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw.Write([]byte(secretContent))
+	pw.Close()
+	aw.Close()
+	raw := testHeader + "Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\"; boundary=\"ENC\"\r\n\r\n" +
+		"--ENC\r\nContent-Type: application/pgp-encrypted\r\n\r\nVersion: 1\r\n" +
+		"--ENC\r\nContent-Type: application/octet-stream; name=encrypted.asc\r\n\r\n" + enc.String() + "\r\n--ENC--\r\n"
+
+	msg, err := (&Security{PGPKeyring: openpgp.EntityList{alice}}).ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := msg.Security
+	if st == nil || !st.Encrypted || !st.Decrypted {
+		t.Fatalf("Security = %+v, want decrypted", st)
+	}
+	// 先签名后加密, 两层的结果合并
+	if !st.Signed || !st.Verified || st.SignerEmail != "alice@example.com" {
+		t.Errorf("Security = %+v, want verified signature by alice", st)
+	}
+	if !strings.Contains(msg.Text, "top secret") {
+		t.Errorf("Text = %q", msg.Text)
+	}
+
+	msg, err = (&Security{PGPKeyring: openpgp.EntityList{mallet}}).ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := msg.Security; st == nil || st.Decrypted || st.Err == nil {
+		t.Errorf("Security = %+v, want decryption error", st)
+	}
+	if strings.Contains(msg.Text, "top secret") {
+		t.Error("decrypted without the key")
+	}
+}
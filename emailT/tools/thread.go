@@ -0,0 +1,287 @@
+package tools
+
+import (
+	"net/mail"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+)
+
+var msgIDReg = regexp.MustCompile(`<([^<>\s]+)>`)
+
+// subjectPrefixReg 回复/转发前缀, 包括 Re[2]: 和中文客户端的 回复: 转发:
+var subjectPrefixReg = regexp.MustCompile(`(?i)^\s*((re|fw|fwd|aw|sv|答复|回复|转发|轉寄|回覆)(\[\d+\])?\s*[:：]\s*)+`)
+
+// ThreadMessage 参与会话归并的邮件
+type ThreadMessage struct {
+	MessageID  string
+	InReplyTo  string
+	References []string
+	Subject    string
+	Date       time.Time
+	// Data 调用方附带的数据, 如 uid
+	Data interface{}
+}
+
+// NewThreadMessage 从邮件头中取出会话归并所需的字段
+func NewThreadMessage(header message.Header) *ThreadMessage {
+	m := &ThreadMessage{
+		MessageID:  firstMsgID(GetMessageID(header)),
+		InReplyTo:  firstMsgID(header.Get("In-Reply-To")),
+		References: parseMsgIDs(header.Get("References")),
+		Subject:    GetSubject(header),
+	}
+	m.Date, _ = mail.ParseDate(header.Get("Date"))
+	return m
+}
+
+// parseMsgIDs 取出 <...> 中的 Message-ID, 没有尖括号时按空白拆分
+func parseMsgIDs(s string) (ids []string) {
+	for _, m := range msgIDReg.FindAllStringSubmatch(s, -1) {
+		ids = append(ids, m[1])
+	}
+	if len(ids) == 0 {
+		ids = strings.Fields(s)
+	}
+	return ids
+}
+
+func firstMsgID(s string) string {
+	if ids := parseMsgIDs(s); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
+// BaseSubject 去掉回复、转发前缀和 [tag] 后的主题, 用于按主题归并
+func BaseSubject(subject string) string {
+	for {
+		s := strings.TrimSpace(subjectPrefixReg.ReplaceAllString(subject, ""))
+		if strings.HasPrefix(s, "[") {
+			if i := strings.Index(s, "]"); i > 0 && i < len(s)-1 {
+				s = strings.TrimSpace(s[i+1:])
+			}
+		}
+		if s == subject {
+			return s
+		}
+		subject = s
+	}
+}
+
+// Thread 会话树的一个节点, Message 为空表示只在 References 中出现过的邮件
+type Thread struct {
+	Message  *ThreadMessage
+	Children []*Thread
+
+	id     string
+	parent *Thread
+}
+
+// Date 节点的日期, 空节点取最早的子节点
+func (t *Thread) Date() time.Time {
+	if t.Message != nil {
+		return t.Message.Date
+	}
+	var d time.Time
+	for _, c := range t.Children {
+		if cd := c.Date(); d.IsZero() || !cd.IsZero() && cd.Before(d) {
+			d = cd
+		}
+	}
+	return d
+}
+
+// Subject 节点的主题, 空节点取第一个子节点
+func (t *Thread) Subject() string {
+	if t.Message != nil {
+		return t.Message.Subject
+	}
+	if len(t.Children) > 0 {
+		return t.Children[0].Subject()
+	}
+	return ""
+}
+
+// Walk 深度优先遍历会话树中的全部邮件
+func (t *Thread) Walk(fn func(m *ThreadMessage, depth int)) {
+	t.walk(fn, 0)
+}
+
+func (t *Thread) walk(fn func(m *ThreadMessage, depth int), depth int) {
+	if t.Message != nil {
+		fn(t.Message, depth)
+		depth++
+	}
+	for _, c := range t.Children {
+		c.walk(fn, depth)
+	}
+}
+
+func (t *Thread) hasDescendant(d *Thread) bool {
+	for p := d; p != nil; p = p.parent {
+		if p == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Thread) addChild(c *Thread) {
+	if c.parent != nil {
+		c.parent.removeChild(c)
+	}
+	c.parent = t
+	t.Children = append(t.Children, c)
+}
+
+func (t *Thread) removeChild(c *Thread) {
+	for i, x := range t.Children {
+		if x == c {
+			t.Children = append(t.Children[:i], t.Children[i+1:]...)
+			break
+		}
+	}
+	c.parent = nil
+}
+
+// BuildThreads 按 JWZ 算法 (https://www.jwz.org/doc/threading.html) 用
+// Message-ID、In-Reply-To、References 归并会话, 再按主题合并断开的会话
+func BuildThreads(msgs []*ThreadMessage) []*Thread {
+	table := make(map[string]*Thread)
+	get := func(id string) *Thread {
+		t, ok := table[id]
+		if !ok {
+			t = &Thread{id: id}
+			table[id] = t
+		}
+		return t
+	}
+
+	for i, m := range msgs {
+		id := m.MessageID
+		// 缺少或重复的 Message-ID 生成一个唯一的
+		if t, ok := table[id]; id == "" || ok && t.Message != nil {
+			id = "dup." + strconv.Itoa(i) + "." + id
+		}
+		node := get(id)
+		node.Message = m
+
+		refs := m.References
+		if len(refs) == 0 && m.InReplyTo != "" {
+			refs = []string{m.InReplyTo}
+		}
+		var prev *Thread
+		for _, ref := range refs {
+			cur := get(ref)
+			if prev != nil && cur.parent == nil && !cur.hasDescendant(prev) {
+				prev.addChild(cur)
+			}
+			prev = cur
+		}
+		// 以本邮件自己的 References 为准, 覆盖之前建立的父子关系
+		if prev != nil && !node.hasDescendant(prev) {
+			prev.addChild(node)
+		} else if prev == nil && node.parent != nil {
+			node.parent.removeChild(node)
+		}
+	}
+
+	var roots []*Thread
+	for _, t := range table {
+		if t.parent == nil {
+			roots = append(roots, t)
+		}
+	}
+	sortThreads(roots)
+	roots = pruneEmpty(roots, true)
+	roots = groupBySubject(roots)
+	sortThreads(roots)
+	return roots
+}
+
+// pruneEmpty 删除没有邮件的空节点, 把它们的子节点提升一层
+func pruneEmpty(list []*Thread, root bool) (out []*Thread) {
+	for _, t := range list {
+		t.Children = pruneEmpty(t.Children, false)
+		if t.Message != nil {
+			out = append(out, t)
+			continue
+		}
+		if len(t.Children) == 0 {
+			continue
+		}
+		// 根上的空节点只有一个子节点时才提升, 否则保留以维持会话
+		if !root || len(t.Children) == 1 {
+			for _, c := range t.Children {
+				c.parent = t.parent
+			}
+			out = append(out, t.Children...)
+			continue
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// groupBySubject 合并主题相同的根节点
+func groupBySubject(roots []*Thread) (out []*Thread) {
+	bySubject := make(map[string]*Thread)
+	for _, t := range roots {
+		subject := BaseSubject(t.Subject())
+		if subject == "" {
+			out = append(out, t)
+			continue
+		}
+		old, ok := bySubject[subject]
+		if !ok {
+			bySubject[subject] = t
+			out = append(out, t)
+			continue
+		}
+		switch {
+		case old.Message == nil && t.Message == nil:
+			for _, c := range append([]*Thread(nil), t.Children...) {
+				old.addChild(c)
+			}
+		case old.Message == nil:
+			old.addChild(t)
+		case isReply(t.Message) && !isReply(old.Message):
+			old.addChild(t)
+		case isReply(old.Message) && !isReply(t.Message):
+			t.addChild(old)
+			bySubject[subject] = t
+			replaceThread(out, old, t)
+		default:
+			// 两个都是原始邮件, 或者都是回复, 放到同一个空节点下
+			holder := &Thread{Children: []*Thread{old, t}}
+			old.parent, t.parent = holder, holder
+			bySubject[subject] = holder
+			replaceThread(out, old, holder)
+		}
+	}
+	return out
+}
+
+func replaceThread(list []*Thread, old, t *Thread) {
+	for i, x := range list {
+		if x == old {
+			list[i] = t
+		}
+	}
+}
+
+func isReply(m *ThreadMessage) bool {
+	return BaseSubject(m.Subject) != strings.TrimSpace(m.Subject)
+}
+
+func sortThreads(list []*Thread) {
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Date().Before(list[j].Date()) })
+	for _, t := range list {
+		sortThreads(t.Children)
+	}
+}
@@ -0,0 +1,173 @@
+package tools
+
+import (
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// UTF7 RFC 2152 定义的 utf-7 编码, golang.org/x/text 中没有提供
+var UTF7 encoding.Encoding = utf7{}
+
+type utf7 struct{}
+
+func (utf7) NewDecoder() *encoding.Decoder {
+	return &encoding.Decoder{Transformer: &utf7Decoder{}}
+}
+
+func (utf7) NewEncoder() *encoding.Encoder {
+	return &encoding.Encoder{Transformer: &utf7Encoder{}}
+}
+
+const utf7Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+type utf7Decoder struct {
+	inBase64 bool
+	bits     uint32
+	nbits    uint
+	high     rune
+}
+
+func (d *utf7Decoder) Reset() {
+	*d = utf7Decoder{}
+}
+
+func (d *utf7Decoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		if len(dst)-nDst < utf8.UTFMax {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		c := src[nSrc]
+		if !d.inBase64 {
+			if c != '+' {
+				if c >= utf8.RuneSelf {
+					c = '?'
+				}
+				dst[nDst] = c
+				nDst++
+				nSrc++
+				continue
+			}
+			if nSrc+1 >= len(src) && !atEOF {
+				return nDst, nSrc, transform.ErrShortSrc
+			}
+			// "+-" 表示字符 '+'
+			if nSrc+1 < len(src) && src[nSrc+1] == '-' {
+				dst[nDst] = '+'
+				nDst++
+				nSrc += 2
+				continue
+			}
+			d.inBase64, d.bits, d.nbits = true, 0, 0
+			nSrc++
+			continue
+		}
+
+		v := base64Index[c]
+		if v == 0xff || c == '-' || c == '_' {
+			// 非 base64 字符结束编码段, '-' 本身被吞掉
+			d.inBase64 = false
+			if c == '-' {
+				nSrc++
+			}
+			continue
+		}
+		nSrc++
+		d.bits = d.bits<<6 | uint32(v)
+		d.nbits += 6
+		if d.nbits < 16 {
+			continue
+		}
+		d.nbits -= 16
+		unit := rune(d.bits >> d.nbits & 0xffff)
+		d.bits &= 1<<d.nbits - 1
+		switch {
+		case utf16.IsSurrogate(unit) && d.high == 0:
+			d.high = unit
+			continue
+		case d.high != 0:
+			unit = utf16.DecodeRune(d.high, unit)
+			d.high = 0
+		}
+		nDst += utf8.EncodeRune(dst[nDst:], unit)
+	}
+	return nDst, nSrc, nil
+}
+
+type utf7Encoder struct {
+	inBase64 bool
+	bits     uint32
+	nbits    uint
+}
+
+func (e *utf7Encoder) Reset() {
+	*e = utf7Encoder{}
+}
+
+// utf7Direct 可以直接输出的字符
+func utf7Direct(r rune) bool {
+	return r >= 0x20 && r < 0x7f && r != '+' && r != '\\' && r != '~' || r == '\t' || r == '\r' || r == '\n'
+}
+
+func (e *utf7Encoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		// 一个字符最多输出: 结束 base64 段 3 字节 + 开始符 1 字节 + 两个 utf-16 单元 6 字节
+		if len(dst)-nDst < 10 {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		if !utf8.FullRune(src[nSrc:]) && !atEOF {
+			return nDst, nSrc, transform.ErrShortSrc
+		}
+		r, size := utf8.DecodeRune(src[nSrc:])
+		nSrc += size
+		if utf7Direct(r) {
+			nDst += e.closeBase64(dst[nDst:])
+			dst[nDst] = byte(r)
+			nDst++
+			continue
+		}
+		if r == '+' && !e.inBase64 {
+			dst[nDst], dst[nDst+1] = '+', '-'
+			nDst += 2
+			continue
+		}
+		if !e.inBase64 {
+			dst[nDst] = '+'
+			nDst++
+			e.inBase64 = true
+		}
+		for _, unit := range utf16.Encode([]rune{r}) {
+			e.bits = e.bits<<16 | uint32(unit)
+			e.nbits += 16
+			for e.nbits >= 6 {
+				e.nbits -= 6
+				dst[nDst] = utf7Alphabet[e.bits>>e.nbits&0x3f]
+				nDst++
+			}
+		}
+	}
+	if atEOF {
+		if len(dst)-nDst < 2 {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		nDst += e.closeBase64(dst[nDst:])
+	}
+	return nDst, nSrc, nil
+}
+
+// closeBase64 输出剩余的比特并以 '-' 结束 base64 段
+func (e *utf7Encoder) closeBase64(dst []byte) (n int) {
+	if !e.inBase64 {
+		return 0
+	}
+	if e.nbits > 0 {
+		dst[n] = utf7Alphabet[e.bits<<(6-e.nbits)&0x3f]
+		n++
+	}
+	dst[n] = '-'
+	n++
+	e.inBase64, e.bits, e.nbits = false, 0, 0
+	return n
+}
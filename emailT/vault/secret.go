@@ -0,0 +1,123 @@
+package vault
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Masked 代替密码返回给调用方的值
+const Masked = "******"
+
+// Secret 密码等敏感字符串. 用 fmt 输出时不为空的值总是显示为 Masked, 包括作为结构体字段时;
+// json 编码时保留原值, 以便保存和传输密文. 需要原值时用 string(s)
+type Secret string
+
+// String 实现 fmt.Stringer
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return Masked
+}
+
+// GoString 实现 fmt.GoStringer, 用于 %#v
+func (s Secret) GoString() string {
+	return `"` + s.String() + `"`
+}
+
+// Format 实现 fmt.Formatter, 所有格式都不输出原值
+func (s Secret) Format(f fmt.State, verb rune) {
+	if verb == 'q' || verb == 'v' && f.Flag('#') {
+		io.WriteString(f, s.GoString())
+		return
+	}
+	io.WriteString(f, s.String())
+}
+
+// known 登记过的敏感字符串, 日志中出现时替换为 Masked
+var known = struct {
+	sync.RWMutex
+	values map[string]bool
+}{values: make(map[string]bool)}
+
+// Register 登记一个需要从日志中去掉的敏感字符串, 登记后一直保留, 只用于进程中长期使用的少量值.
+// 解密出的密码不会自动登记, 出错时用 Redact 或 RedactError 传入
+func Register(secret string) {
+	// 太短的值会误伤正常文本
+	if len(secret) < 4 {
+		return
+	}
+	known.Lock()
+	known.values[secret] = true
+	known.Unlock()
+}
+
+// secretFieldReg 常见的 "password": "xxx"、password=xxx 写法
+var secretFieldReg = regexp.MustCompile(`(?i)("?(?:password|passwd|pwd|secret|access_token|refresh_token|client_secret)"?\s*[:=]\s*"?)([^"\s,&}]+)`)
+
+// Redact 去掉文本中的 secrets、登记过的敏感字符串和密码字段的值
+func Redact(s string, secrets ...string) string {
+	known.RLock()
+	values := make([]string, 0, len(known.values)+len(secrets))
+	for v := range known.values {
+		values = append(values, v)
+	}
+	known.RUnlock()
+	for _, v := range secrets {
+		// 太短的值会误伤正常文本
+		if len(v) >= 4 {
+			values = append(values, v)
+		}
+	}
+	// 先替换长的, 避免一个密码是另一个的子串时只替换了一部分
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, v := range values {
+		s = strings.Replace(s, v, Masked, -1)
+	}
+	return secretFieldReg.ReplaceAllString(s, "${1}"+Masked)
+}
+
+// redactedError 去掉了敏感字符串的错误, 只保存处理后的文本
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// RedactError 包装 err, 错误信息中去掉 secrets 等敏感字符串, 见 Redact.
+// errors.Is 和 errors.As 仍然可以找到原来的错误
+func RedactError(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{msg: Redact(err.Error(), secrets...), err: err}
+}
+
+// redactWriter 写入前去掉敏感字符串
+type redactWriter struct {
+	w io.Writer
+}
+
+// NewRedactWriter 包装日志输出, 如 log.SetOutput(vault.NewRedactWriter(os.Stderr)).
+// 按每次 Write 处理, 适用于一次写一行的日志
+func NewRedactWriter(w io.Writer) io.Writer {
+	return &redactWriter{w: w}
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
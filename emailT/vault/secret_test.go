@@ -0,0 +1,47 @@
+package vault
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecryptDoesNotRegister(t *testing.T) {
+	v, err := Create(filepath.Join(t.TempDir(), "master.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := v.Encrypt("hunter2-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := v.Decrypt(ciphertext)
+	if err != nil || string(plain) != "hunter2-password" {
+		t.Fatalf("Decrypt = %q, %v", string(plain), err)
+	}
+	known.RLock()
+	n := len(known.values)
+	known.RUnlock()
+	if n != 0 {
+		t.Errorf("Decrypt registered %d values", n)
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	cause := errors.New("login hr@example.com with hunter2-password failed")
+	err := RedactError(cause, "hunter2-password", "abc")
+	if strings.Contains(err.Error(), "hunter2") || !strings.Contains(err.Error(), Masked) {
+		t.Errorf("Error() = %q", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Error("errors.Is does not find the original error")
+	}
+	if RedactError(nil, "hunter2-password") != nil {
+		t.Error("RedactError(nil) is not nil")
+	}
+	// 太短的值不替换
+	if got := Redact("abc def", "abc"); got != "abc def" {
+		t.Errorf("Redact short secret = %q", got)
+	}
+}
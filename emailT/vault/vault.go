@@ -0,0 +1,322 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"studyGo/emailT/tools"
+)
+
+// prefix 密文的前缀, 没有该前缀的值按明文处理
+const prefix = "vault:v1:"
+
+var (
+	// ErrUnknownKey 密文使用的主密钥不在密钥文件中
+	ErrUnknownKey = errors.New("vault: unknown master key")
+	// ErrMalformed 不是本包生成的密文
+	ErrMalformed = errors.New("vault: malformed ciphertext")
+)
+
+// Vault 凭据的加密和解密
+type Vault interface {
+	// Encrypt 加密明文, 返回可以直接保存的字符串
+	Encrypt(plaintext string) (string, error)
+	// Decrypt 解密 Encrypt 的结果
+	Decrypt(ciphertext string) (Secret, error)
+}
+
+// IsEncrypted 是否为 Encrypt 生成的密文
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(s, prefix)
+}
+
+// Reveal 解密密文; 不是密文时按明文原样返回, 兼容加密之前保存的密码
+func Reveal(v Vault, s Secret) (string, error) {
+	if !IsEncrypted(string(s)) {
+		return string(s), nil
+	}
+	if v == nil {
+		return "", errors.New("vault: password is encrypted but no master key is loaded")
+	}
+	plain, err := v.Decrypt(string(s))
+	return string(plain), err
+}
+
+// keyFile 主密钥文件的内容
+type keyFile struct {
+	// Current 加密时使用的密钥
+	Current string `json:"current"`
+	// Keys 密钥编号到 base64 的 256 位密钥, 轮换后旧密钥保留用于解密
+	Keys map[string]string `json:"keys"`
+}
+
+// FileVault 信封加密: 每个值用随机的数据密钥 AES-256-GCM 加密, 数据密钥再用
+// 本地文件中的主密钥加密后和密文保存在一起. 轮换主密钥只需要重新加密数据密钥.
+// 可以并发使用
+type FileVault struct {
+	path string
+	mu   sync.RWMutex
+	kf   keyFile
+	keys map[string][]byte
+}
+
+// Open 打开主密钥文件
+func Open(path string) (*FileVault, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	v := &FileVault{path: path}
+	if err = json.Unmarshal(data, &v.kf); err != nil {
+		return nil, fmt.Errorf("vault: %s: %v", path, err)
+	}
+	v.keys = make(map[string][]byte, len(v.kf.Keys))
+	for id, s := range v.kf.Keys {
+		key, err := base64.StdEncoding.DecodeString(s)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("vault: %s: bad key %s", path, id)
+		}
+		v.keys[id] = key
+	}
+	if v.keys[v.kf.Current] == nil {
+		return nil, fmt.Errorf("vault: %s: current key %q not found", path, v.kf.Current)
+	}
+	return v, nil
+}
+
+// Create 生成新的主密钥文件, 权限为 0600; 文件已存在时返回错误
+func Create(path string) (*FileVault, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	v := &FileVault{path: path, kf: keyFile{Keys: make(map[string]string)}, keys: make(map[string][]byte)}
+	if _, err = v.Rotate(); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return v, nil
+}
+
+// OpenOrCreate 打开主密钥文件, 不存在时生成
+func OpenOrCreate(path string) (*FileVault, error) {
+	v, err := Open(path)
+	if os.IsNotExist(err) {
+		return Create(path)
+	}
+	return v, err
+}
+
+// KeyID 当前主密钥的编号
+func (v *FileVault) KeyID() string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.kf.Current
+}
+
+// Rotate 生成新的主密钥作为当前密钥并写入文件, 返回新密钥的编号.
+// 旧密钥保留, 已有的密文仍然可以解密, 用 Rewrap 改用新密钥
+func (v *FileVault) Rotate() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	idb := make([]byte, 4)
+	if _, err := rand.Read(idb); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(idb)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	kf := keyFile{Current: id, Keys: map[string]string{id: base64.StdEncoding.EncodeToString(key)}}
+	for k, s := range v.kf.Keys {
+		kf.Keys[k] = s
+	}
+	if err := v.save(kf); err != nil {
+		return "", err
+	}
+	v.kf = kf
+	v.keys[id] = key
+	return id, nil
+}
+
+// RemoveKey 删除不再使用的旧主密钥, 不能删除当前密钥.
+// 删除前必须用 Rewrap 处理全部密文, 否则这些密文无法再解密
+func (v *FileVault) RemoveKey(id string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if id == v.kf.Current {
+		return errors.New("vault: cannot remove the current key")
+	}
+	if _, ok := v.kf.Keys[id]; !ok {
+		return ErrUnknownKey
+	}
+	kf := keyFile{Current: v.kf.Current, Keys: make(map[string]string)}
+	for k, s := range v.kf.Keys {
+		if k != id {
+			kf.Keys[k] = s
+		}
+	}
+	if err := v.save(kf); err != nil {
+		return err
+	}
+	v.kf = kf
+	delete(v.keys, id)
+	return nil
+}
+
+// Encrypt 用新的数据密钥加密, 数据密钥用当前主密钥加密
+func (v *FileVault) Encrypt(plaintext string) (string, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	data, err := seal(dek, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+	v.mu.RLock()
+	id := v.kf.Current
+	wrapped, err := seal(v.keys[id], dek, []byte(id))
+	v.mu.RUnlock()
+	if err != nil {
+		return "", err
+	}
+	return join(id, wrapped, data), nil
+}
+
+// Decrypt 解密. 明文不会登记到 Redact, 调用方需要时自己去掉
+func (v *FileVault) Decrypt(ciphertext string) (Secret, error) {
+	id, wrapped, data, err := split(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	dek, err := v.unwrap(id, wrapped)
+	if err != nil {
+		return "", err
+	}
+	plain, err := open(dek, data, nil)
+	if err != nil {
+		return "", err
+	}
+	return Secret(plain), nil
+}
+
+// Rewrap 用当前主密钥重新加密数据密钥, 数据部分不变. 已经使用当前密钥时原样返回;
+// 传入明文时加密后返回
+func (v *FileVault) Rewrap(ciphertext string) (string, error) {
+	if !IsEncrypted(ciphertext) {
+		return v.Encrypt(ciphertext)
+	}
+	id, wrapped, data, err := split(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	current := v.KeyID()
+	if id == current {
+		return ciphertext, nil
+	}
+	dek, err := v.unwrap(id, wrapped)
+	if err != nil {
+		return "", err
+	}
+	v.mu.RLock()
+	wrapped, err = seal(v.keys[current], dek, []byte(current))
+	v.mu.RUnlock()
+	if err != nil {
+		return "", err
+	}
+	return join(current, wrapped, data), nil
+}
+
+func (v *FileVault) unwrap(id string, wrapped []byte) ([]byte, error) {
+	v.mu.RLock()
+	key := v.keys[id]
+	v.mu.RUnlock()
+	if key == nil {
+		return nil, fmt.Errorf("%w %s", ErrUnknownKey, id)
+	}
+	return open(key, wrapped, []byte(id))
+}
+
+// save 写入密钥文件, 先写临时文件再改名
+func (v *FileVault) save(kf keyFile) error {
+	data, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return tools.WriteFileAtomic(v.path, data, 0600)
+}
+
+// join 密文格式: vault:v1:<主密钥编号>:<加密的数据密钥>:<加密的数据>
+func join(id string, wrapped, data []byte) string {
+	enc := base64.RawURLEncoding
+	return prefix + id + ":" + enc.EncodeToString(wrapped) + ":" + enc.EncodeToString(data)
+}
+
+func split(s string) (id string, wrapped, data []byte, err error) {
+	if !IsEncrypted(s) {
+		return "", nil, nil, ErrMalformed
+	}
+	parts := strings.Split(s[len(prefix):], ":")
+	if len(parts) != 3 || parts[0] == "" {
+		return "", nil, nil, ErrMalformed
+	}
+	enc := base64.RawURLEncoding
+	if wrapped, err = enc.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	if data, err = enc.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	return parts[0], wrapped, data, nil
+}
+
+// seal AES-GCM 加密, 随机 nonce 放在密文前面
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key, ciphertext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, ErrMalformed
+	}
+	n := gcm.NonceSize()
+	plain, err := gcm.Open(nil, ciphertext[:n], ciphertext[n:], aad)
+	if err != nil {
+		return nil, errors.New("vault: decryption failed, wrong key or corrupted data")
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -5,7 +5,8 @@ go 1.15
 require (
 	git.lieni.com/bigdata/kit v0.0.0-20201207075419-bb4a427a2ce1
 	github.com/PuerkitoBio/goquery v1.5.1
-	github.com/antchfx/htmlquery v1.2.3 // indirect
+	github.com/andybalholm/cascadia v1.1.0
+	github.com/antchfx/htmlquery v1.2.3
 	github.com/antchfx/xmlquery v1.3.3 // indirect
 	github.com/axgle/mahonia v0.0.0-20180208002826-3358181d7394
 	github.com/bitly/go-simplejson v0.5.0
@@ -15,6 +16,7 @@ require (
 	github.com/dop251/goja v0.0.0-20210111190058-952c20e23c35
 	github.com/emersion/go-imap v1.0.6
 	github.com/emersion/go-message v0.14.0
+	github.com/emersion/go-sasl v0.0.0-20191210011802-430746ea8b9b
 	github.com/go-clog/clog v1.2.0
 	github.com/go-sourcemap/sourcemap v2.1.3+incompatible // indirect
 	github.com/gobwas/glob v0.2.3 // indirect
@@ -23,9 +25,13 @@ require (
 	github.com/hsyan2008/go-logger v0.0.0-20201030135914-f6dbda938bed
 	github.com/kennygrant/sanitize v1.2.4 // indirect
 	github.com/robertkrimen/otto v0.0.0-20200922221731-ef014fd054ac
-	github.com/saintfish/chardet v0.0.0-20120816061221-3af4cd4741ca // indirect
+	github.com/saintfish/chardet v0.0.0-20120816061221-3af4cd4741ca
 	github.com/tebeka/selenium v0.9.9
 	github.com/temoto/robotstxt v1.1.1 // indirect
+	go.mozilla.org/pkcs7 v0.9.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	golang.org/x/net v0.0.0-20200813134508-3edf25e44fcc
+	golang.org/x/text v0.3.5-0.20201125200606-c27b9fd57aec
 	google.golang.org/grpc v1.34.0
 	google.golang.org/grpc/cmd/protoc-gen-go-grpc v1.0.1 // indirect
 	google.golang.org/protobuf v1.25.0
@@ -1,49 +1,61 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"studyGo/emailT/registry"
+	"time"
 )
 
+// 账号服务的客户端, 超时、重试和熔断见 httpclient
+var emails = registry.NewClient("http://" + registry.DefaultAddr)
+
 // 添加账号
 func AddEmail() {
-	emailData := make(map[string]string)
-	emailData["password"] = "password"
-	emailData["user_name"] = "user_nameeeee"
-	// emailData["server"] = "imap.exmail.qq.com:993"
-	emailData["last_time"] = "2020-01-02T15:04:05Z"
-	// emailData["is_deleted"] = "1"
+	lastTime, _ := time.Parse(time.RFC3339, "2020-01-02T15:04:05Z")
+	a, err := emails.Add(&registry.Account{
+		UserName: "user_nameeeee",
+		Password: "password",
+		// Server 为空时账号服务按邮箱地址自动查找, 见 discoverEmail
+		// Server: "imap.exmail.qq.com:993",
+		LastTime: lastTime,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(a.ID, a.UserName)
+}
 
-	b, _ := json.Marshal(emailData)
-	req, _ := http.NewRequest("POST", "http://127.0.0.1:63334/emails/Add", bytes.NewBuffer(b))
-	client := &http.Client{}
-	resp, _ := client.Do(req)
-	body, _ := ioutil.ReadAll(resp.Body)
-	fmt.Println(string(body))
+// 查找邮箱的服务器地址
+func discoverEmail() {
+	s, err := emails.Discover("user@example.com")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(s.Addr(), s.TLS, s.Source)
 }
 
 // 修改账号
 func saveEmail() {
-	emailData := make(map[string]string)
-	emailData["id"] = "46"
-	emailData["password"] = "passwordddd"
-	emailData["user_name"] = "user_nameeeee1"
-	emailData["server"] = "imap.exmail.qq.com:993"
-	emailData["last_time"] = "2020-01-02T15:04:05Z"
-	emailData["is_deleted"] = "0"
-
-	b, _ := json.Marshal(emailData)
-	req, _ := http.NewRequest("POST", "http://127.0.0.1:63334/emails/Save", bytes.NewBuffer(b))
-	client := &http.Client{}
-	resp, _ := client.Do(req)
-	body, _ := ioutil.ReadAll(resp.Body)
-	fmt.Println(string(body))
+	lastTime, _ := time.Parse(time.RFC3339, "2020-01-02T15:04:05Z")
+	a, err := emails.Save(&registry.Account{
+		ID:        46,
+		UserName:  "user_nameeeee1",
+		Password:  "passwordddd",
+		Server:    "imap.exmail.qq.com:993",
+		LastTime:  lastTime,
+		IsDeleted: false,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(a.ID, a.UserName)
 }
 
 func main() {
 	saveEmail()
 	// AddEmail()
+	// discoverEmail()
 }
@@ -0,0 +1,106 @@
+package httpclient
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 熔断器的默认配置
+const (
+	// DefaultThreshold 连续失败的请求数, Client 的一次 Do 调用无论重试几次都只算一次
+	DefaultThreshold = 5
+	DefaultCooldown  = 30 * time.Second
+)
+
+// Breaker 按主机熔断. 一个主机连续失败 Threshold 次后断开, Cooldown 之后放行一个试探请求,
+// 试探成功时恢复, 失败时再断开 Cooldown. 零值可用
+type Breaker struct {
+	// Threshold 连续失败多少次后断开, 为 0 时使用 DefaultThreshold
+	Threshold int
+	// Cooldown 断开的时间, 为 0 时使用 DefaultCooldown
+	Cooldown time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+// hostState 一个主机的熔断状态
+type hostState struct {
+	failures  int
+	openUntil time.Time
+	// probing 断开之后已经放行了试探请求, 等待结果
+	probing bool
+}
+
+// Allow 是否可以向主机发送请求, 熔断期间返回包装了 ErrCircuitOpen 的错误
+func (b *Breaker) Allow(host string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h := b.hosts[host]
+	if h == nil || h.failures < b.threshold() {
+		return nil
+	}
+	if now := time.Now(); now.Before(h.openUntil) {
+		return fmt.Errorf("%w for %s until %s", ErrCircuitOpen, host, h.openUntil.Format("15:04:05"))
+	}
+	if h.probing {
+		return fmt.Errorf("%w for %s, waiting for probe", ErrCircuitOpen, host)
+	}
+	h.probing = true
+	return nil
+}
+
+// Success 记录一次成功, 清除主机的失败次数
+func (b *Breaker) Success(host string) {
+	b.mu.Lock()
+	delete(b.hosts, host)
+	b.mu.Unlock()
+}
+
+// Failure 记录一次失败, 达到 Threshold 或试探失败时断开
+func (b *Breaker) Failure(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.hosts == nil {
+		b.hosts = make(map[string]*hostState)
+	}
+	h := b.hosts[host]
+	if h == nil {
+		h = &hostState{}
+		b.hosts[host] = h
+	}
+	h.failures++
+	h.probing = false
+	if h.failures >= b.threshold() {
+		cooldown := b.Cooldown
+		if cooldown <= 0 {
+			cooldown = DefaultCooldown
+		}
+		h.openUntil = time.Now().Add(cooldown)
+	}
+}
+
+// Cancel 请求没有结果就被取消, 不计入成功或失败; 是试探请求时放行下一个试探请求
+func (b *Breaker) Cancel(host string) {
+	b.mu.Lock()
+	if h := b.hosts[host]; h != nil {
+		h.probing = false
+	}
+	b.mu.Unlock()
+}
+
+// Open 主机当前是否处于断开状态
+func (b *Breaker) Open(host string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h := b.hosts[host]
+	return h != nil && h.failures >= b.threshold() && time.Now().Before(h.openUntil)
+}
+
+func (b *Breaker) threshold() int {
+	if b.Threshold <= 0 {
+		return DefaultThreshold
+	}
+	return b.Threshold
+}
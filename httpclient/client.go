@@ -0,0 +1,288 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 客户端的默认配置
+const (
+	DefaultTimeout       = 30 * time.Second
+	DefaultMaxRetries    = 3
+	DefaultMinBackoff    = 200 * time.Millisecond
+	DefaultMaxBackoff    = 10 * time.Second
+	DefaultMaxRetryAfter = time.Minute
+	DefaultMaxBodySize   = 32 << 20
+)
+
+// Response 读取了响应体的响应, 连接已经释放
+type Response struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte
+}
+
+// JSON 把响应体解析到 v
+func (r *Response) JSON(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// Client 带超时、重试和熔断的 HTTP 客户端.
+//
+// 每次请求单独超时, 包括读取响应体; 总的时间由请求的 context 控制.
+// 幂等请求(GET、HEAD、OPTIONS、PUT、DELETE, 以及用 Idempotent 标记的请求)在网络错误、
+// 5xx 和 429 时按指数退避加随机抖动重试, 响应有 Retry-After 时按它等待.
+// 其它请求只在连接没有建立和 429 时重试, 避免重复提交
+type Client struct {
+	// HTTPClient 发送请求的客户端, 为空时使用 http.DefaultClient. 它自己的 Timeout 仍然生效
+	HTTPClient *http.Client
+	// Timeout 每次请求的超时, 为 0 时使用 DefaultTimeout
+	Timeout time.Duration
+	// MaxRetries 最多重试次数, 为 0 时使用 DefaultMaxRetries, 小于 0 时不重试
+	MaxRetries int
+	// MinBackoff、MaxBackoff 第一次重试前和每次重试前最多等待的时间, 为 0 时使用默认值
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxRetryAfter Retry-After 超过该时间时不再重试, 为 0 时使用 DefaultMaxRetryAfter
+	MaxRetryAfter time.Duration
+	// MaxBodySize 响应体的最大字节数, 为 0 时使用 DefaultMaxBodySize
+	MaxBodySize int64
+	// Breaker 按主机熔断, 为空时不熔断
+	Breaker *Breaker
+}
+
+// New 创建使用默认配置和熔断器的客户端
+func New() *Client {
+	return &Client{Breaker: &Breaker{}}
+}
+
+// idempotentKey Idempotent 在 context 中的标记
+type idempotentKey struct{}
+
+// Idempotent 标记用 ctx 发送的请求可以安全地重复发送, 如按 id 覆盖保存的 POST 请求
+func Idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// isIdempotent 请求是否可以重复发送
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	v, _ := req.Context().Value(idempotentKey{}).(bool)
+	return v
+}
+
+// Get 发送 GET 请求
+func (c *Client) Get(ctx context.Context, url string) (*Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req.WithContext(ctx))
+}
+
+// Post 发送 POST 请求, body 为请求体
+func (c *Client) Post(ctx context.Context, url, contentType string, body []byte) (*Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.Do(req.WithContext(ctx))
+}
+
+// Do 发送请求并读取响应体. 状态码不是 2xx 时返回 *StatusError, 同时返回响应,
+// 以便调用方解析错误信息. 请求体需要能用 req.GetBody 重新读取才会重试,
+// http.NewRequest 对 bytes.Reader、bytes.Buffer 和 strings.Reader 会自动设置
+func (c *Client) Do(req *http.Request) (*Response, error) {
+	if c.Breaker != nil {
+		if err := c.Breaker.Allow(req.URL.Host); err != nil {
+			return nil, err
+		}
+	}
+	resp, attempts, err := c.do(req)
+	c.record(req, resp, err)
+	return resp, withAttempts(err, attempts)
+}
+
+// do 发送请求, 失败时按需重试, 返回最后一次的结果和发送的次数
+func (c *Client) do(req *http.Request) (resp *Response, attempts int, err error) {
+	ctx := req.Context()
+	retries := c.MaxRetries
+	if retries == 0 {
+		retries = DefaultMaxRetries
+	}
+	if req.Body != nil && req.GetBody == nil {
+		retries = 0
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err = c.send(req, attempt)
+		var serr *StatusError
+		if err == nil && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+			serr = newStatusError(req, resp)
+			serr.Attempts = attempt
+			err = serr
+		}
+		if err == nil || attempt > retries || ctx.Err() != nil || !c.retryable(req, resp, err) {
+			return resp, attempt, err
+		}
+		wait := c.backoff(attempt)
+		if serr != nil && serr.RetryAfter > 0 {
+			if serr.RetryAfter > c.maxRetryAfter() {
+				return resp, attempt, err
+			}
+			wait = serr.RetryAfter
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return resp, attempt, err
+		case <-t.C:
+		}
+	}
+}
+
+// withAttempts 发送了多次仍然失败时在错误中注明次数, StatusError 自己带有次数
+func withAttempts(err error, n int) error {
+	var serr *StatusError
+	if err == nil || n == 1 || errors.As(err, &serr) {
+		return err
+	}
+	return fmt.Errorf("%w (after %d attempts)", err, n)
+}
+
+// send 发送一次请求, 超时包括读取响应体
+func (c *Client) send(req *http.Request, attempt int) (*Response, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+	r := req.WithContext(ctx)
+	if attempt > 1 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	maxSize := c.MaxBodySize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBodySize
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: read body: %w", req.Method, req.URL.Redacted(), err)
+	}
+	if int64(len(body)) > maxSize {
+		return nil, fmt.Errorf("%s %s: %w, limit %d bytes", req.Method, req.URL.Redacted(), ErrBodyTooLarge, maxSize)
+	}
+	return &Response{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header, Body: body}, nil
+}
+
+// record 记录一次 Do 的最终结果: 网络错误和 5xx 计为主机的一次失败, 重试过程中的失败不单独计入;
+// 其它响应说明主机正常. 调用方取消的请求不计入
+func (c *Client) record(req *http.Request, resp *Response, err error) {
+	if c.Breaker == nil {
+		return
+	}
+	host := req.URL.Host
+	if resp == nil && req.Context().Err() != nil {
+		c.Breaker.Cancel(host)
+		return
+	}
+	if resp == nil && err != nil || resp != nil && resp.StatusCode >= 500 {
+		c.Breaker.Failure(host)
+		return
+	}
+	if resp != nil {
+		c.Breaker.Success(host)
+	}
+}
+
+// retryable 出错的请求是否可以重试
+func (c *Client) retryable(req *http.Request, resp *Response, err error) bool {
+	if resp != nil {
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return true
+		}
+		return resp.StatusCode >= 500 && isIdempotent(req)
+	}
+	if errors.Is(err, ErrBodyTooLarge) {
+		return false
+	}
+	if isIdempotent(req) {
+		return true
+	}
+	// 连接没有建立时服务器没有收到请求, 任何请求都可以重试
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// backoff 第 attempt 次失败后的等待时间: 指数增长, 在一半到全部之间随机
+func (c *Client) backoff(attempt int) time.Duration {
+	first, limit := c.MinBackoff, c.MaxBackoff
+	if first <= 0 {
+		first = DefaultMinBackoff
+	}
+	if limit <= 0 {
+		limit = DefaultMaxBackoff
+	}
+	d := first
+	for i := 1; i < attempt && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (c *Client) maxRetryAfter() time.Duration {
+	if c.MaxRetryAfter <= 0 {
+		return DefaultMaxRetryAfter
+	}
+	return c.MaxRetryAfter
+}
+
+// retryAfter 解析 Retry-After, 秒数或 HTTP 日期; 没有或无法解析时为 0
+func retryAfter(h http.Header, now time.Time) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
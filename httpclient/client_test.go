@@ -0,0 +1,187 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer 按 status 返回状态码的服务器, 返回收到的请求数
+func newTestServer(t *testing.T, status func(n int32, w http.ResponseWriter) int) (*httptest.Server, *int32) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		ioutil.ReadAll(r.Body)
+		w.WriteHeader(status(n, w))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+// fastClient 重试几乎不等待的客户端
+func fastClient() *Client {
+	return &Client{MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+}
+
+func TestGetRetries5xx(t *testing.T) {
+	srv, requests := newTestServer(t, func(int32, http.ResponseWriter) int { return http.StatusServiceUnavailable })
+	_, err := fastClient().Get(context.Background(), srv.URL)
+	if !IsStatus(err, http.StatusServiceUnavailable) {
+		t.Fatalf("err = %v, want 503", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Errorf("%d requests, want 1 + MaxRetries", n)
+	}
+	var serr *StatusError
+	if errors.As(err, &serr) && serr.Attempts != 3 {
+		t.Errorf("Attempts = %d, want 3", serr.Attempts)
+	}
+
+	// 重试后成功
+	srv, requests = newTestServer(t, func(n int32, _ http.ResponseWriter) int {
+		if n < 3 {
+			return http.StatusBadGateway
+		}
+		return http.StatusOK
+	})
+	if _, err = fastClient().Get(context.Background(), srv.URL); err != nil || atomic.LoadInt32(requests) != 3 {
+		t.Errorf("get: %v after %d requests, want success on the third", err, atomic.LoadInt32(requests))
+	}
+}
+
+func TestPostRetries(t *testing.T) {
+	tests := []struct {
+		status int
+		want   int32
+	}{
+		// POST 可能已经被处理, 5xx 不重试
+		{http.StatusInternalServerError, 1},
+		// 429 说明服务器没有处理
+		{http.StatusTooManyRequests, 3},
+	}
+	for _, tt := range tests {
+		srv, requests := newTestServer(t, func(int32, http.ResponseWriter) int { return tt.status })
+		_, err := fastClient().Post(context.Background(), srv.URL, "application/json", []byte(`{}`))
+		if !IsStatus(err, tt.status) {
+			t.Errorf("%d: err = %v", tt.status, err)
+		}
+		if n := atomic.LoadInt32(requests); n != tt.want {
+			t.Errorf("%d: %d requests, want %d", tt.status, n, tt.want)
+		}
+	}
+
+	// 标记为幂等的 POST 在 5xx 时重试
+	srv, requests := newTestServer(t, func(int32, http.ResponseWriter) int { return http.StatusInternalServerError })
+	fastClient().Post(Idempotent(context.Background()), srv.URL, "application/json", []byte(`{}`))
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Errorf("idempotent post: %d requests, want 3", n)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	srv, requests := newTestServer(t, func(n int32, w http.ResponseWriter) int {
+		if n == 1 {
+			w.Header().Set("Retry-After", "1")
+			return http.StatusTooManyRequests
+		}
+		return http.StatusOK
+	})
+	start := time.Now()
+	if _, err := fastClient().Get(context.Background(), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("retried after %v, want Retry-After 1s", d)
+	}
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+
+	// 超过 MaxRetryAfter 时直接返回
+	srv, requests = newTestServer(t, func(n int32, w http.ResponseWriter) int {
+		w.Header().Set("Retry-After", "120")
+		return http.StatusServiceUnavailable
+	})
+	c := fastClient()
+	c.MaxRetryAfter = time.Second
+	_, err := c.Get(context.Background(), srv.URL)
+	var serr *StatusError
+	if !errors.As(err, &serr) || serr.RetryAfter != 2*time.Minute {
+		t.Fatalf("err = %v, want 503 with Retry-After 2m", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("%d requests, want no retry", n)
+	}
+}
+
+func TestBodyWithoutGetBody(t *testing.T) {
+	srv, requests := newTestServer(t, func(int32, http.ResponseWriter) int { return http.StatusServiceUnavailable })
+	// 包装之后 http.NewRequest 无法设置 GetBody, 请求体不能重新读取
+	req, err := http.NewRequest(http.MethodPut, srv.URL, ioutil.NopCloser(strings.NewReader("data")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fastClient().Do(req); !IsStatus(err, http.StatusServiceUnavailable) {
+		t.Fatalf("err = %v, want 503", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("%d requests, want no retry", n)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	var healthy int32
+	srv, requests := newTestServer(t, func(int32, http.ResponseWriter) int {
+		if atomic.LoadInt32(&healthy) == 1 {
+			return http.StatusOK
+		}
+		return http.StatusServiceUnavailable
+	})
+	c := fastClient()
+	c.Breaker = &Breaker{Threshold: 2, Cooldown: 50 * time.Millisecond}
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	// 一次 Do 的多次重试只算一次失败
+	c.Get(context.Background(), srv.URL)
+	if c.Breaker.Open(host) {
+		t.Fatal("breaker opened after one call")
+	}
+	c.Get(context.Background(), srv.URL)
+	if !c.Breaker.Open(host) {
+		t.Fatal("breaker still closed after two failed calls")
+	}
+	sent := atomic.LoadInt32(requests)
+	if _, err := c.Get(context.Background(), srv.URL); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want ErrCircuitOpen", err)
+	}
+	if atomic.LoadInt32(requests) != sent {
+		t.Error("request sent while the breaker was open")
+	}
+
+	// 冷却之后只放行一个试探请求
+	time.Sleep(60 * time.Millisecond)
+	if err := c.Breaker.Allow(host); err != nil {
+		t.Fatalf("probe not allowed: %v", err)
+	}
+	if err := c.Breaker.Allow(host); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("second request during probe: err = %v, want ErrCircuitOpen", err)
+	}
+	c.Breaker.Cancel(host)
+
+	atomic.StoreInt32(&healthy, 1)
+	if _, err := c.Get(context.Background(), srv.URL); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if c.Breaker.Open(host) {
+		t.Error("breaker still open after a successful probe")
+	}
+	if _, err := c.Get(context.Background(), srv.URL); err != nil {
+		t.Errorf("after probe: %v", err)
+	}
+}
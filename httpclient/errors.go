@@ -0,0 +1,70 @@
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrCircuitOpen 主机连续失败, 熔断期间不发送请求
+var ErrCircuitOpen = errors.New("httpclient: circuit open")
+
+// ErrBodyTooLarge 响应体超过 Client.MaxBodySize
+var ErrBodyTooLarge = errors.New("httpclient: response body too large")
+
+// snippetSize StatusError 中保留的响应体长度
+const snippetSize = 512
+
+// StatusError 响应的状态码不是 2xx
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	// Snippet 响应体的开头部分
+	Snippet string
+	// RetryAfter 响应的 Retry-After, 没有时为 0
+	RetryAfter time.Duration
+	// Attempts 一共发送的次数
+	Attempts int
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Status)
+	if e.Attempts > 1 {
+		msg += fmt.Sprintf(" (after %d attempts)", e.Attempts)
+	}
+	if e.Snippet != "" {
+		msg += ": " + e.Snippet
+	}
+	return msg
+}
+
+// Temporary 5xx 和 429 为临时错误, 稍后可以重试
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// newStatusError 截取响应体的开头, 去掉截断的多字节字符
+func newStatusError(req *http.Request, resp *Response) *StatusError {
+	snippet := resp.Body
+	if len(snippet) > snippetSize {
+		snippet = snippet[:snippetSize]
+	}
+	return &StatusError{
+		Method:     req.Method,
+		URL:        req.URL.Redacted(),
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Snippet:    strings.TrimSpace(strings.ToValidUTF8(string(snippet), "")),
+		RetryAfter: retryAfter(resp.Header, time.Now()),
+	}
+}
+
+// IsStatus err 是否为状态码为 code 的 *StatusError
+func IsStatus(err error, code int) bool {
+	var serr *StatusError
+	return errors.As(err, &serr) && serr.StatusCode == code
+}
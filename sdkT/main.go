@@ -2,18 +2,28 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"studyGo/httpclient"
+	"time"
 )
 
+// 解析一份简历的时间较长, 单次请求的超时放宽
+var client = &httpclient.Client{Timeout: 2 * time.Minute, Breaker: &httpclient.Breaker{}}
+
 func doResumeSDK() error {
 	// 线上需要换成内网 ip : 172.23.201.53
 	ResumeSDKURL := "http://101.37.18.181:2015/api/ResumeParser"
 
-	b, _ := ioutil.ReadFile("./aa.pdf")
+	b, err := ioutil.ReadFile("./aa.pdf")
+	if err != nil {
+		return err
+	}
 	encodeString := base64.StdEncoding.EncodeToString(b)
 	resume := make(map[string]interface{})
 	resume["base_cont"] = encodeString
@@ -22,26 +32,32 @@ func doResumeSDK() error {
 	resume["pwd"] = "538610"
 
 	bytesData, err := json.Marshal(resume)
-	req, err := http.NewRequest("POST", ResumeSDKURL, bytes.NewBuffer(bytesData))
+	if err != nil {
+		return err
+	}
+	// 解析简历没有副作用, 失败时可以重试
+	ctx := httpclient.Idempotent(context.Background())
+	req, err := http.NewRequest("POST", ResumeSDKURL, bytes.NewReader(bytesData))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", `application/json`)
 	req.Header.Set("Authentication", `Basic username="admin",password="2015"`)
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
 	}
-
-	body, _ := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close()
-	fmt.Println(string(body))
+	fmt.Println(string(resp.Body))
 
 	return nil
 }
 
 func main() {
 	err := doResumeSDK()
+	var serr *httpclient.StatusError
+	if errors.As(err, &serr) {
+		fmt.Println("resume sdk status: ", serr.StatusCode, serr.Snippet)
+		return
+	}
 	fmt.Println(err)
 }